@@ -0,0 +1,41 @@
+package cmd
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+func NewAlertsCmd(app *GtfsCtlApp) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "alerts",
+		Short: "Inspect service alerts currently in effect",
+		RunE:  app.DoAlerts,
+		Args:  cobra.NoArgs,
+	}
+	cmd.Flags().String("route", "", "Only list alerts affecting this route")
+
+	return cmd
+}
+
+func (app *GtfsCtlApp) DoAlerts(cmd *cobra.Command, args []string) error {
+	route, _ := cmd.Flags().GetString("route")
+
+	repo, closeRepo, err := app.OpenRepository()
+	if err != nil {
+		return err
+	}
+	defer closeRepo()
+
+	alerts, err := repo.ActiveAlerts(app.Context, route, time.Now())
+	if err != nil {
+		return err
+	}
+	for _, alert := range alerts {
+		fmt.Printf("[%s] %s (%s)\n", strings.Join(alert.RouteIDs, ","), alert.HeaderText, alert.Effect)
+	}
+	fmt.Printf("%d alert(s)\n", len(alerts))
+	return nil
+}
@@ -2,19 +2,47 @@ package cmd
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/spf13/cobra"
 )
 
 func NewArrivalsCmd(app *GtfsCtlApp) *cobra.Command {
 	cmd := &cobra.Command{
-		Use:   "arrivals",
+		Use:   "arrivals <stop_id>",
 		Short: "Inspect recent trains arrivals",
-		RunE: func(cmd *cobra.Command, args []string) error {
-			fmt.Println("Arrivals: NYI")
-			return nil
-		},
+		Long:  "Lists the next trains predicted at a stop, or at any platform of a parent station",
+		RunE:  app.DoArrivals,
+		Args:  cobra.ExactArgs(1),
 	}
+	cmd.Flags().Int("limit", 10, "Number of arrivals to list")
 
 	return cmd
 }
+
+func (app *GtfsCtlApp) DoArrivals(cmd *cobra.Command, args []string) error {
+	limit, _ := cmd.Flags().GetInt("limit")
+
+	repo, closeRepo, err := app.OpenRepository()
+	if err != nil {
+		return err
+	}
+	defer closeRepo()
+
+	now := time.Now()
+	arrivals, err := repo.UpcomingArrivals(app.Context, args[0], now, limit)
+	if err != nil {
+		return err
+	}
+	for _, arrival := range arrivals {
+		fmt.Printf(
+			"%s  %3dm  %-4s %-28s %-24s %s\n",
+			formatClock(arrival.At()), int(arrival.At().Sub(now).Minutes()),
+			arrival.RouteID, arrival.Headsign, arrival.TripID, arrival.StopID,
+		)
+	}
+	if len(arrivals) == 0 {
+		fmt.Printf("No trains predicted at %s\n", args[0])
+	}
+	return nil
+}
@@ -11,6 +11,13 @@ func NewCaptureCmd(app *GtfsCtlApp) *cobra.Command {
 
 	cmd.AddCommand(NewRecordCmd(app))
 	cmd.AddCommand(NewPlaybackCmd(app))
+	cmd.AddCommand(NewServeCmd(app))
+	cmd.AddCommand(NewExportCmd(app))
+	cmd.AddCommand(NewVerifyCmd(app))
+	cmd.AddCommand(NewTrimCmd(app))
+	cmd.AddCommand(NewSplitCmd(app))
+	cmd.AddCommand(NewMergeCmd(app))
+	cmd.AddCommand(NewReplayDeadLettersCmd(app))
 
 	return cmd
 }
@@ -0,0 +1,230 @@
+package cmd
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/spf13/cobra"
+	database "tarediiran-industries.com/gtfs-services/internal/db"
+)
+
+func NewDbCmd(app *GtfsCtlApp) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "db",
+		Short: "Maintain the GTFS database",
+	}
+
+	cmd.AddCommand(NewMigrateCmd(app))
+	cmd.AddCommand(NewPruneCmd(app))
+
+	return cmd
+}
+
+func NewMigrateCmd(app *GtfsCtlApp) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "migrate",
+		Short: "Apply or revert the schema migrations built into this binary",
+	}
+
+	up := &cobra.Command{
+		Use:   "up [steps]",
+		Short: "Apply pending migrations, all of them unless a step count is given",
+		RunE:  app.DoMigrateUp,
+		Args:  cobra.MaximumNArgs(1),
+	}
+	down := &cobra.Command{
+		Use:   "down [steps]",
+		Short: "Revert applied migrations, one unless a step count is given",
+		RunE:  app.DoMigrateDown,
+		Args:  cobra.MaximumNArgs(1),
+	}
+	down.Flags().Bool("all", false, "Revert every migration")
+	status := &cobra.Command{
+		Use:   "status",
+		Short: "Show the database schema version and pending migrations",
+		RunE:  app.DoMigrateStatus,
+		Args:  cobra.NoArgs,
+	}
+	force := &cobra.Command{
+		Use:   "force <version>",
+		Short: "Mark a version as applied and clean without running it",
+		RunE:  app.DoMigrateForce,
+		Args:  cobra.ExactArgs(1),
+	}
+
+	cmd.AddCommand(up, down, status, force)
+	return cmd
+}
+
+func parseSteps(args []string, fallback int) (int, error) {
+	if len(args) == 0 {
+		return fallback, nil
+	}
+	steps, err := strconv.Atoi(args[0])
+	if err != nil || steps <= 0 {
+		return 0, fmt.Errorf("steps must be a positive number, got %q", args[0])
+	}
+	return steps, nil
+}
+
+func printMigrations(verb string, migrations []database.Migration) {
+	for _, migration := range migrations {
+		fmt.Printf("%s %03d_%s\n", verb, migration.Version, migration.Name)
+	}
+}
+
+func (app *GtfsCtlApp) DoMigrateUp(cmd *cobra.Command, args []string) error {
+	steps, err := parseSteps(args, 0)
+	if err != nil {
+		return err
+	}
+	migrations, err := database.EmbeddedMigrations()
+	if err != nil {
+		return err
+	}
+
+	db, err := app.Config.NewDatabase(app.Context)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	applied, err := db.MigrateUp(app.Context, migrations, steps)
+	printMigrations("Applied", applied)
+	if err == nil && len(applied) == 0 {
+		fmt.Println("Schema is up to date")
+	}
+	return err
+}
+
+func (app *GtfsCtlApp) DoMigrateDown(cmd *cobra.Command, args []string) error {
+	steps, err := parseSteps(args, 1)
+	if err != nil {
+		return err
+	}
+	all, err := cmd.Flags().GetBool("all")
+	if err != nil {
+		return err
+	}
+	if all {
+		steps = 0
+	}
+	migrations, err := database.EmbeddedMigrations()
+	if err != nil {
+		return err
+	}
+
+	db, err := app.Config.NewDatabase(app.Context)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	reverted, err := db.MigrateDown(app.Context, migrations, steps)
+	printMigrations("Reverted", reverted)
+	return err
+}
+
+func (app *GtfsCtlApp) DoMigrateStatus(cmd *cobra.Command, args []string) error {
+	migrations, err := database.EmbeddedMigrations()
+	if err != nil {
+		return err
+	}
+
+	db, err := app.Config.NewDatabase(app.Context)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	status, err := db.SchemaStatus(app.Context)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Database: %s\n", status)
+	for _, migration := range migrations {
+		state := "pending"
+		if status.HasVersion && migration.Version <= status.Version {
+			state = "applied"
+		}
+		fmt.Printf("  %03d_%-30s %s\n", migration.Version, migration.Name, state)
+	}
+	return nil
+}
+
+func (app *GtfsCtlApp) DoMigrateForce(cmd *cobra.Command, args []string) error {
+	version, err := strconv.ParseUint(args[0], 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid version %q: %w", args[0], err)
+	}
+
+	db, err := app.Config.NewDatabase(app.Context)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	return db.ForceSchemaVersion(app.Context, uint(version))
+}
+
+func NewPruneCmd(app *GtfsCtlApp) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "prune",
+		Short: "Drop days of realtime data older than the retention window",
+		RunE:  app.DoPrune,
+		Args:  cobra.NoArgs,
+	}
+
+	cmd.Flags().Duration("window", 0, "Retention window, overrides [retention] window_hours")
+	cmd.Flags().Bool("rollup", false, "Roll days up into route_daily_summary before dropping them")
+	cmd.Flags().Bool("dry-run", false, "Only list the days that would be dropped")
+
+	return cmd
+}
+
+func (app *GtfsCtlApp) DoPrune(cmd *cobra.Command, args []string) error {
+	opts := app.Config.Retention.Options()
+
+	if cmd.Flags().Changed("window") {
+		window, err := cmd.Flags().GetDuration("window")
+		if err != nil {
+			return err
+		}
+		opts.Window = window
+	}
+	if cmd.Flags().Changed("rollup") {
+		rollup, err := cmd.Flags().GetBool("rollup")
+		if err != nil {
+			return err
+		}
+		opts.Rollup = rollup
+	}
+	dryRun, err := cmd.Flags().GetBool("dry-run")
+	if err != nil {
+		return err
+	}
+	opts.DryRun = dryRun
+
+	if opts.Window <= 0 {
+		return fmt.Errorf("no retention window, set [retention] window_hours or pass --window")
+	}
+
+	db, err := app.Config.NewDatabase(app.Context)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	result, err := db.Prune(app.Context, time.Now(), opts)
+	for _, day := range result.Days {
+		fmt.Println(day.Format(time.DateOnly))
+	}
+	if dryRun {
+		fmt.Printf("Would drop %d day(s) of realtime data\n", len(result.Days))
+	} else {
+		fmt.Printf("Dropped %d day(s) of realtime data, %d route summaries rolled up\n", len(result.Days), result.RolledUp)
+	}
+	return err
+}
@@ -0,0 +1,35 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+	"tarediiran-industries.com/gtfs-services/internal/ingest/gtfs_rt"
+)
+
+func NewReplayDeadLettersCmd(app *GtfsCtlApp) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "replay-dead-letters",
+		Short: "Retry ingesting realtime frames that previously failed to decode or write",
+		RunE:  app.DoReplayDeadLetters,
+		Args:  cobra.NoArgs,
+	}
+
+	cmd.Flags().Bool("keep", false, "Keep dead-letter recordings after replaying them")
+
+	return cmd
+}
+
+func (app *GtfsCtlApp) DoReplayDeadLetters(cmd *cobra.Command, args []string) error {
+	keep, err := cmd.Flags().GetBool("keep")
+	if err != nil {
+		return err
+	}
+
+	stats, err := gtfs_rt.ReplayDeadLetters(app.Context, app.Config, keep)
+	fmt.Printf(
+		"Replayed %d recording(s): %d frame(s), %d ingested, %d failed again, %d dropped as out of order\n",
+		stats.Recordings, stats.Frames, stats.Ingested, stats.Failed, stats.Skipped,
+	)
+	return err
+}
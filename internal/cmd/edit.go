@@ -0,0 +1,167 @@
+package cmd
+
+import (
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"github.com/spf13/cobra"
+	"tarediiran-industries.com/gtfs-services/internal/platform"
+)
+
+func NewTrimCmd(app *GtfsCtlApp) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "trim <recording_name> <output_name>",
+		Short: "Copy the selected frames of a recording into a new recording",
+		Long: "Copy the frames of a recording selected by --from, --to and --feeds into a new " +
+			"recording, renumbered from 1. The new recording lists the original as its source.",
+		RunE: app.DoTrim,
+		Args: cobra.ExactArgs(2),
+	}
+
+	addSelectionFlags(cmd)
+	addContainerFlags(cmd)
+
+	return cmd
+}
+
+func NewSplitCmd(app *GtfsCtlApp) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "split <recording_name> <output_prefix>",
+		Short: "Split a recording into one recording per period",
+		Long: "Split a recording into a new recording for every --every of capture time, counted from " +
+			"midnight where it was recorded. Each part is named after the prefix and the time it starts, " +
+			"periods without frames are skipped.",
+		RunE: app.DoSplit,
+		Args: cobra.ExactArgs(2),
+	}
+
+	cmd.Flags().Duration("every", 0, "Length of each part, e.g. 1h")
+	cmd.MarkFlagRequired("every")
+	addSelectionFlags(cmd)
+	addContainerFlags(cmd)
+
+	return cmd
+}
+
+func NewMergeCmd(app *GtfsCtlApp) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "merge <output_name> <recording_name> <recording_name>...",
+		Short: "Merge recordings into one, interleaving their frames by capture time",
+		Long: "Merge recordings, e.g. captures of different feeds made on two machines, into a new " +
+			"recording with the feeds of all of them. --from and --to are read on the first recording's day.",
+		RunE: app.DoMerge,
+		Args: cobra.MinimumNArgs(3),
+	}
+
+	addSelectionFlags(cmd)
+	addContainerFlags(cmd)
+
+	return cmd
+}
+
+func (app *GtfsCtlApp) DoTrim(cmd *cobra.Command, args []string) error {
+	return app.editRecordings(cmd, args[1], args[:1], 0)
+}
+
+func (app *GtfsCtlApp) DoSplit(cmd *cobra.Command, args []string) error {
+	every, err := cmd.Flags().GetDuration("every")
+	if err != nil {
+		return err
+	}
+	if every <= 0 {
+		return fmt.Errorf("--every must be positive")
+	}
+	return app.editRecordings(cmd, args[1], args[:1], every)
+}
+
+func (app *GtfsCtlApp) DoMerge(cmd *cobra.Command, args []string) error {
+	return app.editRecordings(cmd, args[0], args[1:], 0)
+}
+
+// editRecordings copies the selected frames of the named recordings into output, or into one
+// recording per splitEvery named after output
+func (app *GtfsCtlApp) editRecordings(
+	cmd *cobra.Command, output string, recordingNames []string, splitEvery time.Duration,
+) error {
+	schemaVersion, compression, err := containerSchema(cmd)
+	if err != nil {
+		return err
+	}
+
+	headers := make([]platform.RecordingHeader, 0, len(recordingNames))
+	sources := make([]string, 0, len(recordingNames))
+	for _, name := range recordingNames {
+		header, err := platform.ReadRecordingHeader(filepath.Join(app.Layout.RecordingsDir, name))
+		if err != nil {
+			return err
+		}
+		headers = append(headers, header)
+		sources = append(sources, header.RecordingUID)
+	}
+
+	// The selection flags are read against the first recording, with the feeds of all of them
+	selection := headers[0]
+	selection.Feeds = platform.MergeFeedSpecs(headers, nil)
+	for _, header := range headers[1:] {
+		if header.CreatedAt.Before(selection.CreatedAt) {
+			selection.CreatedAt = header.CreatedAt
+		}
+	}
+	filter, err := playbackFilter(cmd, selection)
+	if err != nil {
+		return err
+	}
+
+	readers := make([]*platform.FeedRecordingReader, 0, len(recordingNames))
+	defer func() {
+		for _, reader := range readers {
+			reader.Close()
+		}
+	}()
+	for _, name := range recordingNames {
+		reader, err := platform.OpenFeedRecording(filepath.Join(app.Layout.RecordingsDir, name))
+		if err != nil {
+			return err
+		}
+		readers = append(readers, reader)
+
+		reader.SetFilter(filter)
+		if !filter.From.IsZero() {
+			if err := reader.SeekTime(filter.From); err != nil {
+				return err
+			}
+		}
+	}
+
+	location := recordingLocation(selection)
+	written, err := platform.WriteEditedRecordings(app.Context, platform.NewFrameMerger(readers...), platform.EditOptions{
+		Header: platform.RecordingHeaderOptions{
+			RecordingName: output,
+			RecordingPath: app.Layout.RecordingsDir,
+			TimeZone:      selection.TimeZone,
+			Tool:          platform.NewToolInfo(cmd.Root().Name()),
+			SchemaVersion: schemaVersion,
+			Compression:   compression,
+			Sources:       sources,
+		},
+		Feeds:      platform.MergeFeedSpecs(headers, filter.FeedIDs),
+		SplitEvery: splitEvery,
+		Location:   location,
+	})
+	if err != nil {
+		return err
+	}
+	if len(written) == 0 {
+		return fmt.Errorf("no frames match the selection, nothing written")
+	}
+
+	for _, recording := range written {
+		fmt.Printf(
+			"%-40s %8d frames  %s to %s\n",
+			filepath.Base(recording.Dir), recording.Frames,
+			recording.First.In(location).Format(time.RFC3339), recording.Last.In(location).Format(time.RFC3339),
+		)
+	}
+	return nil
+}
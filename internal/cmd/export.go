@@ -0,0 +1,110 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"tarediiran-industries.com/gtfs-services/internal/export"
+	"tarediiran-industries.com/gtfs-services/internal/platform"
+)
+
+func NewExportCmd(app *GtfsCtlApp) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "export <recording_name>",
+		Short: "Decode a recording's payloads to NDJSON, CSV or protojson",
+		Long: "Decode a recording's payloads for analysis. ndjson and csv flatten trip updates into one " +
+			"row per stop time update, protojson writes each whole feed message, one frame per line. " +
+			"NYCT extensions are decoded where present.",
+		RunE: app.DoExport,
+		Args: cobra.ExactArgs(1),
+	}
+
+	cmd.Flags().String("format", export.FormatNDJSON, "Output format: "+strings.Join(export.Formats, ", "))
+	cmd.Flags().StringP("output", "o", "", "File to write to, stdout when empty")
+	addSelectionFlags(cmd)
+
+	return cmd
+}
+
+func (app *GtfsCtlApp) DoExport(cmd *cobra.Command, args []string) error {
+	format, err := cmd.Flags().GetString("format")
+	if err != nil {
+		return err
+	}
+	output, err := cmd.Flags().GetString("output")
+	if err != nil {
+		return err
+	}
+
+	recordingPath := filepath.Join(app.Layout.RecordingsDir, args[0])
+	header, err := platform.ReadRecordingHeader(recordingPath)
+	if err != nil {
+		return err
+	}
+	filter, err := playbackFilter(cmd, header)
+	if err != nil {
+		return err
+	}
+
+	var writer io.Writer = os.Stdout
+	if output != "" {
+		file, err := os.Create(output)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		writer = file
+	}
+
+	exporter, err := export.NewExporter(format, writer)
+	if err != nil {
+		return err
+	}
+
+	reader, err := platform.OpenFeedRecording(recordingPath)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	reader.SetFilter(filter)
+	if !filter.From.IsZero() {
+		if err := reader.SeekTime(filter.From); err != nil {
+			return err
+		}
+	}
+
+	frames, skipped := 0, 0
+	for {
+		frame, err := reader.Next(app.Context)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		message, err := export.Decode(frame)
+		if err != nil {
+			log.Printf("skip %v", err)
+			skipped++
+			continue
+		}
+		if err := exporter.Write(frame, message); err != nil {
+			return fmt.Errorf("export %s: %w", frame, err)
+		}
+		frames++
+	}
+
+	if err := exporter.Close(); err != nil {
+		return err
+	}
+	log.Printf("exported %d frames, skipped %d that failed to decode", frames, skipped)
+	return nil
+}
@@ -2,10 +2,17 @@ package cmd
 
 import (
 	"fmt"
+	"log"
+	"os"
+	"os/signal"
 	"path/filepath"
+	"slices"
+	"syscall"
+	"time"
 
 	"github.com/spf13/cobra"
 	"tarediiran-industries.com/gtfs-services/internal/ingest/gtfs_rt"
+	"tarediiran-industries.com/gtfs-services/internal/platform"
 )
 
 func NewPlaybackCmd(app *GtfsCtlApp) *cobra.Command {
@@ -16,8 +23,12 @@ func NewPlaybackCmd(app *GtfsCtlApp) *cobra.Command {
 		Args:  cobra.ExactArgs(1),
 	}
 
-	cmd.Flags().Bool("delays", false, "Respect recorded real-time delays")
+	cmd.Flags().Bool("delays", false, "Respect recorded real-time delays, the same as --speed 1")
+	cmd.Flags().Float64("speed", 0, "Play back this many times faster than recorded, e.g. 0.5, 10 or 60 (0 plays as fast as possible)")
+	cmd.Flags().Bool("loop", false, "Start over once the end of the recording or the --to time is reached")
+	cmd.Flags().Bool("rebase", false, "Shift feed timestamps and predicted stop times so frames look live")
 	cmd.Flags().Bool("list", false, "Respect recorded real-time delays")
+	addSelectionFlags(cmd)
 
 	return cmd
 }
@@ -27,6 +38,89 @@ func (app *GtfsCtlApp) listRecordings() error {
 	return nil
 }
 
+// Flags read by playbackFilter
+func addSelectionFlags(cmd *cobra.Command) {
+	cmd.Flags().String("from", "", "Start at frames captured at this time, as HH:MM[:SS] on the recording's first day or RFC 3339")
+	cmd.Flags().String("to", "", "Stop before frames captured at this time, same formats as --from")
+	cmd.Flags().StringSlice("feeds", nil, "Only play back these feed ids")
+}
+
+// recordingLocation is the time zone a recording was made in, local time when it isn't known
+func recordingLocation(header platform.RecordingHeader) *time.Location {
+	if header.TimeZone != "" {
+		if loaded, err := time.LoadLocation(header.TimeZone); err == nil {
+			return loaded
+		}
+	}
+	return time.Local
+}
+
+// parsePlaybackTime reads a clock time as that time on the day the recording started, in the time
+// zone it was recorded in
+func parsePlaybackTime(header platform.RecordingHeader, value string) (time.Time, error) {
+	if at, err := time.Parse(time.RFC3339, value); err == nil {
+		return at, nil
+	}
+
+	location := recordingLocation(header)
+
+	for _, layout := range []string{"15:04", "15:04:05"} {
+		clock, err := time.ParseInLocation(layout, value, location)
+		if err != nil {
+			continue
+		}
+		day := header.CreatedAt.In(location)
+		return time.Date(
+			day.Year(), day.Month(), day.Day(), clock.Hour(), clock.Minute(), clock.Second(), 0, location,
+		), nil
+	}
+	return time.Time{}, fmt.Errorf("unrecognised time %q, expected HH:MM, HH:MM:SS or RFC 3339", value)
+}
+
+func playbackFilter(cmd *cobra.Command, header platform.RecordingHeader) (platform.FrameFilter, error) {
+	filter := platform.FrameFilter{}
+
+	from, err := cmd.Flags().GetString("from")
+	if err != nil {
+		return filter, err
+	}
+	to, err := cmd.Flags().GetString("to")
+	if err != nil {
+		return filter, err
+	}
+	feeds, err := cmd.Flags().GetStringSlice("feeds")
+	if err != nil {
+		return filter, err
+	}
+
+	if from != "" {
+		if filter.From, err = parsePlaybackTime(header, from); err != nil {
+			return filter, fmt.Errorf("--from: %w", err)
+		}
+	}
+	if to != "" {
+		if filter.To, err = parsePlaybackTime(header, to); err != nil {
+			return filter, fmt.Errorf("--to: %w", err)
+		}
+		// 23:00 to 01:00 crosses midnight
+		if !filter.From.IsZero() && !filter.To.After(filter.From) {
+			filter.To = filter.To.AddDate(0, 0, 1)
+		}
+	}
+
+	for _, feedId := range feeds {
+		recorded := slices.ContainsFunc(header.Feeds, func(feed platform.FeedSpec) bool {
+			return feed.FeedID == feedId
+		})
+		if !recorded {
+			return filter, fmt.Errorf("feed %s is not in this recording", feedId)
+		}
+	}
+	filter.FeedIDs = feeds
+
+	return filter, nil
+}
+
 func (app *GtfsCtlApp) DoPlayback(cmd *cobra.Command, args []string) error {
 	list, err := cmd.Flags().GetBool("list")
 	if err != nil {
@@ -43,14 +137,75 @@ func (app *GtfsCtlApp) DoPlayback(cmd *cobra.Command, args []string) error {
 
 	recordingName := args[0]
 	recordingPath := filepath.Join(app.Layout.RecordingsDir, recordingName)
+	header, err := platform.ReadRecordingHeader(recordingPath)
+	if err != nil {
+		return err
+	}
+	filter, err := playbackFilter(cmd, header)
+	if err != nil {
+		return err
+	}
+
 	playback, err := gtfs_rt.NewFilePlayback(app.Context, app.Config, recordingPath)
 	if err != nil {
 		return err
 	}
 	defer playback.Close()
 
-	if delays {
-		return playback.PlaybackRealtime(app.Context)
+	if err := playback.SetFilter(filter); err != nil {
+		return err
+	}
+
+	speed, err := cmd.Flags().GetFloat64("speed")
+	if err != nil {
+		return err
+	}
+	if delays && speed == 0 {
+		speed = 1
+	}
+	loop, err := cmd.Flags().GetBool("loop")
+	if err != nil {
+		return err
+	}
+	rebase, err := cmd.Flags().GetBool("rebase")
+	if err != nil {
+		return err
+	}
+
+	clock := gtfs_rt.NewPlaybackClock(gtfs_rt.SystemClock, speed)
+	stopPauseToggle := togglePauseOnSignal(clock)
+	defer stopPauseToggle()
+
+	return playback.Play(app.Context, gtfs_rt.PlaybackOptions{
+		Clock:            clock,
+		Loop:             loop,
+		RebaseTimestamps: rebase,
+	})
+}
+
+// togglePauseOnSignal pauses and resumes playback on SIGUSR1 (`kill -USR1 <pid>`)
+func togglePauseOnSignal(clock *gtfs_rt.PlaybackClock) func() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGUSR1)
+	done := make(chan struct{})
+
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			case <-signals:
+				if clock.TogglePause() {
+					log.Printf("playback paused, send SIGUSR1 again to resume")
+				} else {
+					log.Printf("playback resumed")
+				}
+			}
+		}
+	}()
+
+	return func() {
+		signal.Stop(signals)
+		close(done)
 	}
-	return playback.PlaybackFast(app.Context)
 }
@@ -19,6 +19,13 @@ func NewRecordCmd(app *GtfsCtlApp) *cobra.Command {
 		Args:  cobra.ExactArgs(1),
 	}
 
+	addContainerFlags(cmd)
+	cmd.Flags().String("rotate", "", "Start a new recording every hour or day: hourly or daily")
+	cmd.Flags().String("rotate-size", "", "Start a new recording once the current one reaches this size, e.g. 2GiB")
+	cmd.Flags().Duration("stop-after", 0, "Stop recording after this long, e.g. 72h")
+	cmd.Flags().String("quota", "", "Keep everything under the recordings directory within this size, e.g. 50GiB")
+	cmd.Flags().String("quota-action", "delete", "What to do with the oldest recordings over quota: delete, or compress before deleting")
+
 	return cmd
 }
 
@@ -32,7 +39,95 @@ func WritePollResult(
 	return writer.Append(ctx, frame)
 }
 
+// Flags read by containerSchema
+func addContainerFlags(cmd *cobra.Command) {
+	cmd.Flags().String("container", "files", "Recording layout: files for one file per payload, or segmented")
+	cmd.Flags().String("compress", platform.CompressionZstd, "Payload compression for segmented recordings: zstd or none")
+}
+
+func containerSchema(cmd *cobra.Command) (int, string, error) {
+	container, err := cmd.Flags().GetString("container")
+	if err != nil {
+		return 0, "", err
+	}
+	compress, err := cmd.Flags().GetString("compress")
+	if err != nil {
+		return 0, "", err
+	}
+	return recordingSchema(container, compress)
+}
+
+func recordingSchema(container string, compress string) (int, string, error) {
+	compression := compress
+	if compression == "none" {
+		compression = platform.CompressionNone
+	}
+
+	switch container {
+	case "segmented":
+		return platform.RecordingSchemaSegmented, compression, nil
+	case "files":
+		return platform.RecordingSchemaFiles, platform.CompressionNone, nil
+	default:
+		return 0, "", fmt.Errorf("unknown --container %q, expected segmented or files", container)
+	}
+}
+
+func recordOptions(cmd *cobra.Command) (gtfs_rt.RecordOptions, error) {
+	opts := gtfs_rt.RecordOptions{}
+	var err error
+	if opts.Rotate, err = cmd.Flags().GetString("rotate"); err != nil {
+		return opts, err
+	}
+	if opts.StopAfter, err = cmd.Flags().GetDuration("stop-after"); err != nil {
+		return opts, err
+	}
+
+	rotateSize, err := cmd.Flags().GetString("rotate-size")
+	if err != nil {
+		return opts, err
+	}
+	if rotateSize != "" {
+		if opts.RotateBytes, err = platform.ParseByteSize(rotateSize); err != nil {
+			return opts, fmt.Errorf("--rotate-size: %w", err)
+		}
+	}
+
+	quota, err := cmd.Flags().GetString("quota")
+	if err != nil {
+		return opts, err
+	}
+	if quota != "" {
+		if opts.Quota.MaxBytes, err = platform.ParseByteSize(quota); err != nil {
+			return opts, fmt.Errorf("--quota: %w", err)
+		}
+	}
+
+	action, err := cmd.Flags().GetString("quota-action")
+	if err != nil {
+		return opts, err
+	}
+	switch action {
+	case "delete":
+	case "compress":
+		opts.Quota.Compress = true
+	default:
+		return opts, fmt.Errorf("unknown --quota-action %q, expected delete or compress", action)
+	}
+
+	return opts, nil
+}
+
 func (app *GtfsCtlApp) DoRecord(cmd *cobra.Command, args []string) error {
+	schemaVersion, compression, err := containerSchema(cmd)
+	if err != nil {
+		return err
+	}
+	recordOpts, err := recordOptions(cmd)
+	if err != nil {
+		return err
+	}
+
 	now := time.Now()
 	opts := platform.RecordingHeaderOptions{
 		RecordingName: args[0],
@@ -40,15 +135,18 @@ func (app *GtfsCtlApp) DoRecord(cmd *cobra.Command, args []string) error {
 		CreatedAt:     now,
 		TimeZone:      now.Location().String(),
 		Tool:          platform.NewToolInfo(cmd.Root().Name()),
+		SchemaVersion: schemaVersion,
+		Compression:   compression,
 	}
 
-	recorder, err := gtfs_rt.NewFileRecorder(app.Context, app.Config, opts)
+	recorder, err := gtfs_rt.NewFileRecorder(app.Context, app.Config, opts, recordOpts)
 	if err != nil {
 		return err
 	}
+	defer recorder.Stop()
 
 	log.Printf("================================================================================\n")
-	log.Printf("Start file record: %s\n", opts.GetRecordingPath())
+	log.Printf("Start file record: %s\n", recorder.CurrentDir())
 	log.Printf("================================================================================\n")
 
 	return recorder.Record(app.Context)
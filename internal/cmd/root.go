@@ -8,6 +8,7 @@ import (
 
 	"github.com/spf13/cobra"
 	"tarediiran-industries.com/gtfs-services/internal/platform"
+	"tarediiran-industries.com/gtfs-services/internal/repository"
 )
 
 type GtfsCtlApp struct {
@@ -40,6 +41,21 @@ func (app *GtfsCtlApp) Init() error {
 	return app.ParseConfig()
 }
 
+// OpenRepository connects to the configured database for the inspection commands. The returned
+// func closes the connection.
+func (app *GtfsCtlApp) OpenRepository() (*repository.Repository, func(), error) {
+	db, err := app.Config.NewDatabase(app.Context)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := db.RequireSchema(app.Context); err != nil {
+		db.Close()
+		return nil, nil, err
+	}
+
+	return repository.New(db), func() { db.Close() }, nil
+}
+
 func Execute() error {
 	app := &GtfsCtlApp{}
 	var stop context.CancelFunc
@@ -69,7 +85,11 @@ func NewRootCmd(app *GtfsCtlApp) *cobra.Command {
 	cmd.AddCommand(NewArrivalsCmd(app))
 	cmd.AddCommand(NewTripsCmd(app))
 	cmd.AddCommand(NewStationsCmd(app))
+	cmd.AddCommand(NewRoutesCmd(app))
+	cmd.AddCommand(NewAlertsCmd(app))
 	cmd.AddCommand(NewCaptureCmd(app))
+	cmd.AddCommand(NewRtCmd(app))
+	cmd.AddCommand(NewDbCmd(app))
 
 	return cmd
 }
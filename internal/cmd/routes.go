@@ -10,11 +10,26 @@ func NewRoutesCmd(app *GtfsCtlApp) *cobra.Command {
 	cmd := &cobra.Command{
 		Use:   "routes",
 		Short: "Inspect activity for transit routes",
-		RunE: func(cmd *cobra.Command, args []string) error {
-			fmt.Println("Routes: NYI")
-			return nil
-		},
+		RunE:  app.DoRoutes,
+		Args:  cobra.NoArgs,
 	}
 
 	return cmd
 }
+
+func (app *GtfsCtlApp) DoRoutes(cmd *cobra.Command, args []string) error {
+	repo, closeRepo, err := app.OpenRepository()
+	if err != nil {
+		return err
+	}
+	defer closeRepo()
+
+	routes, err := repo.Routes(app.Context)
+	if err != nil {
+		return err
+	}
+	for _, route := range routes {
+		fmt.Printf("%-4s %-6s %s\n", route.ID, route.Color, route.LongName)
+	}
+	return nil
+}
@@ -0,0 +1,104 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/spf13/cobra"
+	"tarediiran-industries.com/gtfs-services/internal/export"
+	"tarediiran-industries.com/gtfs-services/internal/probe"
+	"tarediiran-industries.com/gtfs-services/internal/watch"
+)
+
+func NewRtCmd(app *GtfsCtlApp) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "rt",
+		Short: "Commands to inspect GTFS-RT payloads directly",
+	}
+
+	cmd.AddCommand(NewRtDumpCmd(app))
+
+	return cmd
+}
+
+func NewRtDumpCmd(app *GtfsCtlApp) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "dump <feed_id|url|file>",
+		Short: "Fetch or read one GTFS-RT payload and print it as a summary or protojson",
+		RunE:  app.DoRtDump,
+		Args:  cobra.ExactArgs(1),
+	}
+
+	cmd.Flags().String("format", "summary", "Output format: summary or protojson")
+	cmd.Flags().Bool("trips", false, "List every trip in the summary")
+	cmd.Flags().Bool("watch", false, "Fetch again every --interval and redraw")
+	cmd.Flags().Duration("interval", 5*time.Second, "Refresh interval for --watch")
+
+	return cmd
+}
+
+func (app *GtfsCtlApp) DoRtDump(cmd *cobra.Command, args []string) error {
+	format, err := cmd.Flags().GetString("format")
+	if err != nil {
+		return err
+	}
+	if format != "summary" && format != "protojson" {
+		return fmt.Errorf("unknown --format %q, expected summary or protojson", format)
+	}
+	withTrips, err := cmd.Flags().GetBool("trips")
+	if err != nil {
+		return err
+	}
+	watchMode, err := cmd.Flags().GetBool("watch")
+	if err != nil {
+		return err
+	}
+	interval, err := cmd.Flags().GetDuration("interval")
+	if err != nil {
+		return err
+	}
+
+	target, err := probe.Resolve(&app.Config, args[0])
+	if err != nil {
+		return err
+	}
+	client := &http.Client{Timeout: 30 * time.Second}
+
+	render := func(ctx context.Context, out io.Writer) error {
+		result, err := probe.Load(ctx, client, target)
+		if err != nil {
+			return err
+		}
+
+		if format == "protojson" {
+			encoded, err := export.MarshalProtoJSON(result.Message)
+			if err != nil {
+				return err
+			}
+			var indented bytes.Buffer
+			if err := json.Indent(&indented, encoded, "", "  "); err != nil {
+				return err
+			}
+			indented.WriteByte('\n')
+			_, err = indented.WriteTo(out)
+			return err
+		}
+
+		probe.WriteSummary(out, result, probe.Summarize(result.Message, time.Now()), withTrips)
+		return nil
+	}
+
+	if watchMode {
+		if interval <= 0 {
+			return fmt.Errorf("--interval must be greater than 0")
+		}
+		return watch.Run(app.Context, os.Stdout, interval, render)
+	}
+	return render(app.Context, os.Stdout)
+}
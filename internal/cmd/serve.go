@@ -0,0 +1,74 @@
+package cmd
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/spf13/cobra"
+	"tarediiran-industries.com/gtfs-services/internal/ingest/gtfs_rt"
+	"tarediiran-industries.com/gtfs-services/internal/platform"
+)
+
+func NewServeCmd(app *GtfsCtlApp) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "serve <recording_name>",
+		Short: "Serve a recording as mock GTFS-RT endpoints, one per recorded feed",
+		Long: "Serve a recording as mock GTFS-RT endpoints at http://<listen>/feeds/<feed_id>, each " +
+			"answering with the frame current for the playback clock, including error statuses, " +
+			"latency and dropped connections. Point rt_url at them, see config/gtfs-localhost.dev.toml.",
+		RunE: app.DoServe,
+		Args: cobra.ExactArgs(1),
+	}
+
+	cmd.Flags().String("listen", "localhost:8090", "Address to serve the feeds on")
+	cmd.Flags().Float64("speed", 1, "Play back this many times faster than recorded")
+	cmd.Flags().Bool("loop", false, "Start over once the end of the recording or the --to time is reached")
+	addSelectionFlags(cmd)
+
+	return cmd
+}
+
+func (app *GtfsCtlApp) DoServe(cmd *cobra.Command, args []string) error {
+	listen, err := cmd.Flags().GetString("listen")
+	if err != nil {
+		return err
+	}
+	speed, err := cmd.Flags().GetFloat64("speed")
+	if err != nil {
+		return err
+	}
+	if speed <= 0 {
+		return fmt.Errorf("--speed must be greater than 0")
+	}
+	loop, err := cmd.Flags().GetBool("loop")
+	if err != nil {
+		return err
+	}
+
+	recordingPath := filepath.Join(app.Layout.RecordingsDir, args[0])
+	header, err := platform.ReadRecordingHeader(recordingPath)
+	if err != nil {
+		return err
+	}
+	filter, err := playbackFilter(cmd, header)
+	if err != nil {
+		return err
+	}
+
+	clock := gtfs_rt.NewPlaybackClock(gtfs_rt.SystemClock, speed)
+	stopPauseToggle := togglePauseOnSignal(clock)
+	defer stopPauseToggle()
+
+	server, err := gtfs_rt.NewRecordingServer(recordingPath, gtfs_rt.RecordingServerOptions{
+		ListenAddr: listen,
+		Filter:     filter,
+		Clock:      clock,
+		Loop:       loop,
+	})
+	if err != nil {
+		return err
+	}
+	defer server.Close()
+
+	return server.Serve(app.Context)
+}
@@ -8,13 +8,35 @@ import (
 
 func NewStationsCmd(app *GtfsCtlApp) *cobra.Command {
 	cmd := &cobra.Command{
-		Use:   "stations",
+		Use:   "stations [name]",
 		Short: "Inspect state of stations",
-		RunE: func(cmd *cobra.Command, args []string) error {
-			fmt.Println("Stations: NYI")
-			return nil
-		},
+		Long:  "Lists stations from the static feed, only those whose name contains the argument if given",
+		RunE:  app.DoStations,
+		Args:  cobra.MaximumNArgs(1),
 	}
 
 	return cmd
 }
+
+func (app *GtfsCtlApp) DoStations(cmd *cobra.Command, args []string) error {
+	search := ""
+	if len(args) == 1 {
+		search = args[0]
+	}
+
+	repo, closeRepo, err := app.OpenRepository()
+	if err != nil {
+		return err
+	}
+	defer closeRepo()
+
+	stations, err := repo.Stations(app.Context, search)
+	if err != nil {
+		return err
+	}
+	for _, station := range stations {
+		fmt.Printf("%-8s %-40s %10.6f %11.6f\n", station.ID, station.Name, station.Lat, station.Lon)
+	}
+	fmt.Printf("%d station(s)\n", len(stations))
+	return nil
+}
@@ -2,19 +2,68 @@ package cmd
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/spf13/cobra"
 )
 
 func NewTripsCmd(app *GtfsCtlApp) *cobra.Command {
 	cmd := &cobra.Command{
-		Use:   "trips",
+		Use:   "trips [trip_id]",
 		Short: "Inspect scheduled and upcoming train trips",
-		RunE: func(cmd *cobra.Command, args []string) error {
-			fmt.Println("Trips: NYI")
-			return nil
-		},
+		Long:  "Lists the trips currently in the realtime feeds, or the predicted stops of one trip",
+		RunE:  app.DoTrips,
+		Args:  cobra.MaximumNArgs(1),
 	}
+	cmd.Flags().String("route", "", "Only list trips on this route")
 
 	return cmd
 }
+
+func formatClock(value time.Time) string {
+	if value.IsZero() {
+		return "--:--:--"
+	}
+	return value.Local().Format("15:04:05")
+}
+
+func (app *GtfsCtlApp) DoTrips(cmd *cobra.Command, args []string) error {
+	repo, closeRepo, err := app.OpenRepository()
+	if err != nil {
+		return err
+	}
+	defer closeRepo()
+
+	if len(args) == 1 {
+		arrivals, err := repo.TripArrivals(app.Context, args[0])
+		if err != nil {
+			return err
+		}
+		if len(arrivals) == 0 {
+			return fmt.Errorf("trip %s is not in any realtime feed", args[0])
+		}
+		for _, arrival := range arrivals {
+			fmt.Printf(
+				"%s  %-8s %-32s arr %s  dep %s\n",
+				arrival.StartDate, arrival.StopID, arrival.StopName,
+				formatClock(arrival.ArrivalTime), formatClock(arrival.DepartureTime),
+			)
+		}
+		return nil
+	}
+
+	route, _ := cmd.Flags().GetString("route")
+	trains, err := repo.ActiveTrains(app.Context, route, time.Now())
+	if err != nil {
+		return err
+	}
+	for _, train := range trains {
+		fmt.Printf(
+			"%-4s %-24s %-28s next %-8s %s  (%s)\n",
+			train.RouteID, train.TripID, train.Headsign,
+			train.NextStopID, formatClock(train.NextArrival), train.FeedID,
+		)
+	}
+	fmt.Printf("%d trip(s)\n", len(trains))
+	return nil
+}
@@ -0,0 +1,61 @@
+package cmd
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/spf13/cobra"
+	"tarediiran-industries.com/gtfs-services/internal/platform"
+)
+
+func NewVerifyCmd(app *GtfsCtlApp) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "verify <recording_name>",
+		Short: "Check every payload of a recording against its SHA-256",
+		Long: "Read every frame of a recording, checking each payload against the SHA-256 in its " +
+			"metadata and the sequence numbers for gaps. --repair first drops a last frame torn by a " +
+			"crash, the same repair a resumed recording gets.",
+		RunE: app.DoVerify,
+		Args: cobra.ExactArgs(1),
+	}
+
+	cmd.Flags().Bool("repair", false, "Drop a torn last frame and stale index entries before verifying")
+
+	return cmd
+}
+
+func (app *GtfsCtlApp) DoVerify(cmd *cobra.Command, args []string) error {
+	repair, err := cmd.Flags().GetBool("repair")
+	if err != nil {
+		return err
+	}
+
+	recordingPath := filepath.Join(app.Layout.RecordingsDir, args[0])
+	if repair {
+		result, err := platform.RepairFeedRecording(recordingPath)
+		if err != nil {
+			return err
+		}
+		if result.Repaired() {
+			fmt.Printf(
+				"repaired: dropped %d bytes of torn frame, %d index entries\n",
+				result.TruncatedBytes, result.DroppedIndexEntries,
+			)
+		}
+	}
+
+	verification, err := platform.VerifyFeedRecording(recordingPath)
+	if err != nil {
+		return err
+	}
+
+	for _, problem := range verification.Problems {
+		fmt.Printf("%8d  %s\n", problem.SequenceNumber, problem.Problem)
+	}
+	fmt.Printf("%d frames, %d payloads checked\n", verification.Frames, verification.Payloads)
+
+	if len(verification.Problems) > 0 {
+		return fmt.Errorf("%s: %d problems found", args[0], len(verification.Problems))
+	}
+	return nil
+}
@@ -2,74 +2,119 @@ package db
 
 import (
 	"context"
-	"database/sql"
 	"fmt"
 	"os"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jackc/pgx/v5/pgxpool"
-	_ "github.com/jackc/pgx/v5/stdlib"
 )
 
+// DBTX is what queries need from a connection, satisfied by both *Database and *Tx
 type DBTX interface {
-	ExecContext(context.Context, string, ...any) (sql.Result, error)
-	QueryContext(context.Context, string, ...any) (*sql.Rows, error)
-	QueryRowContext(context.Context, string, ...any) *sql.Row
+	Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error)
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
 }
 
 type CopyCapable interface {
 	CopyFrom(ctx context.Context, table string, columns []string, filePath string) (int64, error)
+	CopyFromSlice(ctx context.Context, table string, columns []string, size int, producer func(i int) ([]any, error)) (int64, error)
+	CopyFromSource(ctx context.Context, table string, columns []string, source pgx.CopyFromSource) (int64, error)
+}
+
+var (
+	_ DBTX        = (*Database)(nil)
+	_ CopyCapable = (*Database)(nil)
+)
+
+// Zero fields keep the pgxpool defaults
+type PoolOptions struct {
+	MaxConns        int32
+	MinConns        int32
+	MaxConnLifetime time.Duration
+	MaxConnIdleTime time.Duration
+	// Sent as statement_timeout on every new connection
+	StatementTimeout time.Duration
 }
 
 type Database struct {
-	db   *sql.DB
 	pool *pgxpool.Pool
 }
 
-func NewDatabaseConnection(ctx context.Context, domainStringName string) (*Database, error) {
-	db, err := sql.Open("pgx", domainStringName)
+func NewDatabaseConnection(ctx context.Context, domainStringName string, opts PoolOptions) (*Database, error) {
+	db, err := OpenDatabase(ctx, domainStringName, opts)
 	if err != nil {
-		return nil, fmt.Errorf("sql.Open: %w", err)
+		return nil, err
 	}
 
 	pctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 
-	if err := db.PingContext(pctx); err != nil {
-		_ = db.Close()
+	if err := db.pool.Ping(pctx); err != nil {
+		db.Close()
 		return nil, fmt.Errorf("db ping: %w", err)
 	}
 
-	pool, err := pgxpool.New(ctx, domainStringName)
-	if err := pool.Ping(ctx); err != nil {
-		pool.Close()
-		_ = db.Close()
-		return nil, fmt.Errorf("pgxpool ping: %w", err)
+	return db, nil
+}
+
+// OpenDatabase sets up the pool without waiting for the database to answer, connections are made
+// as queries need them
+func OpenDatabase(ctx context.Context, domainStringName string, opts PoolOptions) (*Database, error) {
+	config, err := pgxpool.ParseConfig(domainStringName)
+	if err != nil {
+		return nil, fmt.Errorf("parse database url: %w", err)
+	}
+	if opts.MaxConns > 0 {
+		config.MaxConns = opts.MaxConns
+	}
+	if opts.MinConns > 0 {
+		config.MinConns = opts.MinConns
+	}
+	if opts.MaxConnLifetime > 0 {
+		config.MaxConnLifetime = opts.MaxConnLifetime
+	}
+	if opts.MaxConnIdleTime > 0 {
+		config.MaxConnIdleTime = opts.MaxConnIdleTime
+	}
+	if opts.StatementTimeout > 0 {
+		config.ConnConfig.RuntimeParams["statement_timeout"] = strconv.FormatInt(opts.StatementTimeout.Milliseconds(), 10)
 	}
 
-	return &Database{db: db, pool: pool}, nil
+	pool, err := pgxpool.NewWithConfig(ctx, config)
+	if err != nil {
+		return nil, fmt.Errorf("pgxpool: %w", err)
+	}
+	return &Database{pool: pool}, nil
 }
 
 func (db *Database) Close() error {
-	if db == nil || db.db == nil {
+	if db == nil || db.pool == nil {
 		return nil
 	}
-	fmt.Println("DB closed.")
-	return db.db.Close()
+	db.pool.Close()
+	return nil
+}
+
+// Stat is a snapshot of the connection pool's counters, see platform.NewDatabasePoolCollector
+func (db *Database) Stat() *pgxpool.Stat {
+	return db.pool.Stat()
 }
 
-func (db *Database) ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error) {
-	return db.db.ExecContext(ctx, query, args...)
+func (db *Database) Exec(ctx context.Context, query string, args ...any) (pgconn.CommandTag, error) {
+	return db.pool.Exec(ctx, query, args...)
 }
 
-func (db *Database) QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error) {
-	return db.db.QueryContext(ctx, query, args...)
+func (db *Database) Query(ctx context.Context, query string, args ...any) (pgx.Rows, error) {
+	return db.pool.Query(ctx, query, args...)
 }
 
-func (db *Database) QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row {
-	return db.db.QueryRowContext(ctx, query, args...)
+func (db *Database) QueryRow(ctx context.Context, query string, args ...any) pgx.Row {
+	return db.pool.QueryRow(ctx, query, args...)
 }
 
 func quoteProtect(s string) string {
@@ -89,41 +134,46 @@ func buildCopyQuery(tableName string, columns []string) string {
 	)
 }
 
-func (db *Database) CopyFromCSVFile(ctx context.Context, table string, columns []string, filePath string) (int64, error) {
+func copyFromCSVFile(ctx context.Context, conn *pgconn.PgConn, table string, columns []string, filePath string) (int64, error) {
 	file, err := os.Open(filePath)
 	if err != nil {
 		return 0, fmt.Errorf("failed to open file: %w", err)
 	}
 	defer file.Close()
 
-	conn, err := db.pool.Acquire(ctx)
-	if err != nil {
-		return 0, fmt.Errorf("failed to acquire connection from pool: %w", err)
-	}
-	defer conn.Release()
-
-	copyQuery := buildCopyQuery(table, columns)
-	res, err := conn.Conn().PgConn().CopyFrom(ctx, file, copyQuery)
+	res, err := conn.CopyFrom(ctx, file, buildCopyQuery(table, columns))
 	if err != nil {
 		return 0, fmt.Errorf("failed to copy from CSV file: %w", err)
 	}
 	return res.RowsAffected(), nil
 }
 
-func (db *Database) CopyFromSlice(ctx context.Context, table string, columns []string, size int, producer func(i int) ([]any, error)) (int64, error) {
+func (db *Database) CopyFromCSVFile(ctx context.Context, table string, columns []string, filePath string) (int64, error) {
 	conn, err := db.pool.Acquire(ctx)
 	if err != nil {
 		return 0, fmt.Errorf("failed to acquire connection from pool: %w", err)
 	}
 	defer conn.Release()
 
-	res, err := conn.Conn().CopyFrom(ctx, pgx.Identifier{table}, columns, pgx.CopyFromSlice(size, producer))
+	return copyFromCSVFile(ctx, conn.Conn().PgConn(), table, columns, filePath)
+}
+
+func (db *Database) CopyFromSlice(ctx context.Context, table string, columns []string, size int, producer func(i int) ([]any, error)) (int64, error) {
+	res, err := db.pool.CopyFrom(ctx, pgx.Identifier{table}, columns, pgx.CopyFromSlice(size, producer))
 	if err != nil {
 		return 0, fmt.Errorf("failed to copy from slice: %w", err)
 	}
 	return res, nil
 }
 
+func (db *Database) CopyFromSource(ctx context.Context, table string, columns []string, source pgx.CopyFromSource) (int64, error) {
+	res, err := db.pool.CopyFrom(ctx, pgx.Identifier{table}, columns, source)
+	if err != nil {
+		return 0, fmt.Errorf("failed to copy from source: %w", err)
+	}
+	return res, nil
+}
+
 func (db *Database) CopyFrom(ctx context.Context, table string, columns []string, filePath string) (int64, error) {
 	return db.CopyFromCSVFile(ctx, table, columns, filePath)
 }
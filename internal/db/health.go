@@ -0,0 +1,97 @@
+package db
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// IsConnectionError reports whether err means the database itself is unreachable or going away,
+// as opposed to a problem with the statement or the data sent to it.
+func IsConnectionError(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	var connectErr *pgconn.ConnectError
+	if errors.As(err, &connectErr) {
+		return true
+	}
+
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		// 08: connection exception, 53: insufficient resources, 57P: operator intervention
+		return strings.HasPrefix(pgErr.Code, "08") ||
+			strings.HasPrefix(pgErr.Code, "53") ||
+			strings.HasPrefix(pgErr.Code, "57P")
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return true
+	}
+
+	return pgconn.SafeToRetry(err) ||
+		pgconn.Timeout(err) ||
+		errors.Is(err, driver.ErrBadConn) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, io.EOF)
+}
+
+func (db *Database) Ping(ctx context.Context) error {
+	pctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	return db.pool.Ping(pctx)
+}
+
+type RetryOptions struct {
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	MaxWait        time.Duration // zero retries until ctx is done
+}
+
+func DefaultRetryOptions() RetryOptions {
+	return RetryOptions{
+		InitialBackoff: 500 * time.Millisecond,
+		MaxBackoff:     30 * time.Second,
+	}
+}
+
+func (opts RetryOptions) Backoff(attempt int) time.Duration {
+	delay := opts.InitialBackoff << min(attempt, 16)
+	if delay <= 0 || delay > opts.MaxBackoff {
+		delay = opts.MaxBackoff
+	}
+	return delay
+}
+
+// Retry calls attempt until it succeeds, returns an error that isn't a connection error, or the
+// retry budget runs out.
+func Retry(ctx context.Context, label string, opts RetryOptions, attempt func(ctx context.Context) error) error {
+	start := time.Now()
+	for try := 0; ; try++ {
+		err := attempt(ctx)
+		if err == nil || !IsConnectionError(err) {
+			return err
+		}
+		if opts.MaxWait > 0 && time.Since(start) > opts.MaxWait {
+			return fmt.Errorf("%s: gave up after %s: %w", label, opts.MaxWait, err)
+		}
+
+		delay := opts.Backoff(try)
+		log.Printf("%s: %v (retrying in %s)", label, err, delay)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+	}
+}
@@ -0,0 +1,264 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"regexp"
+	"sort"
+	"strconv"
+
+	"github.com/jackc/pgx/v5"
+	"tarediiran-industries.com/gtfs-services/migrations"
+)
+
+// Schema versions are tracked in the same schema_migrations table the golang-migrate CLI uses, so
+// databases migrated with either can be handled by the other.
+const schemaMigrationsTable = "schema_migrations"
+
+var migrationFilePattern = regexp.MustCompile(`^(\d+)_(.+)\.(up|down)\.sql$`)
+
+type Migration struct {
+	Version uint
+	Name    string
+	Up      string
+	Down    string
+}
+
+type SchemaStatus struct {
+	Version uint
+	Dirty   bool
+	// False when no migration has ever been applied
+	HasVersion bool
+}
+
+func (status SchemaStatus) String() string {
+	if !status.HasVersion {
+		return "no migrations applied"
+	}
+	if status.Dirty {
+		return fmt.Sprintf("version %d (dirty)", status.Version)
+	}
+	return fmt.Sprintf("version %d", status.Version)
+}
+
+func LoadMigrations(fsys fs.FS) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[uint]*Migration)
+	for _, entry := range entries {
+		match := migrationFilePattern.FindStringSubmatch(entry.Name())
+		if match == nil {
+			continue
+		}
+
+		version, err := strconv.ParseUint(match[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("migration %s: %w", entry.Name(), err)
+		}
+		body, err := fs.ReadFile(fsys, entry.Name())
+		if err != nil {
+			return nil, err
+		}
+
+		migration, ok := byVersion[uint(version)]
+		if !ok {
+			migration = &Migration{Version: uint(version), Name: match[2]}
+			byVersion[uint(version)] = migration
+		}
+		if match[3] == "up" {
+			migration.Up = string(body)
+		} else {
+			migration.Down = string(body)
+		}
+	}
+
+	loaded := make([]Migration, 0, len(byVersion))
+	for _, migration := range byVersion {
+		if migration.Up == "" {
+			return nil, fmt.Errorf("migration %d_%s has no up file", migration.Version, migration.Name)
+		}
+		loaded = append(loaded, *migration)
+	}
+	sort.Slice(loaded, func(i, j int) bool { return loaded[i].Version < loaded[j].Version })
+	return loaded, nil
+}
+
+// EmbeddedMigrations are the migrations this build was compiled with
+func EmbeddedMigrations() ([]Migration, error) {
+	return LoadMigrations(migrations.FS)
+}
+
+func (db *Database) SchemaStatus(ctx context.Context) (SchemaStatus, error) {
+	status := SchemaStatus{}
+
+	var table *string
+	if err := db.QueryRow(ctx, "SELECT to_regclass($1)::text", schemaMigrationsTable).Scan(&table); err != nil {
+		return status, fmt.Errorf("schema status: %w", err)
+	}
+	if table == nil {
+		return status, nil
+	}
+
+	var version int64
+	err := db.QueryRow(ctx, "SELECT version, dirty FROM "+schemaMigrationsTable+" LIMIT 1").Scan(&version, &status.Dirty)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return status, nil
+	}
+	if err != nil {
+		return status, fmt.Errorf("schema status: %w", err)
+	}
+	status.Version = uint(version)
+	status.HasVersion = true
+	return status, nil
+}
+
+func (db *Database) ensureMigrationsTable(ctx context.Context) error {
+	_, err := db.Exec(
+		ctx,
+		"CREATE TABLE IF NOT EXISTS "+schemaMigrationsTable+" (version BIGINT NOT NULL PRIMARY KEY, dirty BOOLEAN NOT NULL)",
+	)
+	return err
+}
+
+func setSchemaVersion(ctx context.Context, tx *Tx, version uint, hasVersion bool, dirty bool) error {
+	if _, err := tx.Exec(ctx, "TRUNCATE "+schemaMigrationsTable); err != nil {
+		return err
+	}
+	if !hasVersion {
+		return nil
+	}
+	_, err := tx.Exec(
+		ctx,
+		"INSERT INTO "+schemaMigrationsTable+" (version, dirty) VALUES ($1, $2)",
+		int64(version), dirty,
+	)
+	return err
+}
+
+// applyMigration runs one migration file and records the resulting version in a single
+// transaction, so a failed migration leaves neither its changes nor a dirty version behind.
+func (db *Database) applyMigration(ctx context.Context, body string, version uint, hasVersion bool) error {
+	return db.WithTx(ctx, func(tx *Tx) error {
+		// Rewriting large tables can outlast the statement timeout configured for the services
+		if _, err := tx.Exec(ctx, "SET LOCAL statement_timeout = 0"); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(ctx, body); err != nil {
+			return err
+		}
+		return setSchemaVersion(ctx, tx, version, hasVersion, false)
+	})
+}
+
+// MigrateUp applies up to steps pending migrations, or all of them when steps is zero
+func (db *Database) MigrateUp(ctx context.Context, all []Migration, steps int) ([]Migration, error) {
+	if err := db.ensureMigrationsTable(ctx); err != nil {
+		return nil, fmt.Errorf("create %s: %w", schemaMigrationsTable, err)
+	}
+	status, err := db.SchemaStatus(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if status.Dirty {
+		return nil, fmt.Errorf("schema is dirty at version %d, repair it and force a version first", status.Version)
+	}
+
+	applied := make([]Migration, 0)
+	for _, migration := range all {
+		if status.HasVersion && migration.Version <= status.Version {
+			continue
+		}
+		if steps > 0 && len(applied) == steps {
+			break
+		}
+		if err := db.applyMigration(ctx, migration.Up, migration.Version, true); err != nil {
+			return applied, fmt.Errorf("migrate up %d_%s: %w", migration.Version, migration.Name, err)
+		}
+		applied = append(applied, migration)
+	}
+	return applied, nil
+}
+
+// MigrateDown reverts up to steps applied migrations, or all of them when steps is zero
+func (db *Database) MigrateDown(ctx context.Context, all []Migration, steps int) ([]Migration, error) {
+	status, err := db.SchemaStatus(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if status.Dirty {
+		return nil, fmt.Errorf("schema is dirty at version %d, repair it and force a version first", status.Version)
+	}
+
+	reverted := make([]Migration, 0)
+	if !status.HasVersion {
+		return reverted, nil
+	}
+
+	for i := len(all) - 1; i >= 0; i-- {
+		migration := all[i]
+		if migration.Version > status.Version {
+			continue
+		}
+		if steps > 0 && len(reverted) == steps {
+			break
+		}
+		if migration.Down == "" {
+			return reverted, fmt.Errorf("migration %d_%s has no down file", migration.Version, migration.Name)
+		}
+
+		previous, hasPrevious := uint(0), i > 0
+		if hasPrevious {
+			previous = all[i-1].Version
+		}
+		if err := db.applyMigration(ctx, migration.Down, previous, hasPrevious); err != nil {
+			return reverted, fmt.Errorf("migrate down %d_%s: %w", migration.Version, migration.Name, err)
+		}
+		reverted = append(reverted, migration)
+	}
+	return reverted, nil
+}
+
+// ForceSchemaVersion records version as applied and clean without running anything, for recovering
+// from a migration that failed halfway under the migrate CLI
+func (db *Database) ForceSchemaVersion(ctx context.Context, version uint) error {
+	if err := db.ensureMigrationsTable(ctx); err != nil {
+		return err
+	}
+
+	return db.WithTx(ctx, func(tx *Tx) error {
+		return setSchemaVersion(ctx, tx, version, true, false)
+	})
+}
+
+// RequireSchema refuses to continue unless the database is exactly at the newest migration this
+// build was compiled with.
+func (db *Database) RequireSchema(ctx context.Context) error {
+	all, err := EmbeddedMigrations()
+	if err != nil {
+		return err
+	}
+	if len(all) == 0 {
+		return nil
+	}
+	required := all[len(all)-1].Version
+
+	status, err := db.SchemaStatus(ctx)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case status.Dirty:
+		return fmt.Errorf("database schema is dirty at version %d, a migration failed partway", status.Version)
+	case !status.HasVersion || status.Version < required:
+		return fmt.Errorf("database schema is at %s but this build needs version %d, run `gtfs-ctl db migrate up`", status, required)
+	case status.Version > required:
+		return fmt.Errorf("database schema version %d is newer than this build supports (%d)", status.Version, required)
+	}
+	return nil
+}
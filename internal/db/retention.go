@@ -0,0 +1,204 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// Realtime tables partitioned by day, see migrations/005_partition_realtime.up.sql
+var RealtimePartitionedTables = []string{
+	"feed_snapshots",
+	"trip_update_events",
+	"trip_update_stop_time_events",
+}
+
+const (
+	partitionDayFormat = "20060102"
+	day                = 24 * time.Hour
+
+	// Partitions are created this many days past today so writes never land outside one
+	partitionLeadDays = 2
+
+	defaultMaintenanceInterval = time.Hour
+)
+
+type RetentionOptions struct {
+	// Days that ended more than Window ago are dropped, zero keeps everything
+	Window time.Duration
+	// Roll each day up into route_daily_summary before dropping it
+	Rollup bool
+	DryRun bool
+	// How often the ingester's maintenance job runs
+	Interval time.Duration
+}
+
+type PruneResult struct {
+	Days     []time.Time
+	Dropped  []string
+	RolledUp int
+}
+
+func utcDay(t time.Time) time.Time {
+	return t.UTC().Truncate(day)
+}
+
+// IsMissingPartition reports whether err is Postgres refusing a row that has no partition to go to
+func IsMissingPartition(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) &&
+		pgErr.Code == "23514" &&
+		strings.Contains(pgErr.Message, "no partition")
+}
+
+// EnsurePartitions creates any missing daily partitions covering first through last
+func (db *Database) EnsurePartitions(ctx context.Context, first time.Time, last time.Time) (int, error) {
+	var created int
+	row := db.QueryRow(
+		ctx,
+		"SELECT gtfs_rt_ensure_partitions($1::date, $2::date)",
+		utcDay(first).Format(time.DateOnly),
+		utcDay(last).Format(time.DateOnly),
+	)
+	if err := row.Scan(&created); err != nil {
+		return 0, fmt.Errorf("ensure partitions: %w", err)
+	}
+	return created, nil
+}
+
+// PartitionDays lists the days that currently have a feed_snapshots partition, oldest first
+func (db *Database) PartitionDays(ctx context.Context) ([]time.Time, error) {
+	rows, err := db.Query(
+		ctx,
+		`SELECT child.relname
+		FROM pg_inherits
+		JOIN pg_class parent ON parent.oid = pg_inherits.inhparent
+		JOIN pg_class child ON child.oid = pg_inherits.inhrelid
+		WHERE parent.relname = $1`,
+		RealtimePartitionedTables[0],
+	)
+	if err != nil {
+		return nil, fmt.Errorf("list partitions: %w", err)
+	}
+	defer rows.Close()
+
+	prefix := RealtimePartitionedTables[0] + "_p"
+	days := make([]time.Time, 0)
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		suffix, ok := strings.CutPrefix(name, prefix)
+		if !ok {
+			continue
+		}
+		partitionDay, err := time.Parse(partitionDayFormat, suffix)
+		if err != nil {
+			continue
+		}
+		days = append(days, partitionDay)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	sort.Slice(days, func(i, j int) bool { return days[i].Before(days[j]) })
+	return days, nil
+}
+
+// Prune drops every day of realtime data that ended more than opts.Window before now
+func (db *Database) Prune(ctx context.Context, now time.Time, opts RetentionOptions) (PruneResult, error) {
+	result := PruneResult{}
+	if opts.Window <= 0 {
+		return result, nil
+	}
+
+	days, err := db.PartitionDays(ctx)
+	if err != nil {
+		return result, err
+	}
+
+	cutoff := now.Add(-opts.Window)
+	for _, partitionDay := range days {
+		if partitionDay.Add(day).After(cutoff) {
+			break
+		}
+		result.Days = append(result.Days, partitionDay)
+
+		if opts.DryRun {
+			continue
+		}
+
+		// The rollup and the drop commit together, so a day is never lost without its summary
+		var rolledUp int
+		dropped := make([]string, 0, len(RealtimePartitionedTables))
+		err := db.WithTx(ctx, func(tx *Tx) error {
+			// Rolling up a busy day can take longer than the statement timeout meant for ingest
+			if _, err := tx.Exec(ctx, "SET LOCAL statement_timeout = 0"); err != nil {
+				return err
+			}
+			if opts.Rollup {
+				row := tx.QueryRow(ctx, "SELECT gtfs_rt_rollup_day($1::date)", partitionDay.Format(time.DateOnly))
+				if err := row.Scan(&rolledUp); err != nil {
+					return fmt.Errorf("roll up %s: %w", partitionDay.Format(time.DateOnly), err)
+				}
+			}
+
+			// Event tables first, then the snapshots they belong to
+			for i := len(RealtimePartitionedTables) - 1; i >= 0; i-- {
+				name := fmt.Sprintf("%s_p%s", RealtimePartitionedTables[i], partitionDay.Format(partitionDayFormat))
+				if _, err := tx.Exec(ctx, "DROP TABLE IF EXISTS "+quoteProtect(name)); err != nil {
+					return fmt.Errorf("drop %s: %w", name, err)
+				}
+				dropped = append(dropped, name)
+			}
+			return nil
+		})
+		if err != nil {
+			return result, err
+		}
+		result.RolledUp += rolledUp
+		result.Dropped = append(result.Dropped, dropped...)
+	}
+
+	return result, nil
+}
+
+// RunMaintenance keeps partitions created ahead of time and, when a retention window is set, prunes
+// old days. It runs once straight away and then every opts.Interval until ctx is done.
+func (db *Database) RunMaintenance(ctx context.Context, opts RetentionOptions) error {
+	interval := opts.Interval
+	if interval <= 0 {
+		interval = defaultMaintenanceInterval
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		now := time.Now()
+		if _, err := db.EnsurePartitions(ctx, now, now.Add(partitionLeadDays*day)); err != nil {
+			log.Printf("partition maintenance: %v", err)
+		}
+
+		result, err := db.Prune(ctx, now, opts)
+		if err != nil {
+			log.Printf("retention: %v", err)
+		} else if len(result.Days) > 0 {
+			log.Printf("retention: dropped %d day(s) of realtime data, %d route summaries", len(result.Days), result.RolledUp)
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package db
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// Tx is a transaction on one pooled connection. It can be handed to anything taking a DBTX or
+// CopyCapable, so the same queries and bulk loads run inside or outside a transaction.
+type Tx struct {
+	tx pgx.Tx
+}
+
+var (
+	_ DBTX        = (*Tx)(nil)
+	_ CopyCapable = (*Tx)(nil)
+)
+
+func (db *Database) BeginTx(ctx context.Context, opts pgx.TxOptions) (*Tx, error) {
+	tx, err := db.pool.BeginTx(ctx, opts)
+	if err != nil {
+		return nil, fmt.Errorf("begin transaction: %w", err)
+	}
+	return &Tx{tx: tx}, nil
+}
+
+// WithTx runs fn in a transaction, committing if it returns nil and rolling back otherwise
+func (db *Database) WithTx(ctx context.Context, fn func(tx *Tx) error) error {
+	tx, err := db.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	if err := fn(tx); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+func (tx *Tx) Commit(ctx context.Context) error {
+	return tx.tx.Commit(ctx)
+}
+
+// Rollback is a no-op once the transaction has been committed
+func (tx *Tx) Rollback(ctx context.Context) error {
+	return tx.tx.Rollback(ctx)
+}
+
+func (tx *Tx) Exec(ctx context.Context, query string, args ...any) (pgconn.CommandTag, error) {
+	return tx.tx.Exec(ctx, query, args...)
+}
+
+func (tx *Tx) Query(ctx context.Context, query string, args ...any) (pgx.Rows, error) {
+	return tx.tx.Query(ctx, query, args...)
+}
+
+func (tx *Tx) QueryRow(ctx context.Context, query string, args ...any) pgx.Row {
+	return tx.tx.QueryRow(ctx, query, args...)
+}
+
+func (tx *Tx) CopyFrom(ctx context.Context, table string, columns []string, filePath string) (int64, error) {
+	return copyFromCSVFile(ctx, tx.tx.Conn().PgConn(), table, columns, filePath)
+}
+
+func (tx *Tx) CopyFromSlice(ctx context.Context, table string, columns []string, size int, producer func(i int) ([]any, error)) (int64, error) {
+	res, err := tx.tx.CopyFrom(ctx, pgx.Identifier{table}, columns, pgx.CopyFromSlice(size, producer))
+	if err != nil {
+		return 0, fmt.Errorf("failed to copy from slice: %w", err)
+	}
+	return res, nil
+}
+
+func (tx *Tx) CopyFromSource(ctx context.Context, table string, columns []string, source pgx.CopyFromSource) (int64, error) {
+	res, err := tx.tx.CopyFrom(ctx, pgx.Identifier{table}, columns, source)
+	if err != nil {
+		return 0, fmt.Errorf("failed to copy from source: %w", err)
+	}
+	return res, nil
+}
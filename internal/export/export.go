@@ -0,0 +1,325 @@
+package export
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/MobilityData/gtfs-realtime-bindings/golang/gtfs"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"tarediiran-industries.com/gtfs-services/internal/platform"
+)
+
+const (
+	// One StopTimeRow per line
+	FormatNDJSON = "ndjson"
+	// StopTimeRow columns, with a header line
+	FormatCSV = "csv"
+	// One line per frame, the whole feed message as protojson along with the frame's metadata
+	FormatProtoJSON = "protojson"
+)
+
+var Formats = []string{FormatNDJSON, FormatCSV, FormatProtoJSON}
+
+// Exporter writes frames of a recording in one of the export formats. Frames without a payload are
+// only written by the protojson format.
+type Exporter interface {
+	Write(frame platform.FeedFrame, message *gtfs.FeedMessage) error
+	Close() error
+}
+
+func NewExporter(format string, writer io.Writer) (Exporter, error) {
+	switch format {
+	case FormatNDJSON:
+		return &ndjsonExporter{writer: bufio.NewWriter(writer)}, nil
+	case FormatCSV:
+		return &csvExporter{writer: csv.NewWriter(writer)}, nil
+	case FormatProtoJSON:
+		return &protojsonExporter{writer: bufio.NewWriter(writer)}, nil
+	default:
+		return nil, fmt.Errorf("unknown export format %q, expected one of %v", format, Formats)
+	}
+}
+
+// Decode parses a frame's payload, nil for frames recorded without one
+func Decode(frame platform.FeedFrame) (*gtfs.FeedMessage, error) {
+	if !frame.HasPayload() {
+		return nil, nil
+	}
+	message := &gtfs.FeedMessage{}
+	if err := proto.Unmarshal(frame.Body, message); err != nil {
+		return nil, fmt.Errorf("decode %s: %w", frame, err)
+	}
+	return message, nil
+}
+
+type ndjsonExporter struct {
+	writer *bufio.Writer
+}
+
+func (exporter *ndjsonExporter) Write(frame platform.FeedFrame, message *gtfs.FeedMessage) error {
+	for _, row := range StopTimeRows(frame, message) {
+		line, err := json.Marshal(&row)
+		if err != nil {
+			return err
+		}
+		if _, err := exporter.writer.Write(append(line, '\n')); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (exporter *ndjsonExporter) Close() error {
+	return exporter.writer.Flush()
+}
+
+type csvExporter struct {
+	writer      *csv.Writer
+	wroteHeader bool
+}
+
+func (exporter *csvExporter) Write(frame platform.FeedFrame, message *gtfs.FeedMessage) error {
+	if !exporter.wroteHeader {
+		if err := exporter.writer.Write(stopTimeColumns); err != nil {
+			return err
+		}
+		exporter.wroteHeader = true
+	}
+
+	for _, row := range StopTimeRows(frame, message) {
+		if err := exporter.writer.Write(row.record()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (exporter *csvExporter) Close() error {
+	exporter.writer.Flush()
+	return exporter.writer.Error()
+}
+
+type protojsonExporter struct {
+	writer *bufio.Writer
+}
+
+type protojsonFrame struct {
+	FeedID     string          `json:"feed_id"`
+	CapturedAt time.Time       `json:"captured_at"`
+	Status     int             `json:"status,omitempty"`
+	Error      string          `json:"error,omitempty"`
+	Message    json.RawMessage `json:"message,omitempty"`
+}
+
+func (exporter *protojsonExporter) Write(frame platform.FeedFrame, message *gtfs.FeedMessage) error {
+	record := protojsonFrame{
+		FeedID:     frame.FeedID,
+		CapturedAt: frame.CapturedAt,
+		Status:     frame.Status,
+		Error:      frame.FetchError,
+	}
+
+	if message != nil {
+		encoded, err := MarshalProtoJSON(message)
+		if err != nil {
+			return err
+		}
+		record.Message = encoded
+	}
+
+	line, err := json.Marshal(&record)
+	if err != nil {
+		return err
+	}
+	_, err = exporter.writer.Write(append(line, '\n'))
+	return err
+}
+
+func (exporter *protojsonExporter) Close() error {
+	return exporter.writer.Flush()
+}
+
+// MarshalProtoJSON encodes message as protojson with proto field names, adding the NYCT extensions
+// under the keys protojson would give them had they been registered
+func MarshalProtoJSON(message *gtfs.FeedMessage) (json.RawMessage, error) {
+	encoded, err := protojson.MarshalOptions{UseProtoNames: true}.Marshal(message)
+	if err != nil {
+		return nil, err
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(encoded))
+	decoder.UseNumber()
+	tree := map[string]any{}
+	if err := decoder.Decode(&tree); err != nil {
+		return nil, err
+	}
+
+	if header := decodeNyctFeedHeader(message.GetHeader()); header != nil {
+		setChild(tree, header, "header", "[transit_realtime.nyct_feed_header]")
+	}
+
+	entities, _ := tree["entity"].([]any)
+	for i, entity := range message.GetEntity() {
+		if i >= len(entities) {
+			break
+		}
+		entityTree, _ := entities[i].(map[string]any)
+		tripUpdate := entity.GetTripUpdate()
+		if tripUpdate == nil || entityTree == nil {
+			continue
+		}
+		tripUpdateTree, _ := entityTree["trip_update"].(map[string]any)
+
+		if trip := decodeNyctTripDescriptor(tripUpdate.GetTrip()); trip != nil {
+			setChild(tripUpdateTree, trip, "trip", "[transit_realtime.nyct_trip_descriptor]")
+		}
+
+		stopTimes, _ := tripUpdateTree["stop_time_update"].([]any)
+		for j, stopTime := range tripUpdate.GetStopTimeUpdate() {
+			if j >= len(stopTimes) {
+				break
+			}
+			if extension := decodeNyctStopTimeUpdate(stopTime); extension != nil {
+				if stopTimeTree, ok := stopTimes[j].(map[string]any); ok {
+					stopTimeTree["[transit_realtime.nyct_stop_time_update]"] = extension
+				}
+			}
+		}
+	}
+
+	return json.Marshal(tree)
+}
+
+func setChild(tree map[string]any, value any, child string, key string) {
+	if tree == nil {
+		return
+	}
+	if childTree, ok := tree[child].(map[string]any); ok {
+		childTree[key] = value
+	}
+}
+
+// StopTimeRow is one predicted stop of one trip update in one frame. Trip updates without stop
+// time updates get a single row with the stop columns empty.
+type StopTimeRow struct {
+	FeedID          string    `json:"feed_id"`
+	CapturedAt      time.Time `json:"captured_at"`
+	HeaderTimestamp uint64    `json:"header_timestamp"`
+
+	EntityID         string  `json:"entity_id"`
+	TripID           string  `json:"trip_id"`
+	RouteID          string  `json:"route_id"`
+	StartDate        string  `json:"start_date"`
+	StartTime        string  `json:"start_time"`
+	DirectionID      *uint32 `json:"direction_id"`
+	TripRelationship string  `json:"trip_schedule_relationship"`
+	TripTimestamp    uint64  `json:"trip_timestamp"`
+	TrainID          string  `json:"train_id"`
+	NyctDirection    string  `json:"nyct_direction"`
+	IsAssigned       *bool   `json:"is_assigned"`
+
+	StopSequence     *uint32 `json:"stop_sequence"`
+	StopID           string  `json:"stop_id"`
+	ArrivalTime      *int64  `json:"arrival_time"`
+	ArrivalDelay     *int32  `json:"arrival_delay"`
+	DepartureTime    *int64  `json:"departure_time"`
+	DepartureDelay   *int32  `json:"departure_delay"`
+	StopRelationship string  `json:"stop_schedule_relationship"`
+	ScheduledTrack   string  `json:"scheduled_track"`
+	ActualTrack      string  `json:"actual_track"`
+}
+
+var stopTimeColumns = []string{
+	"feed_id", "captured_at", "header_timestamp",
+	"entity_id", "trip_id", "route_id", "start_date", "start_time", "direction_id",
+	"trip_schedule_relationship", "trip_timestamp", "train_id", "nyct_direction", "is_assigned",
+	"stop_sequence", "stop_id", "arrival_time", "arrival_delay", "departure_time", "departure_delay",
+	"stop_schedule_relationship", "scheduled_track", "actual_track",
+}
+
+func optional[T any](value *T) string {
+	if value == nil {
+		return ""
+	}
+	return fmt.Sprint(*value)
+}
+
+func (row StopTimeRow) record() []string {
+	return []string{
+		row.FeedID, row.CapturedAt.Format(time.RFC3339Nano), strconv.FormatUint(row.HeaderTimestamp, 10),
+		row.EntityID, row.TripID, row.RouteID, row.StartDate, row.StartTime, optional(row.DirectionID),
+		row.TripRelationship, strconv.FormatUint(row.TripTimestamp, 10), row.TrainID, row.NyctDirection, optional(row.IsAssigned),
+		optional(row.StopSequence), row.StopID, optional(row.ArrivalTime), optional(row.ArrivalDelay),
+		optional(row.DepartureTime), optional(row.DepartureDelay),
+		row.StopRelationship, row.ScheduledTrack, row.ActualTrack,
+	}
+}
+
+// StopTimeRows flattens the trip updates of a frame
+func StopTimeRows(frame platform.FeedFrame, message *gtfs.FeedMessage) []StopTimeRow {
+	if message == nil {
+		return nil
+	}
+
+	rows := make([]StopTimeRow, 0)
+	for _, entity := range message.GetEntity() {
+		tripUpdate := entity.GetTripUpdate()
+		if tripUpdate == nil {
+			continue
+		}
+		trip := tripUpdate.GetTrip()
+
+		tripRow := StopTimeRow{
+			FeedID:           frame.FeedID,
+			CapturedAt:       frame.CapturedAt,
+			HeaderTimestamp:  message.GetHeader().GetTimestamp(),
+			EntityID:         entity.GetId(),
+			TripID:           trip.GetTripId(),
+			RouteID:          trip.GetRouteId(),
+			StartDate:        trip.GetStartDate(),
+			StartTime:        trip.GetStartTime(),
+			DirectionID:      trip.DirectionId,
+			TripRelationship: trip.GetScheduleRelationship().String(),
+			TripTimestamp:    tripUpdate.GetTimestamp(),
+		}
+		if nyctTrip := decodeNyctTripDescriptor(trip); nyctTrip != nil {
+			tripRow.TrainID = nyctTrip.TrainID
+			tripRow.NyctDirection = nyctTrip.Direction
+			tripRow.IsAssigned = &nyctTrip.IsAssigned
+		}
+
+		stopTimes := tripUpdate.GetStopTimeUpdate()
+		if len(stopTimes) == 0 {
+			rows = append(rows, tripRow)
+			continue
+		}
+
+		for _, stopTime := range stopTimes {
+			row := tripRow
+			row.StopSequence = stopTime.StopSequence
+			row.StopID = stopTime.GetStopId()
+			row.StopRelationship = stopTime.GetScheduleRelationship().String()
+			if arrival := stopTime.GetArrival(); arrival != nil {
+				row.ArrivalTime = arrival.Time
+				row.ArrivalDelay = arrival.Delay
+			}
+			if departure := stopTime.GetDeparture(); departure != nil {
+				row.DepartureTime = departure.Time
+				row.DepartureDelay = departure.Delay
+			}
+			if nyctStopTime := decodeNyctStopTimeUpdate(stopTime); nyctStopTime != nil {
+				row.ScheduledTrack = nyctStopTime.ScheduledTrack
+				row.ActualTrack = nyctStopTime.ActualTrack
+			}
+			rows = append(rows, row)
+		}
+	}
+	return rows
+}
@@ -0,0 +1,155 @@
+package export
+
+import (
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+)
+
+// The MTA's extensions to GTFS-RT from nyct-subway.proto, all registered as field 1001 of the
+// message they extend. The bindings don't include them, so they are left in each message's unknown
+// fields and read from there.
+const nyctExtensionField protowire.Number = 1001
+
+type NyctFeedHeader struct {
+	SubwayVersion          string                      `json:"nyct_subway_version,omitempty"`
+	TripReplacementPeriods []NyctTripReplacementPeriod `json:"trip_replacement_period,omitempty"`
+}
+
+type NyctTripReplacementPeriod struct {
+	RouteID string `json:"route_id,omitempty"`
+	// End of the window in which the feed replaces scheduled trips, as unix seconds
+	End uint64 `json:"replacement_period_end,omitempty"`
+}
+
+type NyctTripDescriptor struct {
+	TrainID    string `json:"train_id,omitempty"`
+	IsAssigned bool   `json:"is_assigned,omitempty"`
+	Direction  string `json:"direction,omitempty"`
+}
+
+type NyctStopTimeUpdate struct {
+	ScheduledTrack string `json:"scheduled_track,omitempty"`
+	ActualTrack    string `json:"actual_track,omitempty"`
+}
+
+var nyctDirections = map[uint64]string{1: "NORTH", 2: "EAST", 3: "SOUTH", 4: "WEST"}
+
+// nyctExtension returns the encoded extension message carried by message, if any
+func nyctExtension(message proto.Message) []byte {
+	if message == nil {
+		return nil
+	}
+	unknown := message.ProtoReflect().GetUnknown()
+	var found []byte
+	eachField(unknown, func(number protowire.Number, wireType protowire.Type, value []byte, _ uint64) {
+		if number == nyctExtensionField && wireType == protowire.BytesType {
+			found = value
+		}
+	})
+	return found
+}
+
+// eachField walks the fields of an encoded message, handing over the bytes of length-delimited
+// fields and the value of varints. It stops at the first malformed field.
+func eachField(buffer []byte, visit func(number protowire.Number, wireType protowire.Type, value []byte, varint uint64)) {
+	for len(buffer) > 0 {
+		number, wireType, n := protowire.ConsumeTag(buffer)
+		if n < 0 {
+			return
+		}
+		buffer = buffer[n:]
+
+		switch wireType {
+		case protowire.VarintType:
+			value, n := protowire.ConsumeVarint(buffer)
+			if n < 0 {
+				return
+			}
+			visit(number, wireType, nil, value)
+			buffer = buffer[n:]
+		case protowire.BytesType:
+			value, n := protowire.ConsumeBytes(buffer)
+			if n < 0 {
+				return
+			}
+			visit(number, wireType, value, 0)
+			buffer = buffer[n:]
+		default:
+			n := protowire.ConsumeFieldValue(number, wireType, buffer)
+			if n < 0 {
+				return
+			}
+			buffer = buffer[n:]
+		}
+	}
+}
+
+func decodeNyctFeedHeader(message proto.Message) *NyctFeedHeader {
+	encoded := nyctExtension(message)
+	if encoded == nil {
+		return nil
+	}
+
+	header := &NyctFeedHeader{}
+	eachField(encoded, func(number protowire.Number, wireType protowire.Type, value []byte, _ uint64) {
+		switch {
+		case number == 1 && wireType == protowire.BytesType:
+			header.SubwayVersion = string(value)
+		case number == 2 && wireType == protowire.BytesType:
+			period := NyctTripReplacementPeriod{}
+			eachField(value, func(number protowire.Number, wireType protowire.Type, value []byte, _ uint64) {
+				switch {
+				case number == 1 && wireType == protowire.BytesType:
+					period.RouteID = string(value)
+				case number == 2 && wireType == protowire.BytesType:
+					// A TimeRange, only its end is set
+					eachField(value, func(number protowire.Number, wireType protowire.Type, _ []byte, varint uint64) {
+						if number == 2 && wireType == protowire.VarintType {
+							period.End = varint
+						}
+					})
+				}
+			})
+			header.TripReplacementPeriods = append(header.TripReplacementPeriods, period)
+		}
+	})
+	return header
+}
+
+func decodeNyctTripDescriptor(message proto.Message) *NyctTripDescriptor {
+	encoded := nyctExtension(message)
+	if encoded == nil {
+		return nil
+	}
+
+	trip := &NyctTripDescriptor{}
+	eachField(encoded, func(number protowire.Number, wireType protowire.Type, value []byte, varint uint64) {
+		switch {
+		case number == 1 && wireType == protowire.BytesType:
+			trip.TrainID = string(value)
+		case number == 2 && wireType == protowire.VarintType:
+			trip.IsAssigned = varint != 0
+		case number == 3 && wireType == protowire.VarintType:
+			trip.Direction = nyctDirections[varint]
+		}
+	})
+	return trip
+}
+
+func decodeNyctStopTimeUpdate(message proto.Message) *NyctStopTimeUpdate {
+	encoded := nyctExtension(message)
+	if encoded == nil {
+		return nil
+	}
+
+	stopTime := &NyctStopTimeUpdate{}
+	eachField(encoded, func(number protowire.Number, wireType protowire.Type, value []byte, _ uint64) {
+		switch {
+		case number == 1 && wireType == protowire.BytesType:
+			stopTime.ScheduledTrack = string(value)
+		case number == 2 && wireType == protowire.BytesType:
+			stopTime.ActualTrack = string(value)
+		}
+	})
+	return stopTime
+}
@@ -0,0 +1,90 @@
+package gtfs_rt
+
+import (
+	"slices"
+	"time"
+
+	"github.com/MobilityData/gtfs-realtime-bindings/golang/gtfs"
+	"tarediiran-industries.com/gtfs-services/internal/repository"
+)
+
+// English text where the feed offers it, otherwise the first translation
+func translatedText(text *gtfs.TranslatedString) string {
+	translations := text.GetTranslation()
+	for _, translation := range translations {
+		switch translation.GetLanguage() {
+		case "", "en", "en-US", "en-us":
+			return translation.GetText()
+		}
+	}
+	if len(translations) > 0 {
+		return translations[0].GetText()
+	}
+	return ""
+}
+
+func (batch *SnapshotBatch) bufferAlert(entityId string, alert *gtfs.Alert) {
+	record := repository.Alert{
+		FeedID:          batch.Frame.FeedID,
+		ID:              entityId,
+		Cause:           alert.GetCause().String(),
+		Effect:          alert.GetEffect().String(),
+		HeaderText:      translatedText(alert.GetHeaderText()),
+		DescriptionText: translatedText(alert.GetDescriptionText()),
+	}
+
+	// Stored as the span covering every active period, a missing bound on any period leaves that
+	// side open
+	periods := alert.GetActivePeriod()
+	openStart, openEnd := len(periods) == 0, len(periods) == 0
+	for _, period := range periods {
+		if period.GetStart() == 0 {
+			openStart = true
+		} else if start := time.Unix(int64(period.GetStart()), 0); record.ActiveStart.IsZero() || start.Before(record.ActiveStart) {
+			record.ActiveStart = start
+		}
+		if period.GetEnd() == 0 {
+			openEnd = true
+		} else if end := time.Unix(int64(period.GetEnd()), 0); end.After(record.ActiveEnd) {
+			record.ActiveEnd = end
+		}
+	}
+	if openStart {
+		record.ActiveStart = time.Time{}
+	}
+	if openEnd {
+		record.ActiveEnd = time.Time{}
+	}
+
+	for _, informed := range alert.GetInformedEntity() {
+		routeId := informed.GetRouteId()
+		if routeId == "" {
+			routeId = informed.GetTrip().GetRouteId()
+		}
+		if routeId != "" && !slices.Contains(record.RouteIDs, routeId) {
+			record.RouteIDs = append(record.RouteIDs, routeId)
+		}
+		if stopId := informed.GetStopId(); stopId != "" && !slices.Contains(record.StopIDs, stopId) {
+			record.StopIDs = append(record.StopIDs, stopId)
+		}
+	}
+
+	batch.Alerts = append(batch.Alerts, record)
+}
+
+// needsAlertWrite reports whether the feed's stored alerts have to be replaced by the ones in
+// batch. Feeds that have never carried an alert are left alone so trip-only feeds don't pay for it
+// on every snapshot.
+func (ingester *FeedIngester) needsAlertWrite(batch *SnapshotBatch) bool {
+	state := &ingester.state
+	state.lock.Lock()
+	defer state.lock.Unlock()
+	return len(batch.Alerts) > 0 || state.hasAlerts
+}
+
+func (ingester *FeedIngester) recordAlertWrite(batch *SnapshotBatch) {
+	state := &ingester.state
+	state.lock.Lock()
+	defer state.lock.Unlock()
+	state.hasAlerts = len(batch.Alerts) > 0
+}
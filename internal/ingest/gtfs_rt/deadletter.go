@@ -0,0 +1,170 @@
+package gtfs_rt
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"tarediiran-industries.com/gtfs-services/internal/platform"
+)
+
+// DeadLetterStore keeps frames that failed to decode or ingest, with the error text, so they can be
+// retried once the cause is fixed. Each process gets its own recording under dir.
+type DeadLetterStore struct {
+	dir   string
+	feeds []platform.FeedSpec
+
+	lock   sync.Mutex
+	writer *platform.FeedRecordingWriter
+}
+
+func DefaultDeadLetterDir() string {
+	cacheDir, err := os.UserCacheDir()
+	if err != nil {
+		cacheDir = os.TempDir()
+	}
+	return filepath.Join(cacheDir, "gtfs-rt-ingest", "dead-letter")
+}
+
+func NewDeadLetterStore(dir string, feeds []platform.FeedSpec) (*DeadLetterStore, error) {
+	if dir == "" {
+		dir = DefaultDeadLetterDir()
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("create dead-letter dir: %w", err)
+	}
+	return &DeadLetterStore{dir: dir, feeds: feeds}, nil
+}
+
+func (store *DeadLetterStore) Dir() string {
+	return store.dir
+}
+
+func (store *DeadLetterStore) Put(ctx context.Context, frame platform.FeedFrame, cause error) error {
+	if store == nil {
+		return nil
+	}
+
+	store.lock.Lock()
+	defer store.lock.Unlock()
+
+	if store.writer == nil {
+		writer, err := createRecordingIn(store.dir, "dead-letter", store.feeds)
+		if err != nil {
+			return fmt.Errorf("create dead-letter recording: %w", err)
+		}
+		store.writer = writer
+	}
+
+	frame.Error = cause.Error()
+	return store.writer.Append(ctx, frame)
+}
+
+func (store *DeadLetterStore) Close() error {
+	if store == nil {
+		return nil
+	}
+
+	store.lock.Lock()
+	defer store.lock.Unlock()
+
+	if store.writer == nil {
+		return nil
+	}
+	err := store.writer.Close()
+	store.writer = nil
+	return err
+}
+
+type DeadLetterReplayStats struct {
+	Recordings int
+	Frames     int
+	Ingested   int
+	Failed     int
+	// Older than what header dedup has already written, these can never be ingested and are dropped
+	Skipped int
+}
+
+// ReplayDeadLetters retries every dead-lettered frame in the configured directory. Frames that fail
+// again land in a fresh dead-letter recording, so replayed recordings are removed unless keep is set.
+func ReplayDeadLetters(ctx context.Context, config platform.SingleConfig, keep bool) (DeadLetterReplayStats, error) {
+	stats := DeadLetterReplayStats{}
+
+	dir := config.Ingest.DeadLetterDir
+	if dir == "" {
+		dir = DefaultDeadLetterDir()
+	}
+	recordings, err := listRecordings(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return stats, nil
+	}
+	if err != nil {
+		return stats, err
+	}
+
+	ingesterSet, err := NewFeedIngesterSet(ctx, config, platform.NewMetrics(prometheus.NewRegistry()))
+	if err != nil {
+		return stats, err
+	}
+	defer ingesterSet.Stop()
+
+	for _, recordingDir := range recordings {
+		if err := replayDeadLetterRecording(ctx, ingesterSet, recordingDir, &stats); err != nil {
+			return stats, fmt.Errorf("replay %s: %w", recordingDir, err)
+		}
+		stats.Recordings++
+
+		if !keep {
+			if err := os.RemoveAll(recordingDir); err != nil {
+				return stats, err
+			}
+		}
+	}
+
+	return stats, nil
+}
+
+func replayDeadLetterRecording(
+	ctx context.Context, ingesterSet *FeedIngesterSet, recordingDir string, stats *DeadLetterReplayStats,
+) error {
+	reader, err := platform.OpenFeedRecording(recordingDir)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	for {
+		frame, err := reader.Next(ctx)
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		stats.Frames++
+
+		reason, err := ingesterSet.replay(ctx, frame)
+		if err != nil {
+			// Leave the recording in place rather than dead-lettering everything behind an outage
+			if ClassifyError(err) == ErrorDatabaseDown {
+				return err
+			}
+			log.Printf("replay %s: %v", frame, err)
+			stats.Failed++
+			continue
+		}
+		// Header dedup has moved past the frame for good, writing it now would roll the current
+		// trips back
+		if reason == "header_out_of_order" {
+			stats.Skipped++
+			continue
+		}
+		stats.Ingested++
+	}
+}
@@ -0,0 +1,202 @@
+package gtfs_rt
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"slices"
+	"sync"
+
+	"github.com/MobilityData/gtfs-realtime-bindings/golang/gtfs"
+	"google.golang.org/protobuf/proto"
+	"tarediiran-industries.com/gtfs-services/internal/platform"
+	"tarediiran-industries.com/gtfs-services/internal/repository"
+)
+
+type DedupMode string
+
+const (
+	DedupHash      DedupMode = "hash"
+	DedupCanonical DedupMode = "canonical"
+	DedupHeader    DedupMode = "header"
+)
+
+const defaultDedupWindow = 32
+
+func ParseDedupMode(value string) (DedupMode, error) {
+	switch mode := DedupMode(value); mode {
+	case "":
+		return DedupHash, nil
+	case DedupHash, DedupCanonical, DedupHeader:
+		return mode, nil
+	default:
+		return "", fmt.Errorf("unknown dedup mode %q", value)
+	}
+}
+
+// Deduplicator remembers the snapshots a feed has recently written. The pipeline's previous-hash
+// check only catches back-to-back repeats, this also catches mirrors taking turns, reordered
+// entities and, in header mode, frames older than what has already been written.
+type Deduplicator struct {
+	feedID  string
+	mode    DedupMode
+	window  int
+	persist bool
+	repo    *repository.Repository
+
+	lock          sync.Mutex
+	recent        [][32]byte
+	seen          map[[32]byte]struct{}
+	lastTimestamp uint64
+}
+
+func NewDeduplicator(feedID string, config platform.DedupConfig, repo *repository.Repository) (*Deduplicator, error) {
+	mode, err := ParseDedupMode(config.Mode)
+	if err != nil {
+		return nil, fmt.Errorf("feed %s: %w", feedID, err)
+	}
+	window := config.Window
+	if window <= 0 {
+		window = defaultDedupWindow
+	}
+
+	return &Deduplicator{
+		feedID:  feedID,
+		mode:    mode,
+		window:  window,
+		persist: config.Persist,
+		repo:    repo,
+		recent:  make([][32]byte, 0, window),
+		seen:    make(map[[32]byte]struct{}, window),
+	}, nil
+}
+
+func (dedup *Deduplicator) Mode() DedupMode {
+	return dedup.mode
+}
+
+// Load seeds the seen-set from the database when persistence is enabled
+func (dedup *Deduplicator) Load(ctx context.Context) error {
+	if !dedup.persist {
+		return nil
+	}
+
+	seen, err := dedup.repo.RecentSeenSnapshots(ctx, dedup.feedID, dedup.window)
+	if err != nil {
+		return err
+	}
+	lastTimestamp, err := dedup.repo.LatestSeenHeaderTimestamp(ctx, dedup.feedID)
+	if err != nil {
+		return err
+	}
+
+	dedup.lock.Lock()
+	defer dedup.lock.Unlock()
+
+	// Rows came back newest first
+	slices.Reverse(seen)
+	for _, snapshot := range seen {
+		dedup.rememberLocked(snapshot.ContentHash)
+	}
+	dedup.lastTimestamp = uint64(lastTimestamp)
+	return nil
+}
+
+func (dedup *Deduplicator) key(batch *SnapshotBatch) ([32]byte, error) {
+	if dedup.mode == DedupCanonical {
+		return CanonicalHash(batch.Message)
+	}
+	return batch.Frame.SHA256, nil
+}
+
+// Check reports why batch would be a duplicate, or an empty reason if it should be written
+func (dedup *Deduplicator) Check(batch *SnapshotBatch) (string, error) {
+	key, err := dedup.key(batch)
+	if err != nil {
+		return "", err
+	}
+	timestamp := batch.Message.GetHeader().GetTimestamp()
+
+	dedup.lock.Lock()
+	defer dedup.lock.Unlock()
+
+	if dedup.mode == DedupHeader && timestamp > 0 && timestamp <= dedup.lastTimestamp {
+		if timestamp == dedup.lastTimestamp {
+			return "header_repeat", nil
+		}
+		return "header_out_of_order", nil
+	}
+	if _, ok := dedup.seen[key]; ok {
+		return string(dedup.mode), nil
+	}
+	return "", nil
+}
+
+// Record marks batch as written, in memory and in the persisted seen-set if enabled
+func (dedup *Deduplicator) Record(ctx context.Context, batch *SnapshotBatch) error {
+	key, err := dedup.key(batch)
+	if err != nil {
+		return err
+	}
+	timestamp := batch.Message.GetHeader().GetTimestamp()
+
+	dedup.lock.Lock()
+	dedup.rememberLocked(key)
+	dedup.lastTimestamp = max(dedup.lastTimestamp, timestamp)
+	dedup.lock.Unlock()
+
+	if !dedup.persist {
+		return nil
+	}
+	return dedup.repo.RecordSeenSnapshot(ctx, repository.SeenSnapshot{
+		FeedID:          dedup.feedID,
+		ContentHash:     key,
+		HeaderTimestamp: int64(timestamp),
+		SeenAt:          batch.Frame.CapturedAt,
+	})
+}
+
+func (dedup *Deduplicator) rememberLocked(key [32]byte) {
+	if _, ok := dedup.seen[key]; ok {
+		return
+	}
+	if len(dedup.recent) == dedup.window {
+		delete(dedup.seen, dedup.recent[0])
+		dedup.recent = dedup.recent[1:]
+	}
+	dedup.recent = append(dedup.recent, key)
+	dedup.seen[key] = struct{}{}
+}
+
+// CanonicalHash hashes the header and entities of msg independently of entity order
+func CanonicalHash(msg *gtfs.FeedMessage) ([32]byte, error) {
+	options := proto.MarshalOptions{Deterministic: true}
+
+	header, err := options.Marshal(msg.GetHeader())
+	if err != nil {
+		return [32]byte{}, err
+	}
+
+	entities := make([][]byte, 0, len(msg.GetEntity()))
+	for _, entity := range msg.GetEntity() {
+		encoded, err := options.Marshal(entity)
+		if err != nil {
+			return [32]byte{}, err
+		}
+		entities = append(entities, encoded)
+	}
+	slices.SortFunc(entities, bytes.Compare)
+
+	hash := sha256.New()
+	for _, part := range append([][]byte{header}, entities...) {
+		// Length prefixes keep entity boundaries from blurring together
+		hash.Write(binary.AppendUvarint(nil, uint64(len(part))))
+		hash.Write(part)
+	}
+
+	var sum [32]byte
+	copy(sum[:], hash.Sum(nil))
+	return sum, nil
+}
@@ -0,0 +1,157 @@
+package gtfs_rt
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"sync"
+	"time"
+
+	"tarediiran-industries.com/gtfs-services/internal/repository"
+)
+
+type tripKey struct {
+	TripId    string
+	StartDate string
+}
+
+func hashTripUpdate(trip *TripUpdateRecord, stopTimes []StopTimeUpdateRecord) [32]byte {
+	hash := sha256.New()
+	writeString := func(value string) {
+		hash.Write(binary.AppendUvarint(nil, uint64(len(value))))
+		hash.Write([]byte(value))
+	}
+
+	writeString(trip.StartTime)
+	hash.Write(binary.AppendUvarint(nil, uint64(trip.DirectionId)))
+	for _, stopTime := range stopTimes {
+		writeString(stopTime.StopId)
+		hash.Write(binary.AppendVarint(nil, stopTime.ArrivalUTC))
+		hash.Write(binary.AppendVarint(nil, stopTime.DepartureUTC))
+	}
+
+	var sum [32]byte
+	copy(sum[:], hash.Sum(nil))
+	return sum
+}
+
+// tripDelta is what one snapshot changes relative to the trips currently known for the feed
+type tripDelta struct {
+	Changed []*TripUpdateRecord
+	Removed []tripKey
+}
+
+func (delta *tripDelta) Records(
+	batch *SnapshotBatch, snapshotId int64, fetchedAt time.Time,
+) ([]TripUpdateRecord, []StopTimeUpdateRecord) {
+	trips := make([]TripUpdateRecord, 0, len(delta.Changed))
+	stopTimes := make([]StopTimeUpdateRecord, 0)
+
+	for _, trip := range delta.Changed {
+		record := *trip
+		record.SnapshotId = snapshotId
+		record.FetchedAt = fetchedAt
+		trips = append(trips, record)
+
+		for _, stopTime := range batch.StopTimeUpdates[trip.stopTimeStart:trip.stopTimeEnd] {
+			stopTime.SnapshotId = snapshotId
+			stopTime.FetchedAt = fetchedAt
+			stopTimes = append(stopTimes, stopTime)
+		}
+	}
+	return trips, stopTimes
+}
+
+// tripChangeTracker mirrors the content hashes in trip_update_current for one feed, so unchanged
+// trips can be skipped without asking the database.
+type tripChangeTracker struct {
+	lock   sync.Mutex
+	hashes map[tripKey][32]byte
+}
+
+func newTripChangeTracker() *tripChangeTracker {
+	return &tripChangeTracker{hashes: make(map[tripKey][32]byte)}
+}
+
+func (tracker *tripChangeTracker) Diff(batch *SnapshotBatch) tripDelta {
+	tracker.lock.Lock()
+	defer tracker.lock.Unlock()
+
+	// A trip repeated within one snapshot keeps its last occurrence
+	latest := make(map[tripKey]*TripUpdateRecord, len(batch.TripUpdates))
+	order := make([]tripKey, 0, len(batch.TripUpdates))
+	for i := range batch.TripUpdates {
+		trip := &batch.TripUpdates[i]
+		key := tripKey{TripId: trip.TripId, StartDate: trip.StartDate}
+		if _, ok := latest[key]; !ok {
+			order = append(order, key)
+		}
+		latest[key] = trip
+	}
+
+	delta := tripDelta{}
+	for _, key := range order {
+		trip := latest[key]
+		if hash, ok := tracker.hashes[key]; !ok || hash != trip.ContentHash {
+			delta.Changed = append(delta.Changed, trip)
+		}
+	}
+	for key := range tracker.hashes {
+		if _, ok := latest[key]; !ok {
+			delta.Removed = append(delta.Removed, key)
+		}
+	}
+	return delta
+}
+
+func (tracker *tripChangeTracker) Apply(delta tripDelta) {
+	tracker.lock.Lock()
+	defer tracker.lock.Unlock()
+
+	for _, trip := range delta.Changed {
+		tracker.hashes[tripKey{TripId: trip.TripId, StartDate: trip.StartDate}] = trip.ContentHash
+	}
+	for _, key := range delta.Removed {
+		delete(tracker.hashes, key)
+	}
+}
+
+func (ingester *FeedIngester) loadCurrentTrips(ctx context.Context) error {
+	current, err := ingester.repo.CurrentTrips(ctx, ingester.cfg.ID)
+	if err != nil {
+		return err
+	}
+
+	hashes := make(map[tripKey][32]byte, len(current))
+	for _, trip := range current {
+		hashes[tripKey{TripId: trip.TripID, StartDate: trip.StartDate}] = trip.ContentHash
+	}
+
+	ingester.trips.lock.Lock()
+	ingester.trips.hashes = hashes
+	ingester.trips.lock.Unlock()
+	return nil
+}
+
+func (ingester *FeedIngester) updateCurrentTrips(
+	ctx context.Context, repo *repository.Repository, changedAt time.Time, snapshotId int64, delta tripDelta,
+) error {
+	changed := make([]repository.CurrentTrip, len(delta.Changed))
+	for i, trip := range delta.Changed {
+		changed[i] = repository.CurrentTrip{
+			TripKey:     repository.TripKey{TripID: trip.TripId, StartDate: trip.StartDate},
+			StartTime:   trip.StartTime,
+			DirectionID: int16(trip.DirectionId),
+			ContentHash: trip.ContentHash,
+		}
+	}
+	if err := repo.UpsertCurrentTrips(ctx, ingester.cfg.ID, snapshotId, changedAt, changed); err != nil {
+		return err
+	}
+
+	removed := make([]repository.TripKey, len(delta.Removed))
+	for i, key := range delta.Removed {
+		removed[i] = repository.TripKey{TripID: key.TripId, StartDate: key.StartDate}
+	}
+	return repo.DeleteCurrentTrips(ctx, ingester.cfg.ID, removed)
+}
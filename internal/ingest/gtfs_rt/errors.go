@@ -0,0 +1,87 @@
+package gtfs_rt
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	database "tarediiran-industries.com/gtfs-services/internal/db"
+)
+
+type ErrorClass int
+
+const (
+	// Network hiccups and upstream 5xx - retry on the next poll
+	ErrorTransient ErrorClass = iota
+	// The payload itself is broken - retrying the same bytes won't help
+	ErrorBadPayload
+	// Database unreachable - buffer to disk and wait for it to come back
+	ErrorDatabaseDown
+	// Nothing sensible left to do, the process should exit non-zero
+	ErrorFatal
+)
+
+func (class ErrorClass) String() string {
+	switch class {
+	case ErrorTransient:
+		return "transient"
+	case ErrorBadPayload:
+		return "bad_payload"
+	case ErrorDatabaseDown:
+		return "database_down"
+	case ErrorFatal:
+		return "fatal"
+	default:
+		return fmt.Sprintf("ErrorClass(%d)", int(class))
+	}
+}
+
+type ClassifiedError struct {
+	Class ErrorClass
+	Err   error
+}
+
+func (err *ClassifiedError) Error() string {
+	return fmt.Sprintf("%s: %v", err.Class, err.Err)
+}
+
+func (err *ClassifiedError) Unwrap() error {
+	return err.Err
+}
+
+func classify(class ErrorClass, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &ClassifiedError{Class: class, Err: err}
+}
+
+// ClassifyError picks the class attached where the error was raised, falling back to inspecting
+// the error chain for database connection failures.
+func ClassifyError(err error) ErrorClass {
+	var classified *ClassifiedError
+	if errors.As(err, &classified) {
+		return classified.Class
+	}
+	if errors.Is(err, context.Canceled) {
+		return ErrorTransient
+	}
+	if database.IsConnectionError(err) {
+		return ErrorDatabaseDown
+	}
+	return ErrorTransient
+}
+
+// Database errors that aren't connection problems mean the rows we built were rejected
+func classifyDatabaseError(err error) error {
+	if err == nil {
+		return nil
+	}
+	if errors.Is(err, context.Canceled) {
+		return classify(ErrorTransient, err)
+	}
+	if database.IsConnectionError(err) {
+		return classify(ErrorDatabaseDown, err)
+	}
+	return classify(ErrorBadPayload, err)
+}
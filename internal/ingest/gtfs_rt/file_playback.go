@@ -4,7 +4,7 @@ import (
 	"context"
 	"fmt"
 	"io"
-	"time"
+	"log"
 
 	"tarediiran-industries.com/gtfs-services/internal/platform"
 )
@@ -13,6 +13,7 @@ type PlaybackCallback func(ctx context.Context, frame platform.FeedFrame) error
 
 type FilePlayback struct {
 	recording   *platform.FeedRecordingReader
+	filter      platform.FrameFilter
 	ingesterSet *FeedIngesterSet
 	handlers    map[string]PlaybackCallback
 
@@ -32,7 +33,9 @@ func NewFilePlayback(
 	telemetry := config.NewTelemetryServer()
 	metrics := platform.NewMetrics(telemetry.GetRegistry())
 
-	ingesterSet, err := NewFeedIngesterSet(ctx, config)
+	// Playback has no poller to fall behind, so every recorded frame should be ingested
+	config.Pipeline.Overflow = string(OverflowBlock)
+	ingesterSet, err := NewFeedIngesterSet(ctx, config, metrics)
 	if err != nil {
 		return nil, err
 	}
@@ -46,36 +49,64 @@ func NewFilePlayback(
 		telemetry: telemetry,
 	}
 
-	for i, _ := range ingesterSet.ingesters {
-		ingester := &ingesterSet.ingesters[i]
-		playback.SetHandler(
-			ingester.cfg.ID,
-			func(ctx context.Context, frame platform.FeedFrame) error {
-				ingester := ingester
-				return ingester.Ingest(ctx, frame)
-			},
-		)
+	for _, worker := range ingesterSet.Workers() {
+		playback.SetHandler(worker.FeedID(), worker.Submit)
 	}
 
 	playback.telemetry.Start()
 	return playback, nil
 }
 
+// SetFilter limits playback to some feeds and a time window, starting at the first frame in it
+func (playback *FilePlayback) SetFilter(filter platform.FrameFilter) error {
+	playback.filter = filter
+	playback.recording.SetFilter(filter)
+	if filter.From.IsZero() {
+		return playback.recording.Reset()
+	}
+	return playback.recording.SeekTime(filter.From)
+}
+
 func (playback *FilePlayback) SetHandler(feedId string, callback PlaybackCallback) {
 	playback.handlers[feedId] = callback
 }
 
-func (playback *FilePlayback) PlaybackRealtime(ctx context.Context) error {
-	return playback.doPlayback(ctx, true)
+type PlaybackOptions struct {
+	// Paces frames by when they were captured, nil plays them as fast as they can be ingested
+	Clock *PlaybackClock
+	// Start over from the beginning of the selection once the recording runs out
+	Loop bool
+	// Shift every timestamp in a frame so it looks like it was fetched as it is played
+	RebaseTimestamps bool
 }
 
-func (playback *FilePlayback) PlaybackFast(ctx context.Context) error {
-	return playback.doPlayback(ctx, false)
-}
+func (playback *FilePlayback) Play(ctx context.Context, opts PlaybackOptions) error {
+	if opts.Clock == nil {
+		opts.Clock = NewPlaybackClock(SystemClock, 0)
+	}
 
-func (playback *FilePlayback) doPlayback(ctx context.Context, doRealTimeDelays bool) error {
-	lastCapturedAt := time.Time{}
+	if err := playback.ingesterSet.Start(ctx); err != nil {
+		return err
+	}
+
+	for {
+		if err := playback.replayFrames(ctx, opts); err != nil {
+			return err
+		}
+		if !opts.Loop {
+			break
+		}
+		if err := playback.SetFilter(playback.filter); err != nil {
+			return err
+		}
+		// Restart the clock on the first frame of the next pass rather than jumping back in time
+		opts.Clock.Reset()
+	}
+	return playback.ingesterSet.Drain(ctx)
+}
 
+func (playback *FilePlayback) replayFrames(ctx context.Context, opts PlaybackOptions) error {
+	played := 0
 	for {
 		frame, err := playback.recording.Next(ctx)
 		if err == io.EOF {
@@ -91,20 +122,28 @@ func (playback *FilePlayback) doPlayback(ctx context.Context, doRealTimeDelays b
 			continue
 		}
 
-		if err := callback(ctx, frame); err != nil {
+		if err := opts.Clock.WaitUntil(ctx, frame.CapturedAt); err != nil {
 			return err
 		}
 
-		if !lastCapturedAt.IsZero() && doRealTimeDelays {
-			delay := frame.CapturedAt.Sub(lastCapturedAt)
-			if delay > 0 {
-				time.Sleep(delay)
+		if opts.RebaseTimestamps {
+			rebased, err := rebaseFrame(frame, opts.Clock.WallNow())
+			if err != nil {
+				log.Printf("rebase %s: %v", frame, err)
+			} else {
+				frame = rebased
 			}
 		}
 
-		lastCapturedAt = frame.CapturedAt
+		if err := callback(ctx, frame); err != nil {
+			return err
+		}
+		played++
 	}
 
+	if played == 0 && opts.Loop {
+		return fmt.Errorf("nothing to loop over, no frames match the playback selection")
+	}
 	return nil
 }
 
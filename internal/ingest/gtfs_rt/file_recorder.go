@@ -2,67 +2,258 @@ package gtfs_rt
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
 
+	"golang.org/x/sync/errgroup"
 	"tarediiran-industries.com/gtfs-services/internal/platform"
 )
 
+const (
+	RotateHourly = "hourly"
+	RotateDaily  = "daily"
+)
+
+// How often the quota is checked between rotations
+const quotaInterval = time.Minute
+
+// RecordOptions control how long a FileRecorder runs and how it splits what it records
+type RecordOptions struct {
+	// RotateHourly or RotateDaily start a new recording at each boundary in local time
+	Rotate string
+	// Start a new recording once the current one reaches this many bytes, zero for no limit
+	RotateBytes int64
+	// Stop cleanly after this long, zero to record until cancelled
+	StopAfter time.Duration
+	Quota     platform.RecordingQuota
+}
+
+func (opts RecordOptions) rotates() bool {
+	return opts.Rotate != "" || opts.RotateBytes > 0
+}
+
 type FileRecorder struct {
 	pollerSet *PollerSet
-	recording *platform.FeedRecordingWriter
 	metrics   *platform.Metrics
 	telemetry *platform.TelemetryServer
+
+	feeds  []platform.FeedSpec
+	header platform.RecordingHeaderOptions
+	opts   RecordOptions
+
+	lock      sync.Mutex
+	recording *platform.FeedRecordingWriter
+	// When the current recording is due to be rotated, zero when rotation isn't by time
+	rotateAt time.Time
+	rotated  chan struct{}
 }
 
 func WritePollResult(
 	ctx context.Context, writer *platform.FeedRecordingWriter, result PollResult,
 ) error {
-	frame := result.ToFeedFrame()
-	fmt.Printf("Wrote: %s\n", frame)
-	return writer.Append(ctx, frame)
+	return writer.Append(ctx, result.ToFeedFrame())
 }
 
 func NewFileRecorder(
-	ctx context.Context, config platform.SingleConfig, opts platform.RecordingHeaderOptions,
+	ctx context.Context, config platform.SingleConfig, header platform.RecordingHeaderOptions, opts RecordOptions,
 ) (*FileRecorder, error) {
-	feeds, err := config.Feed.ToFeedSpecs()
-	if err != nil {
-		return nil, err
+	switch opts.Rotate {
+	case "", RotateHourly, RotateDaily:
+	default:
+		return nil, fmt.Errorf("unknown rotation %q, expected %s or %s", opts.Rotate, RotateHourly, RotateDaily)
 	}
 
-	recording, err := platform.CreateFeedRecording(feeds, opts)
+	feeds, err := config.Feed.ToFeedSpecs()
 	if err != nil {
 		return nil, err
 	}
 
-	pollerSet, err := NewPollerSet(ctx, config)
+	telemetry := config.NewTelemetryServer()
+	metrics := platform.NewMetrics(telemetry.GetRegistry())
+
+	pollerSet, err := NewPollerSet(ctx, config, metrics)
 	if err != nil {
 		return nil, err
 	}
 
-	telemetry := config.NewTelemetryServer()
-	metrics := platform.NewMetrics(telemetry.GetRegistry())
-
 	recorder := &FileRecorder{
 		pollerSet: pollerSet,
-		recording: recording,
 		telemetry: telemetry,
 		metrics:   metrics,
+		feeds:     feeds,
+		header:    header,
+		opts:      opts,
+		rotated:   make(chan struct{}, 1),
+	}
+	if err := recorder.open(time.Now()); err != nil {
+		return nil, err
 	}
 
 	return recorder, nil
 }
 
+// nextRotation is the first hour or day boundary after now
+func nextRotation(rotate string, now time.Time) time.Time {
+	switch rotate {
+	case RotateHourly:
+		return time.Date(now.Year(), now.Month(), now.Day(), now.Hour()+1, 0, 0, 0, now.Location())
+	case RotateDaily:
+		return time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, now.Location())
+	default:
+		return time.Time{}
+	}
+}
+
+// open starts the recording to write to from now on. Rotated recordings are named after the
+// recording name and the time they were started, so that they sort in order, and are always new.
+func (recorder *FileRecorder) open(now time.Time) error {
+	header := recorder.header
+	if recorder.opts.rotates() {
+		name := recorder.header.RecordingName + "-" + now.Format("20060102T150405")
+		header.RecordingName = name
+		for n := 2; ; n++ {
+			if _, err := os.Stat(header.GetRecordingPath()); errors.Is(err, fs.ErrNotExist) {
+				break
+			}
+			header.RecordingName = fmt.Sprintf("%s-%d", name, n)
+		}
+		header.CreatedAt = now
+	}
+
+	recording, err := platform.CreateFeedRecording(recorder.feeds, header)
+	if err != nil {
+		return err
+	}
+	if resumed := recording.Resumed(); resumed != nil {
+		log.Printf(
+			"resuming %s after sequence %d, dropped %d bytes of torn frame",
+			recording.RootDir(), resumed.LastSequence, resumed.TruncatedBytes,
+		)
+	}
+
+	recorder.recording = recording
+	recorder.rotateAt = nextRotation(recorder.opts.Rotate, now)
+	return nil
+}
+
+func (recorder *FileRecorder) rotateDue(now time.Time) bool {
+	if !recorder.rotateAt.IsZero() && !now.Before(recorder.rotateAt) {
+		return true
+	}
+	return recorder.opts.RotateBytes > 0 && recorder.recording.Size() >= recorder.opts.RotateBytes
+}
+
+func (recorder *FileRecorder) write(ctx context.Context, result PollResult) error {
+	recorder.lock.Lock()
+	defer recorder.lock.Unlock()
+
+	if now := time.Now(); recorder.rotateDue(now) {
+		previous := recorder.recording
+		if err := previous.Close(); err != nil {
+			return classify(ErrorFatal, fmt.Errorf("close %s: %w", previous.RootDir(), err))
+		}
+		if err := recorder.open(now); err != nil {
+			return classify(ErrorFatal, err)
+		}
+		log.Printf("rotated %s to %s", previous.RootDir(), recorder.recording.RootDir())
+
+		select {
+		case recorder.rotated <- struct{}{}:
+		default:
+		}
+	}
+
+	return WritePollResult(ctx, recorder.recording, result)
+}
+
+// CurrentDir is the directory of the recording being written
+func (recorder *FileRecorder) CurrentDir() string {
+	recorder.lock.Lock()
+	defer recorder.lock.Unlock()
+	return recorder.recording.RootDir()
+}
+
+// enforceQuota checks the quota at the start, after each rotation and every minute
+func (recorder *FileRecorder) enforceQuota(ctx context.Context) error {
+	ticker := time.NewTicker(quotaInterval)
+	defer ticker.Stop()
+
+	warned := false
+	for {
+		report, err := platform.EnforceRecordingQuota(
+			ctx, recorder.header.RecordingPath, recorder.opts.Quota, recorder.CurrentDir(),
+		)
+		for _, dir := range report.Compressed {
+			log.Printf("quota: compressed %s", filepath.Base(dir))
+		}
+		for _, dir := range report.Deleted {
+			log.Printf("quota: deleted %s", filepath.Base(dir))
+		}
+		if err != nil && ctx.Err() == nil {
+			log.Printf("quota: %v", err)
+		}
+		if over := report.UsedBytes > recorder.opts.Quota.MaxBytes; over && !warned {
+			log.Printf(
+				"quota: %s used of %s with nothing left to free but the active recording",
+				platform.FormatByteSize(report.UsedBytes), platform.FormatByteSize(recorder.opts.Quota.MaxBytes),
+			)
+			warned = true
+		} else if !over {
+			warned = false
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		case <-recorder.rotated:
+		}
+	}
+}
+
 func (recorder *FileRecorder) Record(ctx context.Context) error {
-	recorder.pollerSet.SetHandler(func(ctx context.Context, result PollResult) error {
-		return WritePollResult(ctx, recorder.recording, result)
-	})
+	recorder.pollerSet.SetHandler(recorder.write)
+
+	pollCtx := ctx
+	if recorder.opts.StopAfter > 0 {
+		var cancel context.CancelFunc
+		pollCtx, cancel = context.WithTimeout(ctx, recorder.opts.StopAfter)
+		defer cancel()
+	}
 
 	recorder.telemetry.Start()
-	return recorder.pollerSet.Poll(ctx)
+
+	group, groupCtx := errgroup.WithContext(pollCtx)
+	group.Go(func() error {
+		return recorder.pollerSet.Poll(groupCtx)
+	})
+	if recorder.opts.Quota.MaxBytes > 0 {
+		group.Go(func() error {
+			return recorder.enforceQuota(groupCtx)
+		})
+	}
+
+	err := group.Wait()
+	if errors.Is(err, context.DeadlineExceeded) && ctx.Err() == nil {
+		log.Printf("stopping after %s", recorder.opts.StopAfter)
+		return nil
+	}
+	return err
 }
 
 func (recorder *FileRecorder) Stop() error {
-	recorder.pollerSet.Stop()
-	return recorder.telemetry.Stop()
+	recorder.lock.Lock()
+	defer recorder.lock.Unlock()
+
+	if err := recorder.telemetry.Stop(); err != nil {
+		recorder.recording.Close()
+		return err
+	}
+	return recorder.recording.Close()
 }
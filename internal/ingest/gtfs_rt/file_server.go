@@ -0,0 +1,262 @@
+package gtfs_rt
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/errgroup"
+	"tarediiran-industries.com/gtfs-services/internal/platform"
+)
+
+// RecordingServer serves each feed of a recording at /feeds/{feed_id}, answering with whatever
+// was fetched last as of the playback clock: the same status, headers, body and latency, or a
+// dropped connection for a transport failure.
+type RecordingServer struct {
+	recording *platform.FeedRecordingReader
+	filter    platform.FrameFilter
+	clock     *PlaybackClock
+	loop      bool
+	server    *http.Server
+
+	lock    sync.RWMutex
+	current map[string]platform.FeedFrame
+}
+
+type RecordingServerOptions struct {
+	ListenAddr string
+	Filter     platform.FrameFilter
+	// Paces the recording, a zero speed is treated as real time
+	Clock *PlaybackClock
+	Loop  bool
+}
+
+func NewRecordingServer(recordingDir string, opts RecordingServerOptions) (*RecordingServer, error) {
+	recording, err := platform.OpenFeedRecording(recordingDir)
+	if err != nil {
+		return nil, err
+	}
+
+	clock := opts.Clock
+	if clock == nil || clock.Speed() == 0 {
+		clock = NewPlaybackClock(SystemClock, 1)
+	}
+
+	server := &RecordingServer{
+		recording: recording,
+		filter:    opts.Filter,
+		clock:     clock,
+		loop:      opts.Loop,
+		current:   make(map[string]platform.FeedFrame),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /feeds/{feed_id}", server.handleFeed)
+	mux.HandleFunc("GET /{$}", server.handleIndex)
+	server.server = &http.Server{
+		Addr:    opts.ListenAddr,
+		Handler: mux,
+	}
+
+	return server, nil
+}
+
+// URL is where a feed is served, for pointing a config's rt_url at this server
+func (server *RecordingServer) URL(feedID string) string {
+	return fmt.Sprintf("http://%s/feeds/%s", server.server.Addr, feedID)
+}
+
+func (server *RecordingServer) Serve(ctx context.Context) error {
+	for _, feed := range server.recording.Header().Feeds {
+		if len(server.filter.FeedIDs) == 0 || slices.Contains(server.filter.FeedIDs, feed.FeedID) {
+			log.Printf("serving %s at %s", feed.FeedID, server.URL(feed.FeedID))
+		}
+	}
+
+	group, groupCtx := errgroup.WithContext(ctx)
+	group.Go(func() error {
+		err := server.server.ListenAndServe()
+		if errors.Is(err, http.ErrServerClosed) {
+			return nil
+		}
+		return err
+	})
+	group.Go(func() error {
+		<-groupCtx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		return server.server.Shutdown(shutdownCtx)
+	})
+	group.Go(func() error {
+		err := server.advance(groupCtx)
+		if err == nil {
+			log.Printf("end of recording, still serving the last frame of each feed")
+			return nil
+		}
+		if errors.Is(err, context.Canceled) {
+			return nil
+		}
+		return err
+	})
+	return group.Wait()
+}
+
+// advance moves each feed's current frame along with the playback clock
+func (server *RecordingServer) advance(ctx context.Context) error {
+	for {
+		server.recording.SetFilter(server.filter)
+		var err error
+		if server.filter.From.IsZero() {
+			err = server.recording.Reset()
+		} else {
+			err = server.recording.SeekTime(server.filter.From)
+		}
+		if err != nil {
+			return err
+		}
+
+		played := 0
+		for {
+			frame, err := server.recording.Next(ctx)
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return err
+			}
+			if err := server.clock.WaitUntil(ctx, frame.CapturedAt); err != nil {
+				return err
+			}
+			played++
+
+			// A 304 means the previous body was still current
+			if frame.Status == http.StatusNotModified {
+				continue
+			}
+			server.lock.Lock()
+			server.current[frame.FeedID] = frame
+			server.lock.Unlock()
+		}
+
+		if !server.loop {
+			return nil
+		}
+		if played == 0 {
+			return fmt.Errorf("nothing to loop over, no frames match the selection")
+		}
+		server.clock.Reset()
+	}
+}
+
+func (server *RecordingServer) handleIndex(writer http.ResponseWriter, request *http.Request) {
+	type feedState struct {
+		URL        string    `json:"url"`
+		CapturedAt time.Time `json:"captured_at,omitzero"`
+		Status     int       `json:"status,omitempty"`
+		Error      string    `json:"error,omitempty"`
+	}
+
+	feeds := make(map[string]feedState)
+	server.lock.RLock()
+	for _, feed := range server.recording.Header().Feeds {
+		frame := server.current[feed.FeedID]
+		feeds[feed.FeedID] = feedState{
+			URL:        server.URL(feed.FeedID),
+			CapturedAt: frame.CapturedAt,
+			Status:     frame.Status,
+			Error:      frame.FetchError,
+		}
+	}
+	server.lock.RUnlock()
+
+	writer.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(writer).Encode(map[string]any{
+		"recording":   server.recording.Header().RecordingName,
+		"playback_at": server.clock.RecordedNow(),
+		"feeds":       feeds,
+	})
+}
+
+func (server *RecordingServer) handleFeed(writer http.ResponseWriter, request *http.Request) {
+	feedId := request.PathValue("feed_id")
+
+	server.lock.RLock()
+	frame, ok := server.current[feedId]
+	server.lock.RUnlock()
+
+	if !ok {
+		writer.Header().Set("Retry-After", "1")
+		http.Error(writer, fmt.Sprintf("no frame for %s yet", feedId), http.StatusServiceUnavailable)
+		return
+	}
+
+	select {
+	case <-request.Context().Done():
+		return
+	case <-time.After(frame.Duration):
+	}
+
+	if frame.FetchError != "" {
+		dropConnection(writer)
+		return
+	}
+
+	headers := writer.Header()
+	if frame.Headers.ETag != "" {
+		headers.Set("ETag", frame.Headers.ETag)
+	}
+	if frame.Headers.LastModified != "" {
+		headers.Set("Last-Modified", frame.Headers.LastModified)
+	}
+	if frame.Headers.ContentType != "" {
+		headers.Set("Content-Type", frame.Headers.ContentType)
+	}
+
+	status := frame.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	if status == http.StatusOK && notModified(request, frame.Headers) {
+		writer.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	headers.Set("Content-Length", strconv.Itoa(len(frame.Body)))
+	writer.WriteHeader(status)
+	writer.Write(frame.Body)
+}
+
+func notModified(request *http.Request, headers platform.FrameHeaders) bool {
+	if etag := request.Header.Get("If-None-Match"); etag != "" {
+		return etag == headers.ETag
+	}
+	since := request.Header.Get("If-Modified-Since")
+	return since != "" && since == headers.LastModified
+}
+
+// dropConnection closes the connection without a response, which is how a transport failure
+// looks to the poller
+func dropConnection(writer http.ResponseWriter) {
+	hijacker, ok := writer.(http.Hijacker)
+	if !ok {
+		http.Error(writer, "transport failure", http.StatusBadGateway)
+		return
+	}
+	conn, _, err := hijacker.Hijack()
+	if err != nil {
+		return
+	}
+	conn.Close()
+}
+
+func (server *RecordingServer) Close() error {
+	return server.recording.Close()
+}
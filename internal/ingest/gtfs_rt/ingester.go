@@ -1,14 +1,17 @@
 package gtfs_rt
 
 import (
-	"bytes"
 	"context"
 	"fmt"
+	"log"
+	"sync"
+	"time"
 
 	"github.com/MobilityData/gtfs-realtime-bindings/golang/gtfs"
 	"google.golang.org/protobuf/proto"
 	database "tarediiran-industries.com/gtfs-services/internal/db"
 	"tarediiran-industries.com/gtfs-services/internal/platform"
+	"tarediiran-industries.com/gtfs-services/internal/repository"
 )
 
 type GtfsRecord interface {
@@ -18,14 +21,20 @@ type GtfsRecord interface {
 
 type TripUpdateRecord struct {
 	SnapshotId  int64
+	FetchedAt   time.Time
 	TripId      string
 	StartDate   string
 	StartTime   string
 	DirectionId uint32
+
+	// Hash of everything predicted for the trip, and where its stop times sit in the batch
+	ContentHash   [32]byte
+	stopTimeStart int
+	stopTimeEnd   int
 }
 
 func TripUpdateColumns() []string {
-	return []string{"trip_id", "start_date", "start_time", "direction_id", "snapshot_id"}
+	return []string{"trip_id", "start_date", "start_time", "direction_id", "snapshot_id", "fetched_at"}
 }
 
 func (entry *TripUpdateRecord) ToAnyArray() []any {
@@ -35,106 +44,147 @@ func (entry *TripUpdateRecord) ToAnyArray() []any {
 		entry.StartTime,
 		entry.DirectionId,
 		entry.SnapshotId,
+		entry.FetchedAt,
 	}
 }
 
 type StopTimeUpdateRecord struct {
 	SnapshotId   int64
+	FetchedAt    time.Time
+	TripId       string
+	StartDate    string
 	StopId       string
 	ArrivalUTC   int64
 	DepartureUTC int64
 }
 
 func StopTimeUpdateColumns() []string {
-	return []string{"stop_id", "arrival_time", "departure_time", "snapshot_id"}
+	return []string{"trip_id", "start_date", "stop_id", "arrival_time", "departure_time", "snapshot_id", "fetched_at"}
 }
 
 func (entry *StopTimeUpdateRecord) ToAnyArray() []any {
 	return []any{
+		entry.TripId,
+		entry.StartDate,
 		entry.StopId,
 		entry.ArrivalUTC,
 		entry.DepartureUTC,
 		entry.SnapshotId,
+		entry.FetchedAt,
 	}
 }
 
-type FeedIngester struct {
-	cfg platform.RealTimeConfig
+// SnapshotBatch is one decoded and normalized frame on its way to the database. The write stage
+// only stores the trips that changed since the previous snapshot.
+type SnapshotBatch struct {
+	Frame           platform.FeedFrame
+	Message         *gtfs.FeedMessage
+	TripUpdates     []TripUpdateRecord
+	StopTimeUpdates []StopTimeUpdateRecord
+	Alerts          []repository.Alert
+}
 
-	snapshotId  int64
-	lastHashSum []byte
-	tuBuf       []TripUpdateRecord
-	stuBuf      []StopTimeUpdateRecord
-	db          *database.Database
+// feedIngestState is everything a FeedIngester remembers between frames. Submit can be called
+// from the poller while the pipeline goroutines work, so all access goes through the lock.
+type feedIngestState struct {
+	lock        sync.Mutex
+	lastHashSum [32]byte
+	hasLastHash bool
+	hasAlerts   bool
 }
 
-type FeedIngesterSet struct {
-	cfg platform.SingleConfig
+type FeedIngester struct {
+	cfg platform.RealTimeConfig
 
-	ingesters []FeedIngester
-	db        *database.Database
+	state       feedIngestState
+	db          *database.Database
+	repo        *repository.Repository
+	metrics     *platform.Metrics
+	deadLetters *DeadLetterStore
+	dedup       *Deduplicator
+	trips       *tripChangeTracker
 }
 
-func NewFeedIngesterSet(ctx context.Context, cfg platform.SingleConfig) (*FeedIngesterSet, error) {
-	db, err := cfg.NewDatabase(ctx)
+func NewFeedIngester(
+	cfg platform.RealTimeConfig, db *database.Database, repo *repository.Repository, metrics *platform.Metrics,
+) (*FeedIngester, error) {
+	dedup, err := NewDeduplicator(cfg.ID, cfg.Dedup, repo)
 	if err != nil {
 		return nil, err
 	}
+	return &FeedIngester{
+		cfg:     cfg,
+		db:      db,
+		repo:    repo,
+		metrics: metrics,
+		dedup:   dedup,
+		trips:   newTripChangeTracker(),
+	}, nil
+}
 
-	ingesters := make([]FeedIngester, 0)
-	for _, rtcfg := range cfg.Feed.RealTime {
-		ingester := FeedIngester{
-			cfg:    rtcfg,
-			db:     db,
-			tuBuf:  make([]TripUpdateRecord, 0, 2048),
-			stuBuf: make([]StopTimeUpdateRecord, 0, 2048),
-		}
-		ingesters = append(ingesters, ingester)
+// LoadState picks up where the last run left off: the dedup seen-set and the current trip state
+func (ingester *FeedIngester) LoadState(ctx context.Context) error {
+	if err := ingester.dedup.Load(ctx); err != nil {
+		return err
 	}
 
-	return &FeedIngesterSet{cfg: cfg, ingesters: ingesters, db: db}, nil
+	// Alerts a previous run stored are replaced by whatever the first snapshot carries
+	ingester.state.lock.Lock()
+	ingester.state.hasAlerts = true
+	ingester.state.lock.Unlock()
+
+	return ingester.loadCurrentTrips(ctx)
 }
 
-func (ingester *FeedIngesterSet) Stop() {
-	ingester.db.Close()
+func (ingester *FeedIngester) FeedID() string {
+	return ingester.cfg.ID
 }
 
-func (ingester *FeedIngester) insertFeedSnapshot(ctx context.Context) error {
-	row := ingester.db.QueryRowContext(
-		ctx,
-		"INSERT INTO feed_snapshots DEFAULT VALUES RETURNING snapshot_id",
-	)
+func (ingester *FeedIngester) SetDeadLetterStore(store *DeadLetterStore) {
+	ingester.deadLetters = store
+}
 
-	if err := row.Scan(&ingester.snapshotId); err != nil {
-		return err
+// DeadLetter keeps a frame that failed so it can be retried later. Transient failures such as
+// cancellation say nothing about the payload and are skipped.
+func (ingester *FeedIngester) DeadLetter(ctx context.Context, frame platform.FeedFrame, cause error) {
+	if ClassifyError(cause) == ErrorTransient {
+		return
+	}
+	if err := ingester.deadLetters.Put(ctx, frame, cause); err != nil {
+		log.Printf("dead-letter %s: %v", frame, err)
 	}
-	return nil
 }
 
-func (ingester *FeedIngester) bufferTripUpdate(
-	ctx context.Context, tripUpdate *gtfs.TripUpdate,
-) error {
+// Spooled and replayed frames are written long after they were fetched, so the capture time is
+// what dates a snapshot and picks its partition
+func snapshotTime(frame platform.FeedFrame) time.Time {
+	if frame.CapturedAt.IsZero() {
+		return time.Now()
+	}
+	return frame.CapturedAt
+}
+
+func (batch *SnapshotBatch) bufferTripUpdate(tripUpdate *gtfs.TripUpdate) error {
 	trip := tripUpdate.GetTrip()
 	if trip == nil {
 		return fmt.Errorf("TripUpdate message does not contain a TripDescriptor")
 	}
 
 	tuRecord := TripUpdateRecord{
-		TripId:      trip.GetTripId(),
-		StartDate:   trip.GetStartDate(),
-		StartTime:   trip.GetStartTime(),
-		DirectionId: trip.GetDirectionId(),
-		SnapshotId:  ingester.snapshotId,
+		TripId:        trip.GetTripId(),
+		StartDate:     trip.GetStartDate(),
+		StartTime:     trip.GetStartTime(),
+		DirectionId:   trip.GetDirectionId(),
+		stopTimeStart: len(batch.StopTimeUpdates),
 	}
 
-	ingester.tuBuf = append(ingester.tuBuf, tuRecord)
-
 	for _, stopTimeUpdate := range tripUpdate.GetStopTimeUpdate() {
 		stuRecord := StopTimeUpdateRecord{
-			StopId:       *stopTimeUpdate.StopId,
+			TripId:       tuRecord.TripId,
+			StartDate:    tuRecord.StartDate,
+			StopId:       stopTimeUpdate.GetStopId(),
 			ArrivalUTC:   0,
 			DepartureUTC: 0,
-			SnapshotId:   ingester.snapshotId,
 		}
 
 		if arrival := stopTimeUpdate.GetArrival(); arrival != nil {
@@ -144,76 +194,214 @@ func (ingester *FeedIngester) bufferTripUpdate(
 			stuRecord.DepartureUTC = departure.GetTime()
 		}
 
-		ingester.stuBuf = append(ingester.stuBuf, stuRecord)
+		batch.StopTimeUpdates = append(batch.StopTimeUpdates, stuRecord)
+	}
+
+	tuRecord.stopTimeEnd = len(batch.StopTimeUpdates)
+	tuRecord.ContentHash = hashTripUpdate(&tuRecord, batch.StopTimeUpdates[tuRecord.stopTimeStart:tuRecord.stopTimeEnd])
+	batch.TripUpdates = append(batch.TripUpdates, tuRecord)
+
+	return nil
+}
+
+func (ingester *FeedIngester) copyRecords(
+	ctx context.Context, copier database.CopyCapable, table string, columns []string, size int, producer func(i int) ([]any, error),
+) error {
+	start := time.Now()
+	rows, err := copier.CopyFromSlice(ctx, table, columns, size, producer)
+	if err != nil {
+		return err
 	}
 
+	ingester.metrics.CopyDurationSeconds.WithLabelValues(ingester.cfg.ID, table).Observe(time.Since(start).Seconds())
+	ingester.metrics.CopyRowsTotal.WithLabelValues(ingester.cfg.ID, table).Add(float64(rows))
 	return nil
 }
 
-func (ingester *FeedIngester) flushTripUpdates(ctx context.Context) error {
-	_, err := ingester.db.CopyFromSlice(
+func (ingester *FeedIngester) flushTripUpdates(
+	ctx context.Context, copier database.CopyCapable, trips []TripUpdateRecord, stopTimes []StopTimeUpdateRecord,
+) error {
+	err := ingester.copyRecords(
 		ctx,
+		copier,
 		"trip_update_events",
 		TripUpdateColumns(),
-		len(ingester.tuBuf),
-		func(i int) ([]any, error) { return ingester.tuBuf[i].ToAnyArray(), nil },
+		len(trips),
+		func(i int) ([]any, error) { return trips[i].ToAnyArray(), nil },
 	)
 
 	if err != nil {
 		return err
 	}
-	ingester.tuBuf = make([]TripUpdateRecord, 0, 2048)
 
-	_, err = ingester.db.CopyFromSlice(
+	return ingester.copyRecords(
 		ctx,
+		copier,
 		"trip_update_stop_time_events",
 		StopTimeUpdateColumns(),
-		len(ingester.stuBuf),
-		func(i int) ([]any, error) { return ingester.stuBuf[i].ToAnyArray(), nil },
+		len(stopTimes),
+		func(i int) ([]any, error) { return stopTimes[i].ToAnyArray(), nil },
 	)
+}
 
-	if err != nil {
-		return err
+// IsUnchanged reports whether frame carries the same payload as the last frame seen for this feed
+func (ingester *FeedIngester) IsUnchanged(frame platform.FeedFrame) bool {
+	state := &ingester.state
+	state.lock.Lock()
+	defer state.lock.Unlock()
+
+	if state.hasLastHash && state.lastHashSum == frame.SHA256 {
+		ingester.metrics.FeedUnchangedTotal.WithLabelValues(ingester.cfg.ID).Inc()
+		return true
 	}
-	ingester.stuBuf = make([]StopTimeUpdateRecord, 0, 2048)
 
-	return nil
+	state.lastHashSum = frame.SHA256
+	state.hasLastHash = true
+	return false
 }
 
-func (ingester *FeedIngester) IngestGtfsMessage(ctx context.Context, gtfsMsg *gtfs.FeedMessage) error {
-	if err := ingester.insertFeedSnapshot(ctx); err != nil {
-		return err
+// ForgetHash undoes IsUnchanged for a frame that was accepted but never written, as long as no
+// other frame has been seen since
+func (ingester *FeedIngester) ForgetHash(frame platform.FeedFrame) {
+	state := &ingester.state
+	state.lock.Lock()
+	defer state.lock.Unlock()
+
+	if state.hasLastHash && state.lastHashSum == frame.SHA256 {
+		state.hasLastHash = false
+	}
+}
+
+func (ingester *FeedIngester) Decode(frame platform.FeedFrame) (*gtfs.FeedMessage, error) {
+	gtfsMsg := &gtfs.FeedMessage{}
+	if err := proto.Unmarshal(frame.Body, gtfsMsg); err != nil {
+		ingester.metrics.FeedDecodeErrorsTotal.WithLabelValues(ingester.cfg.ID).Inc()
+		return nil, classify(ErrorBadPayload, err)
+	}
+	return gtfsMsg, nil
+}
+
+func (ingester *FeedIngester) Normalize(frame platform.FeedFrame, gtfsMsg *gtfs.FeedMessage) (*SnapshotBatch, error) {
+	batch := &SnapshotBatch{
+		Frame:           frame,
+		Message:         gtfsMsg,
+		TripUpdates:     make([]TripUpdateRecord, 0, len(gtfsMsg.GetEntity())),
+		StopTimeUpdates: make([]StopTimeUpdateRecord, 0, 2048),
 	}
 
 	for _, entity := range gtfsMsg.GetEntity() {
+		if alert := entity.GetAlert(); alert != nil {
+			batch.bufferAlert(entity.GetId(), alert)
+		}
+
 		tripUpdate := entity.GetTripUpdate()
 		if tripUpdate == nil {
 			continue
 		}
 
-		if err := ingester.bufferTripUpdate(ctx, tripUpdate); err != nil {
-			return err
+		if err := batch.bufferTripUpdate(tripUpdate); err != nil {
+			return nil, classify(ErrorBadPayload, err)
 		}
 	}
 
-	if err := ingester.flushTripUpdates(ctx); err != nil {
-		return err
-	}
+	return batch, nil
+}
 
-	return nil
+func (ingester *FeedIngester) Write(ctx context.Context, batch *SnapshotBatch) error {
+	_, err := ingester.write(ctx, batch)
+	return err
 }
 
-func (ingester *FeedIngester) Ingest(ctx context.Context, frame platform.FeedFrame) error {
-	if bytes.Equal(ingester.lastHashSum, frame.SHA256[:]) {
+// write returns the dedup reason batch was skipped for, empty when it was written
+func (ingester *FeedIngester) write(ctx context.Context, batch *SnapshotBatch) (string, error) {
+	reason, err := ingester.dedup.Check(batch)
+	if err != nil {
+		return "", classify(ErrorBadPayload, err)
+	}
+	if reason != "" {
+		ingester.metrics.FeedDuplicatesTotal.WithLabelValues(ingester.cfg.ID, reason).Inc()
+		return reason, nil
+	}
+
+	// Only trips whose predictions moved are written, the rest stay as they are in trip_update_current
+	fetchedAt := snapshotTime(batch.Frame)
+	delta := ingester.trips.Diff(batch)
+	replaceAlerts := ingester.needsAlertWrite(batch)
+
+	// The snapshot, its changed trips and the current-state tables commit together, so a failure
+	// part way never leaves trip_update_current pointing at a snapshot that isn't there
+	write := func(tx *database.Tx) error {
+		repo := repository.New(tx)
+		snapshotId, err := repo.InsertSnapshot(ctx, ingester.cfg.ID, fetchedAt)
+		if err != nil {
+			return err
+		}
+
+		trips, stopTimes := delta.Records(batch, snapshotId, fetchedAt)
+		if err := ingester.flushTripUpdates(ctx, tx, trips, stopTimes); err != nil {
+			return err
+		}
+		if err := ingester.updateCurrentTrips(ctx, repo, fetchedAt, snapshotId, delta); err != nil {
+			return err
+		}
+		if replaceAlerts {
+			return repo.ReplaceAlerts(ctx, ingester.cfg.ID, snapshotId, fetchedAt, batch.Alerts)
+		}
 		return nil
 	}
 
-	ingester.lastHashSum = frame.SHA256[:]
+	err = ingester.db.WithTx(ctx, write)
+	if database.IsMissingPartition(err) {
+		// Playback of old recordings can reach back past the partitions made ahead of time
+		if _, err := ingester.db.EnsurePartitions(ctx, fetchedAt, fetchedAt); err != nil {
+			return "", classifyDatabaseError(err)
+		}
+		err = ingester.db.WithTx(ctx, write)
+	}
+	if err != nil {
+		return "", classifyDatabaseError(err)
+	}
 
-	gtfsMsg := &gtfs.FeedMessage{}
-	if err := proto.Unmarshal(frame.Body, gtfsMsg); err != nil {
+	ingester.trips.Apply(delta)
+	if replaceAlerts {
+		ingester.recordAlertWrite(batch)
+	}
+
+	// The snapshot is in, so a failure here only weakens dedup across a restart
+	if err := ingester.dedup.Record(ctx, batch); err != nil {
+		log.Printf("record dedup %s: %v", ingester.cfg.ID, err)
+	}
+
+	ingester.metrics.FeedSnapshotsTotal.WithLabelValues(ingester.cfg.ID).Inc()
+	ingester.metrics.FeedEntities.WithLabelValues(ingester.cfg.ID).Set(float64(len(batch.Message.GetEntity())))
+	if timestamp := batch.Message.GetHeader().GetTimestamp(); timestamp > 0 {
+		staleness := time.Since(time.Unix(int64(timestamp), 0))
+		ingester.metrics.FeedStalenessSeconds.WithLabelValues(ingester.cfg.ID).Set(staleness.Seconds())
+	}
+
+	return "", nil
+}
+
+func (ingester *FeedIngester) IngestGtfsMessage(ctx context.Context, frame platform.FeedFrame, gtfsMsg *gtfs.FeedMessage) error {
+	batch, err := ingester.Normalize(frame, gtfsMsg)
+	if err != nil {
 		return err
 	}
+	return ingester.Write(ctx, batch)
+}
 
-	return ingester.IngestGtfsMessage(ctx, gtfsMsg)
+// Ingest runs all stages inline on the caller's goroutine
+func (ingester *FeedIngester) Ingest(ctx context.Context, frame platform.FeedFrame) error {
+	if !frame.HasPayload() || ingester.IsUnchanged(frame) {
+		return nil
+	}
+
+	gtfsMsg, err := ingester.Decode(frame)
+	if err == nil {
+		err = ingester.IngestGtfsMessage(ctx, frame, gtfsMsg)
+	}
+	if err != nil {
+		ingester.DeadLetter(ctx, frame, err)
+	}
+	return err
 }
@@ -0,0 +1,387 @@
+package gtfs_rt
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+
+	"github.com/MobilityData/gtfs-realtime-bindings/golang/gtfs"
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/sync/errgroup"
+	"tarediiran-industries.com/gtfs-services/internal/platform"
+)
+
+type OverflowPolicy string
+
+const (
+	// Block the upstream stage until there's room, which eventually stalls the poller
+	OverflowBlock OverflowPolicy = "block"
+	// Discard the incoming item when the queue is full
+	OverflowDrop OverflowPolicy = "drop"
+	// Discard the oldest queued item so the newest snapshot always makes it through
+	OverflowCoalesce OverflowPolicy = "coalesce"
+)
+
+const (
+	defaultQueueDepth = 4
+	defaultWorkers    = 1
+)
+
+func ParseOverflowPolicy(value string) (OverflowPolicy, error) {
+	switch policy := OverflowPolicy(value); policy {
+	case "":
+		return OverflowCoalesce, nil
+	case OverflowBlock, OverflowDrop, OverflowCoalesce:
+		return policy, nil
+	default:
+		return "", fmt.Errorf("unknown pipeline overflow policy %q", value)
+	}
+}
+
+type pipelineQueue[T any] struct {
+	items   chan T
+	policy  OverflowPolicy
+	depth   prometheus.Gauge
+	dropped prometheus.Counter
+	onDrop  func(item T)
+}
+
+func newPipelineQueue[T any](
+	feedID string, stage string, depth int, policy OverflowPolicy, metrics *platform.Metrics, onDrop func(item T),
+) *pipelineQueue[T] {
+	return &pipelineQueue[T]{
+		items:   make(chan T, depth),
+		policy:  policy,
+		depth:   metrics.PipelineQueueDepth.WithLabelValues(feedID, stage),
+		dropped: metrics.PipelineDroppedTotal.WithLabelValues(feedID, stage),
+		onDrop:  onDrop,
+	}
+}
+
+func (queue *pipelineQueue[T]) push(ctx context.Context, item T) error {
+	defer func() { queue.depth.Set(float64(len(queue.items))) }()
+
+	switch queue.policy {
+	case OverflowBlock:
+		select {
+		case queue.items <- item:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+
+	case OverflowDrop:
+		select {
+		case queue.items <- item:
+		default:
+			queue.dropped.Inc()
+			queue.onDrop(item)
+		}
+		return nil
+
+	default:
+		for {
+			select {
+			case queue.items <- item:
+				return nil
+			default:
+			}
+
+			select {
+			case oldest := <-queue.items:
+				queue.dropped.Inc()
+				queue.onDrop(oldest)
+			default:
+			}
+		}
+	}
+}
+
+func (queue *pipelineQueue[T]) pop(ctx context.Context) (T, error) {
+	select {
+	case item := <-queue.items:
+		queue.depth.Set(float64(len(queue.items)))
+		return item, nil
+	case <-ctx.Done():
+		var zero T
+		return zero, ctx.Err()
+	}
+}
+
+// Frames are numbered as they're submitted so the write stage can take them in that order
+type submittedFrame struct {
+	seq   uint64
+	frame platform.FeedFrame
+}
+
+type decodedFrame struct {
+	seq     uint64
+	frame   platform.FeedFrame
+	message *gtfs.FeedMessage
+}
+
+// frameSequencer puts batches back in submission order ahead of the write stage, however the
+// decode and normalize workers happened to finish them. Dedup in header mode and the trip change
+// tracker both rely on snapshots being written in the order they were fetched.
+type frameSequencer struct {
+	lock sync.Mutex
+	next uint64
+	// Frames finished out of turn, nil for those that left the pipeline without a batch
+	waiting map[uint64]*SnapshotBatch
+}
+
+// release resolves frame seq and pushes every batch that is now next in line, in order. Pushing
+// under the lock keeps two workers from handing over their batches the wrong way round.
+func (sequencer *frameSequencer) release(
+	seq uint64, batch *SnapshotBatch, push func(batch *SnapshotBatch) error, abandon func(),
+) error {
+	sequencer.lock.Lock()
+	defer sequencer.lock.Unlock()
+
+	sequencer.waiting[seq] = batch
+	var err error
+	for {
+		next, ok := sequencer.waiting[sequencer.next]
+		if !ok {
+			return err
+		}
+		delete(sequencer.waiting, sequencer.next)
+		sequencer.next++
+
+		if next == nil {
+			continue
+		}
+		if err != nil {
+			abandon()
+			continue
+		}
+		if err = push(next); err != nil {
+			abandon()
+		}
+	}
+}
+
+// FeedPipeline runs one feed's ingest as decode -> normalize -> write stages joined by bounded
+// queues, so a slow COPY never holds up the poller that feeds it.
+type FeedPipeline struct {
+	ingester *FeedIngester
+	config   platform.PipelineConfig
+	guard    *DatabaseGuard
+
+	// Frames accepted by Submit that haven't been written, dropped or failed yet
+	pending sync.WaitGroup
+
+	submitLock sync.Mutex
+	nextSeq    uint64
+	sequencer  frameSequencer
+
+	decodeQueue    *pipelineQueue[submittedFrame]
+	normalizeQueue *pipelineQueue[decodedFrame]
+	writeQueue     *pipelineQueue[*SnapshotBatch]
+}
+
+func NewFeedPipeline(
+	ingester *FeedIngester, config platform.PipelineConfig, metrics *platform.Metrics,
+) (*FeedPipeline, error) {
+	policy, err := ParseOverflowPolicy(config.Overflow)
+	if err != nil {
+		return nil, err
+	}
+	if config.QueueDepth <= 0 {
+		config.QueueDepth = defaultQueueDepth
+	}
+	if config.DecodeWorkers <= 0 {
+		config.DecodeWorkers = defaultWorkers
+	}
+	if config.NormalizeWorkers <= 0 {
+		config.NormalizeWorkers = defaultWorkers
+	}
+
+	feedID := ingester.FeedID()
+	pipeline := &FeedPipeline{
+		ingester:  ingester,
+		config:    config,
+		sequencer: frameSequencer{waiting: make(map[uint64]*SnapshotBatch)},
+	}
+
+	pipeline.decodeQueue = newPipelineQueue(feedID, "decode", config.QueueDepth, policy, metrics, func(item submittedFrame) {
+		pipeline.dropped(item.seq, item.frame)
+	})
+	pipeline.normalizeQueue = newPipelineQueue(feedID, "normalize", config.QueueDepth, policy, metrics, func(item decodedFrame) {
+		pipeline.dropped(item.seq, item.frame)
+	})
+	// Past the sequencer a dropped batch has no turn left to give up
+	pipeline.writeQueue = newPipelineQueue(feedID, "write", config.QueueDepth, policy, metrics, func(batch *SnapshotBatch) {
+		pipeline.ingester.ForgetHash(batch.Frame)
+		pipeline.pending.Done()
+	})
+	return pipeline, nil
+}
+
+// dropped lets a frame the queues had no room for go. Its payload was never written, so an
+// identical poll later on mustn't be mistaken for a repeat. Only the drop and coalesce policies
+// drop frames, and their pushes never block, so no context is needed to hand on what follows.
+func (pipeline *FeedPipeline) dropped(seq uint64, frame platform.FeedFrame) {
+	pipeline.ingester.ForgetHash(frame)
+	pipeline.pending.Done()
+	if err := pipeline.release(context.Background(), seq, nil); err != nil {
+		log.Printf("queue write %s: %v", pipeline.FeedID(), err)
+	}
+}
+
+// release passes frame seq's batch, or nil when it left the pipeline early, to the sequencer,
+// which queues it for writing once every frame submitted before it has been dealt with
+func (pipeline *FeedPipeline) release(ctx context.Context, seq uint64, batch *SnapshotBatch) error {
+	return pipeline.sequencer.release(
+		seq, batch,
+		func(batch *SnapshotBatch) error { return pipeline.writeQueue.push(ctx, batch) },
+		pipeline.pending.Done,
+	)
+}
+
+// SetDatabaseGuard makes the write stage spool frames instead of failing while the database is down
+func (pipeline *FeedPipeline) SetDatabaseGuard(guard *DatabaseGuard) {
+	pipeline.guard = guard
+}
+
+func (pipeline *FeedPipeline) FeedID() string {
+	return pipeline.ingester.FeedID()
+}
+
+// Submit hands a fetched frame to the pipeline. It only blocks under the "block" overflow policy.
+func (pipeline *FeedPipeline) Submit(ctx context.Context, frame platform.FeedFrame) error {
+	if !frame.HasPayload() || pipeline.ingester.IsUnchanged(frame) {
+		return nil
+	}
+
+	pipeline.submitLock.Lock()
+	seq := pipeline.nextSeq
+	pipeline.nextSeq++
+	pipeline.pending.Add(1)
+	err := pipeline.decodeQueue.push(ctx, submittedFrame{seq: seq, frame: frame})
+	pipeline.submitLock.Unlock()
+
+	if err != nil {
+		pipeline.ingester.ForgetHash(frame)
+		pipeline.pending.Done()
+		pipeline.release(ctx, seq, nil)
+		return err
+	}
+	return nil
+}
+
+// Wait blocks until every submitted frame has left the pipeline. Callers must stop submitting first.
+func (pipeline *FeedPipeline) Wait(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		pipeline.pending.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (pipeline *FeedPipeline) decodeLoop(ctx context.Context) error {
+	for {
+		item, err := pipeline.decodeQueue.pop(ctx)
+		if err != nil {
+			return err
+		}
+
+		message, err := pipeline.ingester.Decode(item.frame)
+		if err != nil {
+			log.Printf("decode %s: %v", pipeline.FeedID(), err)
+			pipeline.ingester.DeadLetter(ctx, item.frame, err)
+			pipeline.pending.Done()
+			if err := pipeline.release(ctx, item.seq, nil); err != nil {
+				return err
+			}
+			continue
+		}
+
+		decoded := decodedFrame{seq: item.seq, frame: item.frame, message: message}
+		if err := pipeline.normalizeQueue.push(ctx, decoded); err != nil {
+			pipeline.pending.Done()
+			pipeline.release(ctx, item.seq, nil)
+			return err
+		}
+	}
+}
+
+func (pipeline *FeedPipeline) normalizeLoop(ctx context.Context) error {
+	for {
+		decoded, err := pipeline.normalizeQueue.pop(ctx)
+		if err != nil {
+			return err
+		}
+
+		batch, err := pipeline.ingester.Normalize(decoded.frame, decoded.message)
+		if err != nil {
+			log.Printf("normalize %s: %v", pipeline.FeedID(), err)
+			pipeline.ingester.DeadLetter(ctx, decoded.frame, err)
+			pipeline.pending.Done()
+			batch = nil
+		}
+
+		if err := pipeline.release(ctx, decoded.seq, batch); err != nil {
+			return err
+		}
+	}
+}
+
+// Writes stay on a single goroutine, taking batches in the order the sequencer queued them, which
+// is the order their frames were submitted
+func (pipeline *FeedPipeline) writeLoop(ctx context.Context) error {
+	for {
+		batch, err := pipeline.writeQueue.pop(ctx)
+		if err != nil {
+			return err
+		}
+
+		pipeline.write(ctx, batch)
+		pipeline.pending.Done()
+	}
+}
+
+func (pipeline *FeedPipeline) write(ctx context.Context, batch *SnapshotBatch) {
+	if spooled, err := pipeline.guard.SpoolIfDown(ctx, batch.Frame); spooled {
+		if err != nil {
+			log.Printf("spool %s: %v", pipeline.FeedID(), err)
+		}
+		return
+	}
+
+	err := pipeline.ingester.Write(ctx, batch)
+	if err == nil {
+		return
+	}
+	if ClassifyError(err) == ErrorDatabaseDown && pipeline.guard != nil {
+		pipeline.guard.MarkDown(err)
+		if _, err := pipeline.guard.SpoolIfDown(ctx, batch.Frame); err != nil {
+			log.Printf("spool %s: %v", pipeline.FeedID(), err)
+		}
+		return
+	}
+	log.Printf("write %s: %v", pipeline.FeedID(), err)
+	pipeline.ingester.DeadLetter(ctx, batch.Frame, err)
+}
+
+func (pipeline *FeedPipeline) Run(ctx context.Context) error {
+	group, subctx := errgroup.WithContext(ctx)
+
+	for range pipeline.config.DecodeWorkers {
+		group.Go(func() error { return pipeline.decodeLoop(subctx) })
+	}
+	for range pipeline.config.NormalizeWorkers {
+		group.Go(func() error { return pipeline.normalizeLoop(subctx) })
+	}
+	group.Go(func() error { return pipeline.writeLoop(subctx) })
+
+	return group.Wait()
+}
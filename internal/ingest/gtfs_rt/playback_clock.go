@@ -0,0 +1,181 @@
+package gtfs_rt
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Clock is the source of wall time for playback, replaceable so playback can be driven without
+// sleeping
+type Clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+func (systemClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+var SystemClock Clock = systemClock{}
+
+// PlaybackClock maps the times frames were captured at onto wall time. It is anchored at the first
+// frame it is asked to wait for, from there recorded time advances speed times as fast as wall
+// time. A zero speed doesn't wait at all, but still honours pauses.
+type PlaybackClock struct {
+	clock Clock
+	speed float64
+
+	lock sync.Mutex
+	// Recorded time at anchoredAt, the wall time playback was (re)started or resumed
+	position   time.Time
+	anchoredAt time.Time
+	paused     bool
+	// Closed and replaced whenever the clock is paused, resumed or re-anchored, to wake waiters
+	changed chan struct{}
+}
+
+func NewPlaybackClock(clock Clock, speed float64) *PlaybackClock {
+	if clock == nil {
+		clock = SystemClock
+	}
+	if speed < 0 {
+		speed = 0
+	}
+	return &PlaybackClock{
+		clock:   clock,
+		speed:   speed,
+		changed: make(chan struct{}),
+	}
+}
+
+func (playbackClock *PlaybackClock) Speed() float64 {
+	return playbackClock.speed
+}
+
+func (playbackClock *PlaybackClock) notifyLocked() {
+	close(playbackClock.changed)
+	playbackClock.changed = make(chan struct{})
+}
+
+// Start makes the recorded time at correspond to now, again each time playback loops
+func (playbackClock *PlaybackClock) Start(at time.Time) {
+	playbackClock.lock.Lock()
+	defer playbackClock.lock.Unlock()
+
+	playbackClock.position = at
+	playbackClock.anchoredAt = playbackClock.clock.Now()
+	playbackClock.notifyLocked()
+}
+
+// Reset forgets where the clock was anchored, the next WaitUntil starts it again
+func (playbackClock *PlaybackClock) Reset() {
+	playbackClock.Start(time.Time{})
+}
+
+// WallNow is the current time of the underlying clock
+func (playbackClock *PlaybackClock) WallNow() time.Time {
+	return playbackClock.clock.Now()
+}
+
+func (playbackClock *PlaybackClock) Started() bool {
+	playbackClock.lock.Lock()
+	defer playbackClock.lock.Unlock()
+	return !playbackClock.position.IsZero()
+}
+
+func (playbackClock *PlaybackClock) recordedNowLocked() time.Time {
+	if playbackClock.paused || playbackClock.position.IsZero() {
+		return playbackClock.position
+	}
+	elapsed := playbackClock.clock.Now().Sub(playbackClock.anchoredAt)
+	return playbackClock.position.Add(time.Duration(float64(elapsed) * playbackClock.speed))
+}
+
+// RecordedNow is the point in the recording playback has reached
+func (playbackClock *PlaybackClock) RecordedNow() time.Time {
+	playbackClock.lock.Lock()
+	defer playbackClock.lock.Unlock()
+	return playbackClock.recordedNowLocked()
+}
+
+func (playbackClock *PlaybackClock) Pause() {
+	playbackClock.lock.Lock()
+	defer playbackClock.lock.Unlock()
+
+	if playbackClock.paused {
+		return
+	}
+	playbackClock.position = playbackClock.recordedNowLocked()
+	playbackClock.paused = true
+	playbackClock.notifyLocked()
+}
+
+func (playbackClock *PlaybackClock) Resume() {
+	playbackClock.lock.Lock()
+	defer playbackClock.lock.Unlock()
+
+	if !playbackClock.paused {
+		return
+	}
+	playbackClock.anchoredAt = playbackClock.clock.Now()
+	playbackClock.paused = false
+	playbackClock.notifyLocked()
+}
+
+// TogglePause pauses a running clock or resumes a paused one, returning whether it is now paused
+func (playbackClock *PlaybackClock) TogglePause() bool {
+	if playbackClock.Paused() {
+		playbackClock.Resume()
+		return false
+	}
+	playbackClock.Pause()
+	return true
+}
+
+func (playbackClock *PlaybackClock) Paused() bool {
+	playbackClock.lock.Lock()
+	defer playbackClock.lock.Unlock()
+	return playbackClock.paused
+}
+
+// WaitUntil blocks until playback reaches the recorded time at, anchoring the clock there if it
+// hasn't been started
+func (playbackClock *PlaybackClock) WaitUntil(ctx context.Context, at time.Time) error {
+	if !playbackClock.Started() {
+		playbackClock.Start(at)
+	}
+
+	for {
+		playbackClock.lock.Lock()
+		changed := playbackClock.changed
+		paused := playbackClock.paused
+		var wait time.Duration
+		if !paused && playbackClock.speed > 0 {
+			wait = time.Duration(float64(at.Sub(playbackClock.recordedNowLocked())) / playbackClock.speed)
+		}
+		playbackClock.lock.Unlock()
+
+		if !paused && wait <= 0 {
+			return ctx.Err()
+		}
+
+		var timer <-chan time.Time
+		if !paused {
+			timer = playbackClock.clock.After(wait)
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-changed:
+		case <-timer:
+		}
+	}
+}
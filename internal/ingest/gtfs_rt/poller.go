@@ -7,6 +7,7 @@ import (
 	"io"
 	"log"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
 
@@ -14,22 +15,46 @@ import (
 	"tarediiran-industries.com/gtfs-services/internal/platform"
 )
 
+// PollResult is the outcome of one request. Failed requests are reported too, with Err set and no
+// payload, so recordings keep outages and error responses.
 type PollResult struct {
-	FeedID     string
-	URL        string
-	FetchedAt  time.Time
-	StatusCode int
-	Payload    []byte
+	FeedID        string
+	URL           string
+	FetchedAt     time.Time
+	Duration      time.Duration
+	StatusCode    int
+	ContentLength int64
+	Headers       platform.FrameHeaders
+	Payload       []byte
+	Err           error
 }
 
 func (result *PollResult) ToFeedFrame() platform.FeedFrame {
-	return platform.FeedFrame{
-		FeedID:     result.FeedID,
-		CapturedAt: time.Now(),
-		Status:     200,
-		Body:       result.Payload,
-		SHA256:     sha256.Sum256(result.Payload),
-		Source:     "http",
+	frame := platform.FeedFrame{
+		FeedID:        result.FeedID,
+		CapturedAt:    result.FetchedAt,
+		Status:        result.StatusCode,
+		Duration:      result.Duration,
+		ContentLength: result.ContentLength,
+		Headers:       result.Headers,
+		Body:          result.Payload,
+		Source:        "http",
+	}
+	if len(result.Payload) > 0 {
+		frame.SHA256 = sha256.Sum256(result.Payload)
+	}
+	// A status means the server answered, the reason it was rejected is already in the frame
+	if result.Err != nil && result.StatusCode == 0 {
+		frame.FetchError = result.Err.Error()
+	}
+	return frame
+}
+
+func responseHeaders(header http.Header) platform.FrameHeaders {
+	return platform.FrameHeaders{
+		ETag:         header.Get("ETag"),
+		LastModified: header.Get("Last-Modified"),
+		ContentType:  header.Get("Content-Type"),
 	}
 }
 
@@ -37,30 +62,48 @@ type Poller struct {
 	Config         platform.RealTimeConfig
 	LastHash       []byte
 	URL            string
-	Ticker         *time.Ticker
+	Auth           *platform.RequestAuth
+	Schedule       *PollSchedule
 	PayloadHandler PollCallback
+	Metrics        *platform.Metrics
+
+	// Validators from the last successful response, replayed as a conditional GET
+	ETag         string
+	LastModified string
 }
 
 type PollCallback func(ctx context.Context, result PollResult) error
 
+// A server that stops responding fails the poll and backs off instead of stalling the feed
+const pollRequestTimeout = 30 * time.Second
+
 type PollerSet struct {
 	Config  platform.FeedConfig
 	Client  *http.Client
 	Pollers []Poller
 }
 
-func NewPollerSet(ctx context.Context, config platform.SingleConfig) (*PollerSet, error) {
+func NewPollerSet(
+	ctx context.Context, config platform.SingleConfig, metrics *platform.Metrics,
+) (*PollerSet, error) {
 	pollerSet := &PollerSet{
 		Config:  config.Feed,
-		Client:  &http.Client{},
+		Client:  &http.Client{Timeout: pollRequestTimeout},
 		Pollers: make([]Poller, 0),
 	}
 
 	for _, realTimeConfig := range config.Feed.RealTime {
+		auth, err := config.ResolveAuth(realTimeConfig.Auth)
+		if err != nil {
+			return nil, fmt.Errorf("feed %s: %w", realTimeConfig.ID, err)
+		}
+
 		poller := Poller{
-			Config: realTimeConfig,
-			URL:    realTimeConfig.URL,
-			Ticker: time.NewTicker(time.Duration(realTimeConfig.PollSeconds) * time.Second),
+			Config:   realTimeConfig,
+			URL:      realTimeConfig.URL,
+			Auth:     auth,
+			Schedule: NewPollSchedule(realTimeConfig),
+			Metrics:  metrics,
 		}
 		pollerSet.Pollers = append(pollerSet.Pollers, poller)
 	}
@@ -100,61 +143,146 @@ func (pollerSet *PollerSet) String() string {
 	return builder.String()
 }
 
+func (poller *Poller) conditionalGet() bool {
+	return poller.Config.Schedule.ConditionalGet == nil || *poller.Config.Schedule.ConditionalGet
+}
+
 func (poller *Poller) SampleEndpoint(ctx context.Context, client *http.Client) error {
 	req, err := http.NewRequestWithContext(ctx, "GET", poller.URL, nil)
 	if err != nil {
 		return err
 	}
+	poller.Auth.Apply(req)
+
+	if poller.conditionalGet() {
+		if poller.ETag != "" {
+			req.Header.Set("If-None-Match", poller.ETag)
+		}
+		if poller.LastModified != "" {
+			req.Header.Set("If-Modified-Since", poller.LastModified)
+		}
+	}
+
+	feedID := poller.Config.ID
+	metrics := poller.Metrics
+
+	requestStart := time.Now()
+	result := PollResult{
+		FeedID:    feedID,
+		URL:       poller.URL,
+		FetchedAt: requestStart,
+	}
 
 	resp, err := client.Do(req)
 	if err != nil {
-		return err
+		metrics.HttpErrorsTotal.WithLabelValues(feedID, "transport").Inc()
+		poller.Schedule.ObserveFailure()
+		return poller.reportFailure(ctx, result, classify(ErrorTransient, err))
 	}
 	defer resp.Body.Close()
 
+	metrics.HttpTTFBSeconds.WithLabelValues(feedID).Observe(time.Since(requestStart).Seconds())
+	metrics.HttpRequestsTotal.WithLabelValues(feedID, strconv.Itoa(resp.StatusCode)).Inc()
+
+	result.StatusCode = resp.StatusCode
+	result.ContentLength = resp.ContentLength
+	result.Headers = responseHeaders(resp.Header)
+
+	if resp.StatusCode == http.StatusNotModified {
+		poller.Schedule.ObserveResponse(time.Now(), resp.Header)
+		result.Duration = time.Since(requestStart)
+		return poller.handle(ctx, result)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		metrics.HttpErrorsTotal.WithLabelValues(feedID, "status").Inc()
+		poller.Schedule.ObserveFailure()
+		return poller.reportFailure(ctx, result, classify(ErrorTransient, fmt.Errorf("HTTP status %d", resp.StatusCode)))
+	}
+
+	readStart := time.Now()
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return err
+		metrics.HttpErrorsTotal.WithLabelValues(feedID, "read_body").Inc()
+		poller.Schedule.ObserveFailure()
+		// The status alone would look like a good response with an empty body
+		result.StatusCode = 0
+		return poller.reportFailure(ctx, result, classify(ErrorTransient, fmt.Errorf("read body: %w", err)))
 	}
 
-	result := PollResult{
-		FeedID:     poller.Config.ID,
-		URL:        poller.URL,
-		FetchedAt:  time.Now(),
-		StatusCode: resp.StatusCode,
-		Payload:    body,
+	metrics.HttpReadBodySeconds.WithLabelValues(feedID).Observe(time.Since(readStart).Seconds())
+	metrics.HttpBytesTotal.WithLabelValues(feedID).Add(float64(len(body)))
+	metrics.FeedPayloadBytes.WithLabelValues(feedID).Observe(float64(len(body)))
+
+	now := time.Now()
+	poller.Schedule.ObserveResponse(now, resp.Header)
+	poller.ETag = result.Headers.ETag
+	poller.LastModified = result.Headers.LastModified
+
+	if poller.Schedule.Adaptive() {
+		if timestamp, err := FeedHeaderTimestamp(body); err == nil {
+			poller.Schedule.ObserveHeaderTimestamp(now, timestamp)
+		}
+	}
+
+	result.Duration = now.Sub(requestStart)
+	result.Payload = body
+	if result.ContentLength < 0 {
+		result.ContentLength = int64(len(body))
 	}
 
+	return poller.handle(ctx, result)
+}
+
+func (poller *Poller) handle(ctx context.Context, result PollResult) error {
+	if poller.PayloadHandler == nil {
+		return nil
+	}
 	return poller.PayloadHandler(ctx, result)
 }
 
-func (pollerSet *PollerSet) PollEndpoint(ctx context.Context, poller Poller) error {
+// reportFailure passes a failed request on to the handler and returns the fetch error, unless the
+// handler itself failed fatally. Requests cut short by shutdown aren't reported.
+func (poller *Poller) reportFailure(ctx context.Context, result PollResult, err error) error {
+	if ctx.Err() != nil {
+		return err
+	}
+
+	result.Duration = time.Since(result.FetchedAt)
+	result.Err = err
+	if handlerErr := poller.handle(ctx, result); handlerErr != nil && ClassifyError(handlerErr) == ErrorFatal {
+		return handlerErr
+	}
+	return err
+}
+
+func (pollerSet *PollerSet) PollEndpoint(ctx context.Context, poller *Poller) error {
+	timer := time.NewTimer(poller.Schedule.First())
+	defer timer.Stop()
+
 	for {
 		select {
 		case <-ctx.Done():
 			return ctx.Err()
-		case <-poller.Ticker.C:
+		case <-timer.C:
 			err := poller.SampleEndpoint(ctx, pollerSet.Client)
+			if err != nil && ClassifyError(err) == ErrorFatal {
+				return fmt.Errorf("poll %s: %w", poller.Config.ID, err)
+			}
 			if err != nil {
-				log.Printf("poll %s: %v", poller.Config.ID, err)
+				log.Printf("poll %s: %v (%s)", poller.Config.ID, err, poller.Schedule)
 			}
+			timer.Reset(poller.Schedule.Next(time.Now()))
 		}
 	}
 }
 
 func (pollerSet *PollerSet) Poll(ctx context.Context) error {
 	group, subctx := errgroup.WithContext(ctx)
-	for _, poller := range pollerSet.Pollers {
-		thisPoller := poller // need to capture the loop var, otherwise they all are the same
+	for i := range pollerSet.Pollers {
+		poller := &pollerSet.Pollers[i]
 		group.Go(func() error {
-			return pollerSet.PollEndpoint(subctx, thisPoller)
+			return pollerSet.PollEndpoint(subctx, poller)
 		})
 	}
 	return group.Wait()
 }
-
-func (pollerSet *PollerSet) Stop() {
-	for _, poller := range pollerSet.Pollers {
-		poller.Ticker.Stop()
-	}
-}
@@ -0,0 +1,98 @@
+package gtfs_rt
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"time"
+
+	"github.com/MobilityData/gtfs-realtime-bindings/golang/gtfs"
+	"google.golang.org/protobuf/proto"
+	"tarediiran-industries.com/gtfs-services/internal/platform"
+)
+
+func shiftUnix(value *uint64, shift int64) {
+	if value != nil && *value != 0 {
+		*value = uint64(int64(*value) + shift)
+	}
+}
+
+// Service days move by however many calendar days the shift crosses
+func shiftStartDate(value *string, shift time.Duration) {
+	if value == nil || *value == "" {
+		return
+	}
+	// Noon keeps the date stable whatever the shift's sub-day remainder
+	date, err := time.Parse("20060102", *value)
+	if err != nil {
+		return
+	}
+	*value = date.Add(12 * time.Hour).Add(shift).Format("20060102")
+}
+
+// RebaseFeedMessage moves every epoch in a GTFS-RT payload by shift: the header, trip update and
+// vehicle timestamps, predicted stop times, alert active periods and trip start dates. Extension
+// fields it doesn't know are carried over unchanged.
+func RebaseFeedMessage(payload []byte, shift time.Duration) ([]byte, error) {
+	message := &gtfs.FeedMessage{}
+	if err := proto.Unmarshal(payload, message); err != nil {
+		return nil, fmt.Errorf("decode feed message: %w", err)
+	}
+
+	seconds := int64(shift / time.Second)
+	shiftUnix(message.GetHeader().Timestamp, seconds)
+
+	for _, entity := range message.GetEntity() {
+		if tripUpdate := entity.GetTripUpdate(); tripUpdate != nil {
+			shiftUnix(tripUpdate.Timestamp, seconds)
+			if trip := tripUpdate.GetTrip(); trip != nil {
+				shiftStartDate(trip.StartDate, shift)
+			}
+			for _, stopTime := range tripUpdate.GetStopTimeUpdate() {
+				for _, event := range []*gtfs.TripUpdate_StopTimeEvent{stopTime.GetArrival(), stopTime.GetDeparture()} {
+					if event != nil && event.Time != nil && *event.Time != 0 {
+						*event.Time += seconds
+					}
+				}
+			}
+		}
+
+		if vehicle := entity.GetVehicle(); vehicle != nil {
+			shiftUnix(vehicle.Timestamp, seconds)
+			if trip := vehicle.GetTrip(); trip != nil {
+				shiftStartDate(trip.StartDate, shift)
+			}
+		}
+
+		if alert := entity.GetAlert(); alert != nil {
+			for _, period := range alert.GetActivePeriod() {
+				shiftUnix(period.Start, seconds)
+				shiftUnix(period.End, seconds)
+			}
+			for _, informed := range alert.GetInformedEntity() {
+				if trip := informed.GetTrip(); trip != nil {
+					shiftStartDate(trip.StartDate, shift)
+				}
+			}
+		}
+	}
+
+	return proto.Marshal(message)
+}
+
+// rebaseFrame makes a recorded frame look as if it had just been fetched at now
+func rebaseFrame(frame platform.FeedFrame, now time.Time) (platform.FeedFrame, error) {
+	shift := now.Sub(frame.CapturedAt).Truncate(time.Second)
+	frame.CapturedAt = frame.CapturedAt.Add(shift)
+	if !frame.HasPayload() {
+		return frame, nil
+	}
+
+	body, err := RebaseFeedMessage(frame.Body, shift)
+	if err != nil {
+		return frame, err
+	}
+	frame.Body = body
+	frame.SHA256 = sha256.Sum256(body)
+	frame.ContentLength = int64(len(body))
+	return frame, nil
+}
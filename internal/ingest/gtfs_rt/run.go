@@ -2,6 +2,7 @@ package gtfs_rt
 
 import (
 	"context"
+	"errors"
 	"os"
 	"os/signal"
 	"syscall"
@@ -18,7 +19,9 @@ func Run(cfg platform.SingleConfig) error {
 		return err
 	}
 	defer watcher.Close()
-	watcher.Watch(ctx)
 
+	if err := watcher.Watch(ctx); err != nil && !errors.Is(err, context.Canceled) {
+		return err
+	}
 	return nil
 }
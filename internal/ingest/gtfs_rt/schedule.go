@@ -0,0 +1,193 @@
+package gtfs_rt
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/MobilityData/gtfs-realtime-bindings/golang/gtfs"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+	"tarediiran-industries.com/gtfs-services/internal/platform"
+)
+
+const (
+	defaultJitterFraction = 0.1
+	defaultBackoffMax     = 60 * time.Second
+
+	// Weight of the newest observation in the moving average of header timestamp change periods
+	changePeriodAlpha = 0.3
+)
+
+type PollSchedule struct {
+	base       time.Duration
+	minimum    time.Duration
+	maximum    time.Duration
+	backoffMax time.Duration
+	jitter     float64
+	adaptive   bool
+
+	interval  time.Duration
+	failures  int
+	freshTill time.Time
+
+	lastHeaderTimestamp uint64
+	lastChangeAt        time.Time
+	changePeriod        time.Duration
+}
+
+func secondsToDuration(seconds float64) time.Duration {
+	return time.Duration(seconds * float64(time.Second))
+}
+
+func NewPollSchedule(config platform.RealTimeConfig) *PollSchedule {
+	scheduleConfig := config.Schedule
+	base := secondsToDuration(config.PollSeconds)
+
+	schedule := &PollSchedule{
+		base:       base,
+		minimum:    base,
+		maximum:    base,
+		backoffMax: defaultBackoffMax,
+		jitter:     defaultJitterFraction,
+		adaptive:   scheduleConfig.Adaptive,
+		interval:   base,
+	}
+	// Adaptive polling needs room to move even when no bounds are configured
+	if schedule.adaptive {
+		schedule.minimum = base / 2
+		schedule.maximum = base * 4
+	}
+
+	if scheduleConfig.JitterFraction > 0 {
+		schedule.jitter = min(scheduleConfig.JitterFraction, 1)
+	}
+	if scheduleConfig.BackoffMaxSeconds > 0 {
+		schedule.backoffMax = secondsToDuration(scheduleConfig.BackoffMaxSeconds)
+	}
+	if scheduleConfig.MinPollSeconds > 0 {
+		schedule.minimum = secondsToDuration(scheduleConfig.MinPollSeconds)
+	}
+	if scheduleConfig.MaxPollSeconds > 0 {
+		schedule.maximum = secondsToDuration(scheduleConfig.MaxPollSeconds)
+	}
+	if schedule.maximum < schedule.minimum {
+		schedule.maximum = schedule.minimum
+	}
+
+	return schedule
+}
+
+func (schedule *PollSchedule) String() string {
+	return fmt.Sprintf(
+		"interval=%s failures=%d change_period=%s",
+		schedule.interval, schedule.failures, schedule.changePeriod,
+	)
+}
+
+// Initial delay is spread over one base interval so feeds sharing a host don't all fire at once
+func (schedule *PollSchedule) First() time.Duration {
+	return time.Duration(rand.Float64() * float64(schedule.base))
+}
+
+func (schedule *PollSchedule) Next(now time.Time) time.Duration {
+	delay := schedule.interval
+	if schedule.failures > 0 {
+		delay = schedule.base << min(schedule.failures, 16)
+		if delay <= 0 || delay > schedule.backoffMax {
+			delay = schedule.backoffMax
+		}
+	} else if fresh := schedule.freshTill.Sub(now); fresh > delay {
+		// Cache-Control says nothing new will show up before then, within reason
+		delay = min(fresh, max(schedule.maximum, schedule.base))
+	}
+
+	if schedule.jitter > 0 {
+		spread := schedule.jitter * (2*rand.Float64() - 1)
+		delay += time.Duration(spread * float64(delay))
+	}
+	return max(delay, 0)
+}
+
+func (schedule *PollSchedule) ObserveFailure() {
+	schedule.failures++
+}
+
+func (schedule *PollSchedule) ObserveResponse(now time.Time, header http.Header) {
+	schedule.failures = 0
+	schedule.freshTill = now.Add(cacheMaxAge(header))
+}
+
+func (schedule *PollSchedule) ObserveHeaderTimestamp(now time.Time, timestamp uint64) {
+	if !schedule.adaptive || timestamp == 0 || timestamp == schedule.lastHeaderTimestamp {
+		return
+	}
+
+	if !schedule.lastChangeAt.IsZero() {
+		period := now.Sub(schedule.lastChangeAt)
+		if schedule.changePeriod == 0 {
+			schedule.changePeriod = period
+		} else {
+			schedule.changePeriod = time.Duration(
+				changePeriodAlpha*float64(period) + (1-changePeriodAlpha)*float64(schedule.changePeriod),
+			)
+		}
+		schedule.interval = min(max(schedule.changePeriod/2, schedule.minimum), schedule.maximum)
+	}
+
+	schedule.lastHeaderTimestamp = timestamp
+	schedule.lastChangeAt = now
+}
+
+func (schedule *PollSchedule) Adaptive() bool {
+	return schedule.adaptive
+}
+
+func cacheMaxAge(header http.Header) time.Duration {
+	for _, directive := range strings.Split(header.Get("Cache-Control"), ",") {
+		directive = strings.TrimSpace(strings.ToLower(directive))
+		if directive == "no-cache" || directive == "no-store" {
+			return 0
+		}
+		if value, ok := strings.CutPrefix(directive, "max-age="); ok {
+			seconds, err := strconv.Atoi(value)
+			if err == nil && seconds > 0 {
+				return time.Duration(seconds) * time.Second
+			}
+		}
+	}
+	return 0
+}
+
+// FeedHeaderTimestamp pulls FeedHeader.timestamp out of a FeedMessage without decoding entities
+func FeedHeaderTimestamp(payload []byte) (uint64, error) {
+	for len(payload) > 0 {
+		number, wireType, n := protowire.ConsumeTag(payload)
+		if n < 0 {
+			return 0, protowire.ParseError(n)
+		}
+		payload = payload[n:]
+
+		if number == 1 && wireType == protowire.BytesType {
+			headerBytes, n := protowire.ConsumeBytes(payload)
+			if n < 0 {
+				return 0, protowire.ParseError(n)
+			}
+			header := &gtfs.FeedHeader{}
+			if err := (proto.UnmarshalOptions{AllowPartial: true}).Unmarshal(headerBytes, header); err != nil {
+				return 0, err
+			}
+			return header.GetTimestamp(), nil
+		}
+
+		n = protowire.ConsumeFieldValue(number, wireType, payload)
+		if n < 0 {
+			return 0, protowire.ParseError(n)
+		}
+		payload = payload[n:]
+	}
+	return 0, fmt.Errorf("FeedMessage has no header")
+}
@@ -0,0 +1,363 @@
+package gtfs_rt
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	database "tarediiran-industries.com/gtfs-services/internal/db"
+	"tarediiran-industries.com/gtfs-services/internal/platform"
+)
+
+type pendingSpool struct {
+	dir  string
+	skip int // frames already replayed before the database dropped out again
+}
+
+// DatabaseGuard keeps frames from being lost while the database is down. Writers spool frames to
+// disk in the recording format instead of failing, and once the database answers pings again the
+// spools are replayed oldest first before live writes resume.
+type DatabaseGuard struct {
+	db       *database.Database
+	spoolDir string
+	feeds    []platform.FeedSpec
+	retry    database.RetryOptions
+	replay   PlaybackCallback
+	// Frames whose spooled payload can no longer be read
+	deadLetters *DeadLetterStore
+	// Startup work waiting on the database to answer for the first time, only touched by Run
+	connect func(ctx context.Context) error
+
+	lock    sync.Mutex
+	down    bool
+	writer  *platform.FeedRecordingWriter
+	pending []pendingSpool
+	fatal   error
+	wake    chan struct{}
+}
+
+func DefaultSpoolDir() string {
+	cacheDir, err := os.UserCacheDir()
+	if err != nil {
+		cacheDir = os.TempDir()
+	}
+	return filepath.Join(cacheDir, "gtfs-rt-ingest", "spool")
+}
+
+func NewDatabaseGuard(
+	db *database.Database, spoolDir string, feeds []platform.FeedSpec, replay PlaybackCallback,
+) (*DatabaseGuard, error) {
+	if spoolDir == "" {
+		spoolDir = DefaultSpoolDir()
+	}
+	if err := os.MkdirAll(spoolDir, 0o755); err != nil {
+		return nil, fmt.Errorf("create spool dir: %w", err)
+	}
+
+	guard := &DatabaseGuard{
+		db:       db,
+		spoolDir: spoolDir,
+		feeds:    feeds,
+		retry:    database.DefaultRetryOptions(),
+		replay:   replay,
+		wake:     make(chan struct{}, 1),
+	}
+
+	// Spools left over from a previous run go first, ahead of anything written live
+	leftovers, err := listRecordings(spoolDir)
+	if err != nil {
+		return nil, err
+	}
+	for _, dir := range leftovers {
+		guard.pending = append(guard.pending, pendingSpool{dir: dir})
+	}
+	if len(guard.pending) > 0 {
+		log.Printf("found %d spooled recording(s) in %s to replay", len(guard.pending), spoolDir)
+		guard.down = true
+		guard.signal()
+	}
+
+	return guard, nil
+}
+
+// listRecordings returns recording directories under root, oldest name first
+func listRecordings(root string) ([]string, error) {
+	entries, err := os.ReadDir(root)
+	if err != nil {
+		return nil, err
+	}
+
+	dirs := make([]string, 0)
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		dir := filepath.Join(root, entry.Name())
+		if _, err := os.Stat(filepath.Join(dir, "recording.json")); err == nil {
+			dirs = append(dirs, dir)
+		}
+	}
+	sort.Strings(dirs)
+	return dirs, nil
+}
+
+// createRecordingIn starts a recording named after prefix and the current time, so that
+// listRecordings returns them in creation order
+func createRecordingIn(dir string, prefix string, feeds []platform.FeedSpec) (*platform.FeedRecordingWriter, error) {
+	return platform.CreateFeedRecording(feeds, platform.RecordingHeaderOptions{
+		RecordingName: prefix + "-" + time.Now().UTC().Format("20060102T150405.000000000Z"),
+		RecordingPath: dir,
+		Tool:          platform.NewToolInfo("gtfs-rt-ingest"),
+	})
+}
+
+func (guard *DatabaseGuard) signal() {
+	select {
+	case guard.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (guard *DatabaseGuard) IsDown() bool {
+	if guard == nil {
+		return false
+	}
+	guard.lock.Lock()
+	defer guard.lock.Unlock()
+	return guard.down
+}
+
+func (guard *DatabaseGuard) SetDeadLetterStore(store *DeadLetterStore) {
+	guard.deadLetters = store
+}
+
+// StartDown spools every frame until the database first answers, then runs connect before replaying
+// the spools, so a database that is down at startup holds nothing up. It must be called before Run.
+func (guard *DatabaseGuard) StartDown(connect func(ctx context.Context) error) {
+	guard.lock.Lock()
+	guard.down = true
+	guard.connect = connect
+	guard.lock.Unlock()
+	guard.signal()
+}
+
+func (guard *DatabaseGuard) MarkDown(err error) {
+	if guard == nil {
+		return
+	}
+	guard.lock.Lock()
+	if !guard.down {
+		log.Printf("database unavailable, spooling frames to %s: %v", guard.spoolDir, err)
+		guard.down = true
+	}
+	guard.lock.Unlock()
+	guard.signal()
+}
+
+// SpoolIfDown writes frame to the current spool when the database is down. It reports false when
+// the database is considered healthy and the caller should write directly.
+func (guard *DatabaseGuard) SpoolIfDown(ctx context.Context, frame platform.FeedFrame) (bool, error) {
+	if guard == nil {
+		return false, nil
+	}
+
+	guard.lock.Lock()
+	defer guard.lock.Unlock()
+
+	if !guard.down {
+		return false, nil
+	}
+
+	if guard.writer == nil {
+		writer, err := createRecordingIn(guard.spoolDir, "spool", guard.feeds)
+		if err != nil {
+			return true, guard.failLocked(fmt.Errorf("create spool: %w", err))
+		}
+		guard.writer = writer
+	}
+
+	if err := guard.writer.Append(ctx, frame); err != nil {
+		return true, guard.failLocked(fmt.Errorf("spool frame: %w", err))
+	}
+	return true, nil
+}
+
+// Losing the ability to spool means frames would be dropped silently, so it ends the process
+func (guard *DatabaseGuard) failLocked(err error) error {
+	err = classify(ErrorFatal, err)
+	if guard.fatal == nil {
+		guard.fatal = err
+	}
+	guard.signal()
+	return err
+}
+
+func (guard *DatabaseGuard) Run(ctx context.Context) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-guard.wake:
+		}
+
+		guard.lock.Lock()
+		fatal := guard.fatal
+		guard.lock.Unlock()
+		if fatal != nil {
+			return fatal
+		}
+
+		if err := guard.recover(ctx); err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+	}
+}
+
+func (guard *DatabaseGuard) recover(ctx context.Context) error {
+	for {
+		if err := database.Retry(ctx, "database reconnect", guard.retry, guard.reconnect); err != nil {
+			return err
+		}
+
+		guard.lock.Lock()
+		if guard.writer != nil {
+			// Rotate so replay reads a closed spool while live frames start a fresh one
+			if err := guard.writer.Close(); err != nil {
+				guard.lock.Unlock()
+				return classify(ErrorFatal, fmt.Errorf("close spool: %w", err))
+			}
+			guard.pending = append(guard.pending, pendingSpool{dir: guard.writer.RootDir()})
+			guard.writer = nil
+		}
+		if len(guard.pending) == 0 {
+			guard.down = false
+			guard.lock.Unlock()
+			log.Printf("database recovered, resuming direct writes")
+			return nil
+		}
+		next := guard.pending[0]
+		guard.lock.Unlock()
+
+		replayed, err := guard.replaySpool(ctx, next)
+		if err != nil && ctx.Err() == nil && ClassifyError(err) == ErrorBadPayload {
+			// Only a spool damaged before its last line gets here, before any of it was replayed, so
+			// it stays on disk for inspection
+			log.Printf("%v, leaving it in place", err)
+			guard.lock.Lock()
+			guard.pending = guard.pending[1:]
+			guard.lock.Unlock()
+			continue
+		}
+		if err != nil {
+			if ClassifyError(err) != ErrorDatabaseDown {
+				return err
+			}
+			log.Printf("database dropped during replay of %s: %v", next.dir, err)
+			guard.lock.Lock()
+			guard.pending[0].skip = replayed
+			guard.lock.Unlock()
+			continue
+		}
+
+		guard.lock.Lock()
+		guard.pending = guard.pending[1:]
+		guard.lock.Unlock()
+		if err := os.RemoveAll(next.dir); err != nil {
+			log.Printf("remove replayed spool %s: %v", next.dir, err)
+		}
+	}
+}
+
+// reconnect pings the database, and the first time it answers runs the startup work waiting on it
+func (guard *DatabaseGuard) reconnect(ctx context.Context) error {
+	if err := guard.db.Ping(ctx); err != nil {
+		return err
+	}
+	if guard.connect == nil {
+		return nil
+	}
+	if err := guard.connect(ctx); err != nil {
+		return err
+	}
+	guard.connect = nil
+	return nil
+}
+
+func (guard *DatabaseGuard) replaySpool(ctx context.Context, spool pendingSpool) (int, error) {
+	// A crash mid-append tears at most the last line, anything else is damage the reader reports
+	if _, err := platform.RepairFeedRecording(spool.dir); err != nil {
+		return spool.skip, classify(ErrorBadPayload, fmt.Errorf("repair spool %s: %w", spool.dir, err))
+	}
+
+	reader, err := platform.OpenFeedRecording(spool.dir)
+	if err != nil {
+		return spool.skip, classify(ErrorFatal, fmt.Errorf("open spool %s: %w", spool.dir, err))
+	}
+	defer reader.Close()
+
+	log.Printf("replaying spooled frames from %s", spool.dir)
+	replayed := 0
+	for {
+		// Past the repair every line of frames.jsonl parses, so a failure here is the disk's
+		meta, err := reader.PeekMeta()
+		if errors.Is(err, io.EOF) {
+			return replayed, nil
+		}
+		if err != nil {
+			return replayed, classify(ErrorFatal, fmt.Errorf("read spool %s: %w", spool.dir, err))
+		}
+
+		frame, err := reader.Next(ctx)
+		if err != nil && ctx.Err() != nil {
+			return replayed, ctx.Err()
+		}
+
+		replayed++
+		if replayed <= spool.skip {
+			continue
+		}
+
+		if err != nil {
+			// A damaged payload only costs its own frame, the rest of the spool replays as usual
+			frame = platform.FeedFrame{FeedID: meta.FeedID, CapturedAt: meta.CapturedAt, Source: "replay"}
+			log.Printf("read spooled %s: %v", frame, err)
+			if err := guard.deadLetters.Put(ctx, frame, err); err != nil {
+				log.Printf("dead-letter %s: %v", frame, err)
+			}
+			continue
+		}
+
+		if err := guard.replay(ctx, frame); err != nil {
+			if ClassifyError(err) == ErrorDatabaseDown {
+				return replayed - 1, err
+			}
+			log.Printf("replay %s: %v", frame, err)
+		}
+	}
+}
+
+// Close finishes the open spool, if any, so it can be replayed on the next start
+func (guard *DatabaseGuard) Close() error {
+	if guard == nil {
+		return nil
+	}
+	guard.lock.Lock()
+	defer guard.lock.Unlock()
+
+	if guard.writer == nil {
+		return nil
+	}
+	err := guard.writer.Close()
+	guard.writer = nil
+	return err
+}
@@ -0,0 +1,136 @@
+package gtfs_rt
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	database "tarediiran-industries.com/gtfs-services/internal/db"
+	"tarediiran-industries.com/gtfs-services/internal/platform"
+)
+
+// Nothing listens on port 1, so every connection attempt is refused straight away
+const unreachableDatabaseURL = "postgres://gtfs@127.0.0.1:1/gtfs?connect_timeout=1"
+
+func TestDatabaseGuardStartsDown(t *testing.T) {
+	db, err := database.OpenDatabase(context.Background(), unreachableDatabaseURL, database.PoolOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	feeds := []platform.FeedSpec{{FeedID: "alpha", URL: "http://alpha.test/rt", PollSeconds: 15}}
+	spoolDir := t.TempDir()
+	guard, err := NewDatabaseGuard(db, spoolDir, feeds, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	guard.retry = database.RetryOptions{InitialBackoff: time.Millisecond, MaxBackoff: 5 * time.Millisecond}
+	connected := false
+	guard.StartDown(func(ctx context.Context) error {
+		connected = true
+		return nil
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- guard.Run(ctx) }()
+
+	const frames = 5
+	for i := 1; i <= frames; i++ {
+		spooled, err := guard.SpoolIfDown(ctx, testFrame(t, "alpha", i))
+		if !spooled || err != nil {
+			t.Fatalf("spool frame %d: %v, %v", i, spooled, err)
+		}
+	}
+
+	cancel()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("guard kept retrying after its context was cancelled")
+	}
+	if connected {
+		t.Error("startup work ran without the database answering")
+	}
+	if err := guard.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := spooledTimestamps(t, spoolDir)["alpha"]; len(got) != frames {
+		t.Errorf("spooled %d frames, want %d", len(got), frames)
+	}
+}
+
+func TestDatabaseGuardReplaySpoolDamagedPayload(t *testing.T) {
+	feeds := []platform.FeedSpec{{FeedID: "alpha", URL: "http://alpha.test/rt", PollSeconds: 15}}
+	replayed := make([]int64, 0)
+	guard, err := NewDatabaseGuard(nil, t.TempDir(), feeds, func(ctx context.Context, frame platform.FeedFrame) error {
+		replayed = append(replayed, frame.CapturedAt.Unix())
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	deadLetters, err := NewDeadLetterStore(t.TempDir(), feeds)
+	if err != nil {
+		t.Fatal(err)
+	}
+	guard.SetDeadLetterStore(deadLetters)
+
+	ctx := context.Background()
+	guard.MarkDown(errors.New("no database in tests"))
+	for i := 1; i <= 5; i++ {
+		if spooled, err := guard.SpoolIfDown(ctx, testFrame(t, "alpha", i)); !spooled || err != nil {
+			t.Fatalf("spool frame %d: %v, %v", i, spooled, err)
+		}
+	}
+	if err := guard.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	spools, err := listRecordings(guard.spoolDir)
+	if err != nil || len(spools) != 1 {
+		t.Fatalf("spools = %v, %v", spools, err)
+	}
+	payload := filepath.Join(spools[0], "payloads", "000003.pb")
+	if err := os.WriteFile(payload, []byte("damaged"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	count, err := guard.replaySpool(ctx, pendingSpool{dir: spools[0]})
+	if err != nil || count != 5 {
+		t.Fatalf("replaySpool = %d, %v", count, err)
+	}
+	if want := []int64{1, 2, 4, 5}; fmt.Sprint(replayed) != fmt.Sprint(want) {
+		t.Errorf("replayed %v, want %v", replayed, want)
+	}
+
+	if err := deadLetters.Close(); err != nil {
+		t.Fatal(err)
+	}
+	letters, err := listRecordings(deadLetters.Dir())
+	if err != nil || len(letters) != 1 {
+		t.Fatalf("dead letters = %v, %v", letters, err)
+	}
+	reader, err := platform.OpenFeedRecording(letters[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+	frame, err := reader.Next(ctx)
+	if err != nil || frame.CapturedAt.Unix() != 3 || frame.Error == "" {
+		t.Errorf("dead letter = %v (%q), %v", frame, frame.Error, err)
+	}
+	if _, err := reader.Next(ctx); err != io.EOF {
+		t.Errorf("more than the damaged frame was dead-lettered: %v", err)
+	}
+}
@@ -2,15 +2,22 @@ package gtfs_rt
 
 import (
 	"context"
+	"fmt"
+	"log"
+	"time"
 
+	"golang.org/x/sync/errgroup"
 	"tarediiran-industries.com/gtfs-services/internal/platform"
 )
 
+const drainTimeout = 10 * time.Second
+
 type Watcher struct {
 	cfg platform.SingleConfig
 
 	pollerSet   *PollerSet
 	ingesterSet *FeedIngesterSet
+	guard       *DatabaseGuard
 
 	metrics   *platform.Metrics
 	telemetry *platform.TelemetryServer
@@ -18,25 +25,45 @@ type Watcher struct {
 
 func NewWatcher(ctx context.Context, cfg platform.SingleConfig) (*Watcher, error) {
 	telemetry := cfg.NewTelemetryServer()
-	telemetry.Start()
+	if err := telemetry.Start(); err != nil {
+		return nil, fmt.Errorf("start telemetry: %w", err)
+	}
 	metrics := platform.NewMetrics(telemetry.GetRegistry())
 
-	pollerSet, err := NewPollerSet(ctx, cfg)
+	pollerSet, err := NewPollerSet(ctx, cfg, metrics)
 	if err != nil {
+		telemetry.Stop()
 		return nil, err
 	}
 
-	ingesterSet, err := NewFeedIngesterSet(ctx, cfg)
+	// A database that is down at startup shouldn't hold up polling, the guard spools until it answers
+	ingesterSet, err := OpenFeedIngesterSet(ctx, cfg, metrics)
 	if err != nil {
+		telemetry.Stop()
 		return nil, err
 	}
 
-	for _, ingester := range ingesterSet.ingesters {
+	feeds, err := cfg.Feed.ToFeedSpecs()
+	if err != nil {
+		ingesterSet.Stop()
+		telemetry.Stop()
+		return nil, err
+	}
+	guard, err := NewDatabaseGuard(ingesterSet.Database(), cfg.Ingest.SpoolDir, feeds, ingesterSet.Replay)
+	if err != nil {
+		ingesterSet.Stop()
+		telemetry.Stop()
+		return nil, err
+	}
+	guard.SetDeadLetterStore(ingesterSet.DeadLetters())
+	guard.StartDown(ingesterSet.LoadState)
+	ingesterSet.SetDatabaseGuard(guard)
+
+	for _, worker := range ingesterSet.Workers() {
 		pollerSet.SetHandlerByID(
-			ingester.cfg.ID,
+			worker.FeedID(),
 			func(ctx context.Context, result PollResult) error {
-				ingester := ingester
-				return ingester.Ingest(ctx, result.ToFeedFrame())
+				return worker.Submit(ctx, result.ToFeedFrame())
 			},
 		)
 	}
@@ -45,17 +72,40 @@ func NewWatcher(ctx context.Context, cfg platform.SingleConfig) (*Watcher, error
 		cfg:         cfg,
 		pollerSet:   pollerSet,
 		ingesterSet: ingesterSet,
+		guard:       guard,
 		metrics:     metrics,
 		telemetry:   telemetry,
 	}, nil
 }
 
 func (watcher *Watcher) Watch(ctx context.Context) error {
-	return watcher.pollerSet.Poll(ctx)
+	// Workers outlive the poll context so frames already fetched can still be drained on shutdown
+	if err := watcher.ingesterSet.Start(context.WithoutCancel(ctx)); err != nil {
+		return err
+	}
+
+	// A fatal guard error cancels polling; database outages are otherwise absorbed by the spool
+	group, subctx := errgroup.WithContext(ctx)
+	group.Go(func() error { return watcher.guard.Run(subctx) })
+	group.Go(func() error { return watcher.pollerSet.Poll(subctx) })
+	group.Go(func() error {
+		return watcher.ingesterSet.Database().RunMaintenance(subctx, watcher.cfg.Retention.Options())
+	})
+	watchErr := group.Wait()
+
+	drainCtx, cancel := context.WithTimeout(context.Background(), drainTimeout)
+	defer cancel()
+	if err := watcher.ingesterSet.Drain(drainCtx); err != nil {
+		log.Printf("drain ingesters: %v", err)
+	}
+	if err := watcher.guard.Close(); err != nil {
+		log.Printf("close spool: %v", err)
+	}
+
+	return watchErr
 }
 
 func (watcher *Watcher) Close() {
 	watcher.telemetry.Stop()
-	watcher.pollerSet.Stop()
 	watcher.ingesterSet.Stop()
 }
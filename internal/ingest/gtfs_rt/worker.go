@@ -0,0 +1,322 @@
+package gtfs_rt
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+
+	database "tarediiran-industries.com/gtfs-services/internal/db"
+	"tarediiran-industries.com/gtfs-services/internal/platform"
+	"tarediiran-industries.com/gtfs-services/internal/repository"
+)
+
+var ErrWorkerNotRunning = errors.New("feed worker is not running")
+
+type WorkerState int
+
+const (
+	WorkerIdle WorkerState = iota
+	WorkerRunning
+	WorkerDraining
+	WorkerStopped
+)
+
+func (state WorkerState) String() string {
+	switch state {
+	case WorkerIdle:
+		return "idle"
+	case WorkerRunning:
+		return "running"
+	case WorkerDraining:
+		return "draining"
+	case WorkerStopped:
+		return "stopped"
+	default:
+		return fmt.Sprintf("WorkerState(%d)", int(state))
+	}
+}
+
+// FeedWorker owns one feed's ingester and pipeline. Live watching and playback both push frames
+// through Submit, so they share the exact same per-feed state and lifecycle.
+type FeedWorker struct {
+	ingester *FeedIngester
+	pipeline *FeedPipeline
+
+	lock   sync.Mutex
+	state  WorkerState
+	cancel context.CancelFunc
+	done   chan struct{}
+	runErr error
+}
+
+func NewFeedWorker(ingester *FeedIngester, config platform.PipelineConfig, metrics *platform.Metrics) (*FeedWorker, error) {
+	pipeline, err := NewFeedPipeline(ingester, config, metrics)
+	if err != nil {
+		return nil, fmt.Errorf("feed %s: %w", ingester.FeedID(), err)
+	}
+	return &FeedWorker{ingester: ingester, pipeline: pipeline}, nil
+}
+
+func (worker *FeedWorker) FeedID() string {
+	return worker.ingester.FeedID()
+}
+
+func (worker *FeedWorker) State() WorkerState {
+	worker.lock.Lock()
+	defer worker.lock.Unlock()
+	return worker.state
+}
+
+func (worker *FeedWorker) Start(ctx context.Context) error {
+	worker.lock.Lock()
+	defer worker.lock.Unlock()
+
+	if worker.state != WorkerIdle {
+		return fmt.Errorf("start feed worker %s: already %s", worker.FeedID(), worker.state)
+	}
+
+	runCtx, cancel := context.WithCancel(ctx)
+	worker.cancel = cancel
+	worker.done = make(chan struct{})
+	worker.state = WorkerRunning
+
+	go func() {
+		defer close(worker.done)
+		err := worker.pipeline.Run(runCtx)
+		if errors.Is(err, context.Canceled) {
+			err = nil
+		}
+		worker.lock.Lock()
+		worker.runErr = err
+		worker.lock.Unlock()
+	}()
+
+	return nil
+}
+
+func (worker *FeedWorker) Submit(ctx context.Context, frame platform.FeedFrame) error {
+	// Holding the lock across Submit keeps Drain from racing a frame into a pipeline it already waited on
+	worker.lock.Lock()
+	defer worker.lock.Unlock()
+
+	if worker.state != WorkerRunning {
+		return fmt.Errorf("submit to %s: %w (%s)", worker.FeedID(), ErrWorkerNotRunning, worker.state)
+	}
+	return worker.pipeline.Submit(ctx, frame)
+}
+
+// Drain stops accepting frames, waits for everything already submitted to be written, then stops.
+// If ctx expires first the remaining frames are abandoned.
+func (worker *FeedWorker) Drain(ctx context.Context) error {
+	worker.lock.Lock()
+	if worker.state != WorkerRunning {
+		worker.lock.Unlock()
+		return worker.Stop()
+	}
+	worker.state = WorkerDraining
+	worker.lock.Unlock()
+
+	drainErr := worker.pipeline.Wait(ctx)
+	if err := worker.Stop(); err != nil {
+		return err
+	}
+	return drainErr
+}
+
+func (worker *FeedWorker) Stop() error {
+	worker.lock.Lock()
+	if worker.state == WorkerIdle || worker.state == WorkerStopped {
+		worker.state = WorkerStopped
+		worker.lock.Unlock()
+		return nil
+	}
+	worker.state = WorkerStopped
+	cancel, done := worker.cancel, worker.done
+	worker.lock.Unlock()
+
+	cancel()
+	<-done
+
+	worker.lock.Lock()
+	defer worker.lock.Unlock()
+	return worker.runErr
+}
+
+type FeedIngesterSet struct {
+	cfg platform.SingleConfig
+
+	workers     []*FeedWorker
+	byID        map[string]*FeedWorker
+	db          *database.Database
+	repo        *repository.Repository
+	deadLetters *DeadLetterStore
+}
+
+// NewFeedIngesterSet connects to the database, retrying until ctx is done, and loads the state of
+// every feed before returning
+func NewFeedIngesterSet(
+	ctx context.Context, cfg platform.SingleConfig, metrics *platform.Metrics,
+) (*FeedIngesterSet, error) {
+	ingesterSet, err := OpenFeedIngesterSet(ctx, cfg, metrics)
+	if err != nil {
+		return nil, err
+	}
+	err = database.Retry(ctx, "connect database", database.DefaultRetryOptions(), ingesterSet.LoadState)
+	if err != nil {
+		ingesterSet.db.Close()
+		return nil, err
+	}
+	return ingesterSet, nil
+}
+
+// OpenFeedIngesterSet builds the ingesters without waiting for the database. LoadState has to
+// succeed before anything is written directly, until then frames belong in the DatabaseGuard spool.
+func OpenFeedIngesterSet(
+	ctx context.Context, cfg platform.SingleConfig, metrics *platform.Metrics,
+) (*FeedIngesterSet, error) {
+	feeds, err := cfg.Feed.ToFeedSpecs()
+	if err != nil {
+		return nil, err
+	}
+	deadLetters, err := NewDeadLetterStore(cfg.Ingest.DeadLetterDir, feeds)
+	if err != nil {
+		return nil, err
+	}
+
+	db, err := database.OpenDatabase(ctx, cfg.Database.URL, cfg.Database.PoolOptions())
+	if err != nil {
+		return nil, err
+	}
+	metrics.RegisterDatabasePool(db)
+
+	ingesterSet := &FeedIngesterSet{
+		cfg:         cfg,
+		workers:     make([]*FeedWorker, 0, len(cfg.Feed.RealTime)),
+		byID:        make(map[string]*FeedWorker),
+		db:          db,
+		repo:        repository.New(db),
+		deadLetters: deadLetters,
+	}
+
+	for _, rtcfg := range cfg.Feed.RealTime {
+		ingester, err := NewFeedIngester(rtcfg, db, ingesterSet.repo, metrics)
+		if err != nil {
+			db.Close()
+			return nil, err
+		}
+		ingester.SetDeadLetterStore(deadLetters)
+
+		worker, err := NewFeedWorker(ingester, cfg.Pipeline, metrics)
+		if err != nil {
+			db.Close()
+			return nil, err
+		}
+		ingesterSet.workers = append(ingesterSet.workers, worker)
+		ingesterSet.byID[rtcfg.ID] = worker
+	}
+
+	return ingesterSet, nil
+}
+
+// LoadState checks the schema and picks up each feed's state where the last run left off
+func (ingesterSet *FeedIngesterSet) LoadState(ctx context.Context) error {
+	if err := ingesterSet.db.RequireSchema(ctx); err != nil {
+		return err
+	}
+	for _, worker := range ingesterSet.workers {
+		if err := worker.ingester.LoadState(ctx); err != nil {
+			return fmt.Errorf("load state of %s: %w", worker.FeedID(), err)
+		}
+	}
+	return nil
+}
+
+func (ingesterSet *FeedIngesterSet) Database() *database.Database {
+	return ingesterSet.db
+}
+
+func (ingesterSet *FeedIngesterSet) DeadLetters() *DeadLetterStore {
+	return ingesterSet.deadLetters
+}
+
+func (ingesterSet *FeedIngesterSet) SetDatabaseGuard(guard *DatabaseGuard) {
+	for _, worker := range ingesterSet.workers {
+		worker.pipeline.SetDatabaseGuard(guard)
+	}
+}
+
+// Replay writes a frame that already went through Submit once straight to the database, skipping
+// the pipeline queues and the unchanged check.
+func (ingesterSet *FeedIngesterSet) Replay(ctx context.Context, frame platform.FeedFrame) error {
+	_, err := ingesterSet.replay(ctx, frame)
+	return err
+}
+
+// replay returns the dedup reason the frame was skipped for, empty when it was written
+func (ingesterSet *FeedIngesterSet) replay(ctx context.Context, frame platform.FeedFrame) (string, error) {
+	worker, ok := ingesterSet.byID[frame.FeedID]
+	if !ok {
+		return "", classify(ErrorBadPayload, fmt.Errorf("no ingester configured for feed %s", frame.FeedID))
+	}
+
+	reason := ""
+	gtfsMsg, err := worker.ingester.Decode(frame)
+	if err == nil {
+		var batch *SnapshotBatch
+		if batch, err = worker.ingester.Normalize(frame, gtfsMsg); err == nil {
+			reason, err = worker.ingester.write(ctx, batch)
+		}
+	}
+	if err != nil && ClassifyError(err) != ErrorDatabaseDown {
+		worker.ingester.DeadLetter(ctx, frame, err)
+	}
+	return reason, err
+}
+
+func (ingesterSet *FeedIngesterSet) Workers() []*FeedWorker {
+	return ingesterSet.workers
+}
+
+func (ingesterSet *FeedIngesterSet) Worker(feedID string) (*FeedWorker, bool) {
+	worker, ok := ingesterSet.byID[feedID]
+	return worker, ok
+}
+
+func (ingesterSet *FeedIngesterSet) Start(ctx context.Context) error {
+	for _, worker := range ingesterSet.workers {
+		if err := worker.Start(ctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (ingesterSet *FeedIngesterSet) Submit(ctx context.Context, frame platform.FeedFrame) error {
+	worker, ok := ingesterSet.byID[frame.FeedID]
+	if !ok {
+		return fmt.Errorf("no ingester configured for feed %s", frame.FeedID)
+	}
+	return worker.Submit(ctx, frame)
+}
+
+func (ingesterSet *FeedIngesterSet) Drain(ctx context.Context) error {
+	var errs []error
+	for _, worker := range ingesterSet.workers {
+		if err := worker.Drain(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("drain %s: %w", worker.FeedID(), err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (ingesterSet *FeedIngesterSet) Stop() {
+	for _, worker := range ingesterSet.workers {
+		worker.Stop()
+	}
+	if err := ingesterSet.deadLetters.Close(); err != nil {
+		log.Printf("close dead-letter recording: %v", err)
+	}
+	ingesterSet.db.Close()
+}
@@ -0,0 +1,358 @@
+package gtfs_rt
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/MobilityData/gtfs-realtime-bindings/golang/gtfs"
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/protobuf/proto"
+	"tarediiran-industries.com/gtfs-services/internal/platform"
+)
+
+// The workers write to a guard that considers the database down, so every frame that makes it
+// through the pipeline lands in a spool the tests can read back, without a database to talk to.
+func newTestWorkers(
+	t *testing.T, feedIDs []string, config platform.PipelineConfig,
+) ([]*FeedWorker, *DatabaseGuard, string) {
+	t.Helper()
+
+	metrics := platform.NewMetrics(prometheus.NewRegistry())
+	feeds := make([]platform.FeedSpec, 0, len(feedIDs))
+	for _, feedID := range feedIDs {
+		feeds = append(feeds, platform.FeedSpec{FeedID: feedID, URL: "http://" + feedID + ".test/rt", PollSeconds: 15})
+	}
+
+	spoolDir := t.TempDir()
+	guard, err := NewDatabaseGuard(nil, spoolDir, feeds, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	guard.MarkDown(errors.New("no database in tests"))
+
+	workers := make([]*FeedWorker, 0, len(feedIDs))
+	for _, feedID := range feedIDs {
+		ingester, err := NewFeedIngester(platform.RealTimeConfig{ID: feedID}, nil, nil, metrics)
+		if err != nil {
+			t.Fatal(err)
+		}
+		worker, err := NewFeedWorker(ingester, config, metrics)
+		if err != nil {
+			t.Fatal(err)
+		}
+		worker.pipeline.SetDatabaseGuard(guard)
+		workers = append(workers, worker)
+	}
+	return workers, guard, spoolDir
+}
+
+// testFrame carries a feed message whose header timestamp is i, so spooled frames can be put back
+// in submission order
+func testFrame(t *testing.T, feedID string, i int) platform.FeedFrame {
+	t.Helper()
+
+	timestamp := uint64(i)
+	body, err := proto.Marshal(&gtfs.FeedMessage{
+		Header: &gtfs.FeedHeader{
+			GtfsRealtimeVersion: proto.String("2.0"),
+			Timestamp:           &timestamp,
+		},
+		Entity: []*gtfs.FeedEntity{{
+			Id: proto.String(fmt.Sprintf("%s-%d", feedID, i)),
+			TripUpdate: &gtfs.TripUpdate{
+				Trip: &gtfs.TripDescriptor{TripId: proto.String(fmt.Sprintf("trip-%d", i))},
+			},
+		}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return platform.FeedFrame{
+		FeedID:     feedID,
+		CapturedAt: time.Unix(int64(i), 0),
+		Status:     200,
+		Body:       body,
+		SHA256:     sha256.Sum256(body),
+	}
+}
+
+// spooledTimestamps reads back the header timestamps of every frame spooled for each feed
+func spooledTimestamps(t *testing.T, spoolDir string) map[string][]uint64 {
+	t.Helper()
+
+	dirs, err := listRecordings(spoolDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	timestamps := make(map[string][]uint64)
+	for _, dir := range dirs {
+		reader, err := platform.OpenFeedRecording(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for {
+			frame, err := reader.Next(context.Background())
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			message := &gtfs.FeedMessage{}
+			if err := proto.Unmarshal(frame.Body, message); err != nil {
+				t.Fatalf("spooled frame of %s: %v", frame.FeedID, err)
+			}
+			timestamps[frame.FeedID] = append(timestamps[frame.FeedID], message.GetHeader().GetTimestamp())
+		}
+		reader.Close()
+	}
+	return timestamps
+}
+
+func startWorkers(t *testing.T, ctx context.Context, workers []*FeedWorker) {
+	t.Helper()
+	for _, worker := range workers {
+		if err := worker.Start(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// drainWorkers drains every worker at once, as a shutdown does
+func drainWorkers(t *testing.T, workers []*FeedWorker, timeout time.Duration) {
+	t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	errs := make([]error, len(workers))
+	var group sync.WaitGroup
+	for i, worker := range workers {
+		group.Add(1)
+		go func() {
+			defer group.Done()
+			errs[i] = worker.Drain(ctx)
+		}()
+	}
+	group.Wait()
+
+	for i, err := range errs {
+		if err != nil {
+			t.Errorf("drain %s: %v", workers[i].FeedID(), err)
+		}
+	}
+}
+
+func TestFeedWorkersConcurrentFeeds(t *testing.T) {
+	const frames = 200
+	feedIDs := []string{"alpha", "beta", "gamma", "delta"}
+	workers, guard, spoolDir := newTestWorkers(t, feedIDs, platform.PipelineConfig{
+		QueueDepth:       2,
+		DecodeWorkers:    4,
+		NormalizeWorkers: 4,
+		Overflow:         string(OverflowBlock),
+	})
+	startWorkers(t, context.Background(), workers)
+
+	stopWatching := make(chan struct{})
+	var watchers sync.WaitGroup
+	for _, worker := range workers {
+		watchers.Add(1)
+		go func() {
+			defer watchers.Done()
+			for {
+				select {
+				case <-stopWatching:
+					return
+				default:
+					worker.State()
+				}
+			}
+		}()
+	}
+
+	var submitters sync.WaitGroup
+	for _, worker := range workers {
+		submitters.Add(1)
+		go func() {
+			defer submitters.Done()
+			for i := 1; i <= frames; i++ {
+				frame := testFrame(t, worker.FeedID(), i)
+				if i%10 == 0 {
+					// Undecodable frames leave the pipeline early and mustn't hold up the rest
+					frame.Body = []byte{0xff, 0xff, 0xff}
+					frame.SHA256 = sha256.Sum256(append(frame.Body, byte(i)))
+				}
+				if err := worker.Submit(context.Background(), frame); err != nil {
+					t.Errorf("submit %s #%d: %v", worker.FeedID(), i, err)
+					return
+				}
+			}
+		}()
+	}
+	submitters.Wait()
+
+	drainWorkers(t, workers, 30*time.Second)
+	close(stopWatching)
+	watchers.Wait()
+	if err := guard.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	spooled := spooledTimestamps(t, spoolDir)
+	for _, worker := range workers {
+		if state := worker.State(); state != WorkerStopped {
+			t.Errorf("%s is %s after drain", worker.FeedID(), state)
+		}
+		err := worker.Submit(context.Background(), testFrame(t, worker.FeedID(), frames+1))
+		if !errors.Is(err, ErrWorkerNotRunning) {
+			t.Errorf("submit to drained %s: got %v, want ErrWorkerNotRunning", worker.FeedID(), err)
+		}
+
+		want := make([]uint64, 0, frames)
+		for i := 1; i <= frames; i++ {
+			if i%10 != 0 {
+				want = append(want, uint64(i))
+			}
+		}
+		got := spooled[worker.FeedID()]
+		if len(got) != len(want) {
+			t.Errorf("%s spooled %d frames, want %d", worker.FeedID(), len(got), len(want))
+			continue
+		}
+		for i := range want {
+			if got[i] != want[i] {
+				t.Errorf("%s frame %d has timestamp %d, want %d", worker.FeedID(), i, got[i], want[i])
+				break
+			}
+		}
+	}
+}
+
+func TestFeedWorkersDrainAfterDrops(t *testing.T) {
+	for _, policy := range []OverflowPolicy{OverflowDrop, OverflowCoalesce} {
+		t.Run(string(policy), func(t *testing.T) {
+			const frames = 300
+			feedIDs := []string{"north", "south", "east"}
+			workers, guard, spoolDir := newTestWorkers(t, feedIDs, platform.PipelineConfig{
+				QueueDepth:       1,
+				DecodeWorkers:    3,
+				NormalizeWorkers: 3,
+				Overflow:         string(policy),
+			})
+			startWorkers(t, context.Background(), workers)
+
+			var submitters sync.WaitGroup
+			for _, worker := range workers {
+				submitters.Add(1)
+				go func() {
+					defer submitters.Done()
+					for i := 1; i <= frames; i++ {
+						if err := worker.Submit(context.Background(), testFrame(t, worker.FeedID(), i)); err != nil {
+							t.Errorf("submit %s #%d: %v", worker.FeedID(), i, err)
+							return
+						}
+					}
+				}()
+			}
+			submitters.Wait()
+
+			// A dropped frame that isn't accounted for leaves Drain waiting until it times out
+			drainWorkers(t, workers, 30*time.Second)
+			if err := guard.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			spooled := spooledTimestamps(t, spoolDir)
+			for _, worker := range workers {
+				got := spooled[worker.FeedID()]
+				if len(got) == 0 || len(got) > frames {
+					t.Errorf("%s spooled %d of %d frames", worker.FeedID(), len(got), frames)
+				}
+				for i := 1; i < len(got); i++ {
+					if got[i] <= got[i-1] {
+						t.Errorf("%s spooled timestamp %d after %d", worker.FeedID(), got[i], got[i-1])
+						break
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestFeedWorkersStopWhileSubmitting(t *testing.T) {
+	feedIDs := []string{"one", "two", "three"}
+	workers, guard, _ := newTestWorkers(t, feedIDs, platform.PipelineConfig{
+		QueueDepth:       1,
+		DecodeWorkers:    2,
+		NormalizeWorkers: 2,
+		Overflow:         string(OverflowBlock),
+	})
+	defer guard.Close()
+
+	for _, worker := range workers {
+		err := worker.Submit(context.Background(), testFrame(t, worker.FeedID(), 1))
+		if !errors.Is(err, ErrWorkerNotRunning) {
+			t.Errorf("submit to idle %s: got %v, want ErrWorkerNotRunning", worker.FeedID(), err)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	startWorkers(t, ctx, workers)
+	for _, worker := range workers {
+		if err := worker.Start(ctx); err == nil {
+			t.Errorf("second start of %s succeeded", worker.FeedID())
+		}
+	}
+
+	var submitters sync.WaitGroup
+	for _, worker := range workers {
+		submitters.Add(1)
+		go func() {
+			defer submitters.Done()
+			for i := 1; ; i++ {
+				err := worker.Submit(ctx, testFrame(t, worker.FeedID(), i))
+				if errors.Is(err, ErrWorkerNotRunning) {
+					return
+				}
+				if err != nil {
+					t.Errorf("submit %s #%d: %v", worker.FeedID(), i, err)
+					return
+				}
+			}
+		}()
+	}
+
+	time.Sleep(20 * time.Millisecond)
+	var stoppers sync.WaitGroup
+	for _, worker := range workers {
+		// Stopping twice at once must be as safe as stopping once
+		for range 2 {
+			stoppers.Add(1)
+			go func() {
+				defer stoppers.Done()
+				if err := worker.Stop(); err != nil {
+					t.Errorf("stop %s: %v", worker.FeedID(), err)
+				}
+			}()
+		}
+	}
+	stoppers.Wait()
+	submitters.Wait()
+
+	for _, worker := range workers {
+		if state := worker.State(); state != WorkerStopped {
+			t.Errorf("%s is %s after stop", worker.FeedID(), state)
+		}
+		if err := worker.Drain(context.Background()); err != nil {
+			t.Errorf("drain stopped %s: %v", worker.FeedID(), err)
+		}
+	}
+}
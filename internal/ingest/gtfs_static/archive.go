@@ -0,0 +1,204 @@
+package gtfs_static
+
+import (
+	"archive/zip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+var ErrArchiveLimit = errors.New("archive exceeds extraction limits")
+
+type ExtractLimits struct {
+	MaxEntryBytes       uint64
+	MaxTotalBytes       uint64
+	MaxCompressionRatio uint64
+}
+
+// Large enough for the MTA subway feed (stop_times.txt is ~100MB) with plenty of headroom.
+var DefaultExtractLimits = ExtractLimits{
+	MaxEntryBytes:       1 << 30,
+	MaxTotalBytes:       4 << 30,
+	MaxCompressionRatio: 200,
+}
+
+type GtfsArchive struct {
+	reader  *zip.ReadCloser
+	limits  ExtractLimits
+	entries map[string]*zip.File
+}
+
+func isGtfsFileName(name string) bool {
+	for _, entry := range FileTableMapping {
+		if entry.FileName == name {
+			return true
+		}
+	}
+	return false
+}
+
+func isIgnoredArchiveEntry(name string) bool {
+	base := path.Base(name)
+	return strings.HasPrefix(name, "__MACOSX/") || strings.HasPrefix(base, "._") || base == ".DS_Store"
+}
+
+func validateArchiveEntryName(name string) error {
+	if name == "" || strings.Contains(name, `\`) {
+		return fmt.Errorf("invalid archive entry name %q", name)
+	}
+	if !filepath.IsLocal(filepath.FromSlash(name)) {
+		return fmt.Errorf("archive entry %q escapes extraction directory", name)
+	}
+	return nil
+}
+
+func OpenGtfsArchive(zipPath string, limits ExtractLimits) (*GtfsArchive, error) {
+	reader, err := zip.OpenReader(zipPath)
+	if err != nil {
+		return nil, err
+	}
+
+	archive := &GtfsArchive{
+		reader:  reader,
+		limits:  limits,
+		entries: make(map[string]*zip.File),
+	}
+
+	if err := archive.index(); err != nil {
+		reader.Close()
+		return nil, err
+	}
+
+	return archive, nil
+}
+
+func (archive *GtfsArchive) index() error {
+	rootDirs := make(map[string]bool)
+	var totalBytes uint64
+
+	for _, file := range archive.reader.File {
+		if err := validateArchiveEntryName(file.Name); err != nil {
+			return err
+		}
+		if file.FileInfo().IsDir() || isIgnoredArchiveEntry(file.Name) {
+			continue
+		}
+
+		name := path.Clean(file.Name)
+		base := path.Base(name)
+		if !isGtfsFileName(base) {
+			fmt.Printf("Unrecognized file %s - ignore for now\n", file.Name)
+			continue
+		}
+
+		if err := archive.checkDeclaredSize(file); err != nil {
+			return err
+		}
+		totalBytes += file.UncompressedSize64
+		if archive.limits.MaxTotalBytes > 0 && totalBytes > archive.limits.MaxTotalBytes {
+			return fmt.Errorf("%w: total uncompressed size exceeds %d bytes", ErrArchiveLimit, archive.limits.MaxTotalBytes)
+		}
+
+		dir := path.Dir(name)
+		rootDirs[dir] = true
+		if _, ok := archive.entries[base]; ok {
+			return fmt.Errorf("archive contains %s more than once", base)
+		}
+		archive.entries[base] = file
+	}
+
+	if len(rootDirs) > 1 {
+		return fmt.Errorf("archive contains GTFS files in more than one folder")
+	}
+	for dir := range rootDirs {
+		if dir != "." && strings.Contains(dir, "/") {
+			return fmt.Errorf("GTFS files nested too deeply in archive: %s", dir)
+		}
+	}
+
+	return nil
+}
+
+func (archive *GtfsArchive) checkDeclaredSize(file *zip.File) error {
+	limits := archive.limits
+	if limits.MaxEntryBytes > 0 && file.UncompressedSize64 > limits.MaxEntryBytes {
+		return fmt.Errorf("%w: %s is %d bytes (limit %d)", ErrArchiveLimit, file.Name, file.UncompressedSize64, limits.MaxEntryBytes)
+	}
+	if limits.MaxCompressionRatio > 0 && file.CompressedSize64 > 0 &&
+		file.UncompressedSize64/file.CompressedSize64 > limits.MaxCompressionRatio {
+		return fmt.Errorf("%w: %s has suspicious compression ratio", ErrArchiveLimit, file.Name)
+	}
+	return nil
+}
+
+func (archive *GtfsArchive) Close() error {
+	return archive.reader.Close()
+}
+
+func (archive *GtfsArchive) Has(fileName string) bool {
+	_, ok := archive.entries[fileName]
+	return ok
+}
+
+func (archive *GtfsArchive) Validate() error {
+	for _, entry := range FileTableMapping {
+		if entry.Required && !archive.Has(entry.FileName) {
+			return fmt.Errorf("Required file %s is missing", entry.FileName)
+		}
+	}
+	return nil
+}
+
+// Open streams a GTFS file straight out of the archive. The reader fails if the entry inflates past
+// its declared size or the configured limits, since zip headers can't be trusted.
+func (archive *GtfsArchive) Open(fileName string) (io.ReadCloser, error) {
+	file, ok := archive.entries[fileName]
+	if !ok {
+		return nil, fmt.Errorf("%s: %w", fileName, os.ErrNotExist)
+	}
+
+	contents, err := file.Open()
+	if err != nil {
+		return nil, err
+	}
+
+	limit := file.UncompressedSize64
+	if archive.limits.MaxEntryBytes > 0 && archive.limits.MaxEntryBytes < limit {
+		limit = archive.limits.MaxEntryBytes
+	}
+
+	return &limitedEntryReader{name: file.Name, reader: contents, remaining: limit}, nil
+}
+
+type limitedEntryReader struct {
+	name      string
+	reader    io.ReadCloser
+	remaining uint64
+}
+
+func (entry *limitedEntryReader) Read(buffer []byte) (int, error) {
+	if entry.remaining == 0 {
+		// Probe for one more byte to tell a clean EOF apart from an entry lying about its size
+		var probe [1]byte
+		n, err := entry.reader.Read(probe[:])
+		if n > 0 {
+			return 0, fmt.Errorf("%w: %s inflates past its declared size", ErrArchiveLimit, entry.name)
+		}
+		return 0, err
+	}
+
+	if uint64(len(buffer)) > entry.remaining {
+		buffer = buffer[:entry.remaining]
+	}
+	n, err := entry.reader.Read(buffer)
+	entry.remaining -= uint64(n)
+	return n, err
+}
+
+func (entry *limitedEntryReader) Close() error {
+	return entry.reader.Close()
+}
@@ -0,0 +1,137 @@
+package gtfs_static
+
+import (
+	"archive/zip"
+	"errors"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+type testEntry struct {
+	name string
+	body string
+	// Size written to the zip header instead of the real one, to fake an entry lying about its size
+	declaredSize uint64
+}
+
+func writeTestArchive(t *testing.T, entries []testEntry) string {
+	t.Helper()
+
+	zipPath := filepath.Join(t.TempDir(), "gtfs.zip")
+	file, err := os.Create(zipPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writer := zip.NewWriter(file)
+	for _, entry := range entries {
+		var dst io.Writer
+		if entry.declaredSize > 0 {
+			dst, err = writer.CreateRaw(&zip.FileHeader{
+				Name:               entry.name,
+				Method:             zip.Store,
+				CRC32:              crc32.ChecksumIEEE([]byte(entry.body)),
+				CompressedSize64:   uint64(len(entry.body)),
+				UncompressedSize64: entry.declaredSize,
+			})
+		} else {
+			dst, err = writer.Create(entry.name)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := io.WriteString(dst, entry.body); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := file.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return zipPath
+}
+
+func TestGtfsArchiveGuards(t *testing.T) {
+	stops := "stop_id,stop_name\nTST01,Test Square\n"
+	limits := ExtractLimits{MaxEntryBytes: 64, MaxTotalBytes: 1 << 20, MaxCompressionRatio: 200}
+
+	tests := []struct {
+		name     string
+		entries  []testEntry
+		wantOpen bool
+		// The stops.txt read back, when the archive opens
+		wantRead bool
+	}{
+		{"top level", []testEntry{{name: "stops.txt", body: stops}}, true, true},
+		{"one root folder", []testEntry{{name: "feed/stops.txt", body: stops}, {name: "feed/agency.txt", body: "agency_id\n"}}, true, true},
+		{"two levels deep", []testEntry{{name: "feed/gtfs/stops.txt", body: stops}}, false, false},
+		{"parent dir", []testEntry{{name: "../stops.txt", body: stops}}, false, false},
+		{"absolute path", []testEntry{{name: "/tmp/stops.txt", body: stops}}, false, false},
+		{"over entry limit", []testEntry{{name: "stops.txt", body: stops + strings.Repeat("TST02,Test Park\n", 4)}}, false, false},
+		{"inflates past declared size", []testEntry{{name: "stops.txt", body: stops, declaredSize: 8}}, true, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			archive, err := OpenGtfsArchive(writeTestArchive(t, test.entries), limits)
+			if !test.wantOpen {
+				if err == nil {
+					archive.Close()
+					t.Fatal("archive opened")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer archive.Close()
+
+			file, err := archive.Open("stops.txt")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer file.Close()
+			body, err := io.ReadAll(file)
+			if !test.wantRead {
+				if err == nil {
+					t.Fatalf("read %d bytes past the declared size", len(body))
+				}
+				return
+			}
+			if err != nil || string(body) != stops {
+				t.Errorf("stops.txt = %q, %v", body, err)
+			}
+		})
+	}
+}
+
+func TestLimitedEntryReader(t *testing.T) {
+	tests := []struct {
+		name      string
+		body      string
+		remaining uint64
+		wantErr   error
+	}{
+		{"within limit", "stop_id\n", 8, nil},
+		{"past limit", "stop_id\nTST01\n", 8, ErrArchiveLimit},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			entry := &limitedEntryReader{
+				name:      "stops.txt",
+				reader:    io.NopCloser(strings.NewReader(test.body)),
+				remaining: test.remaining,
+			}
+			_, err := io.ReadAll(entry)
+			if !errors.Is(err, test.wantErr) {
+				t.Errorf("read = %v, want %v", err, test.wantErr)
+			}
+		})
+	}
+}
@@ -5,14 +5,30 @@ import (
 	"flag"
 	"fmt"
 	"io"
+	"time"
 
 	"github.com/BurntSushi/toml"
 	"tarediiran-industries.com/gtfs-services/internal/platform"
 )
 
+type DownloadConfigFile struct {
+	TimeoutSeconds       float64 `toml:"timeout_sec"`
+	HeaderTimeoutSeconds float64 `toml:"header_timeout_sec"`
+	MaxAttempts          int     `toml:"max_attempts"`
+	BackoffSeconds       float64 `toml:"backoff_sec"`
+	MaxBackoffSeconds    float64 `toml:"max_backoff_sec"`
+	CacheDir             string  `toml:"cache_dir"`
+	SHA256               string  `toml:"sha256"`
+}
+
 type ConfigFile struct {
-	DefaultUrl      string `toml:"default_url"`
-	DefaultDatabase string `toml:"default_database"`
+	DefaultUrl      string             `toml:"default_url"`
+	DefaultDatabase string             `toml:"default_database"`
+	TelemetryUrl    string             `toml:"telemetry_url"`
+	SecretsFile     string             `toml:"secrets_file"`
+	Download        DownloadConfigFile `toml:"download"`
+
+	Auth platform.RequestAuthConfig `toml:"auth"`
 }
 
 type Config struct {
@@ -28,6 +44,37 @@ type Config struct {
 	// Output args - either can dry-run or write to a database connection
 	DryRun             *bool
 	DatabaseConnection string
+
+	Download     DownloadOptions
+	TelemetryUrl string
+}
+
+func secondsToDuration(seconds float64) time.Duration {
+	return time.Duration(seconds * float64(time.Second))
+}
+
+func (file DownloadConfigFile) applyTo(opts *DownloadOptions) {
+	if file.TimeoutSeconds > 0 {
+		opts.Timeout = secondsToDuration(file.TimeoutSeconds)
+	}
+	if file.HeaderTimeoutSeconds > 0 {
+		opts.HeaderTimeout = secondsToDuration(file.HeaderTimeoutSeconds)
+	}
+	if file.MaxAttempts > 0 {
+		opts.MaxAttempts = file.MaxAttempts
+	}
+	if file.BackoffSeconds > 0 {
+		opts.InitialBackoff = secondsToDuration(file.BackoffSeconds)
+	}
+	if file.MaxBackoffSeconds > 0 {
+		opts.MaxBackoff = secondsToDuration(file.MaxBackoffSeconds)
+	}
+	if file.CacheDir != "" {
+		opts.CacheDir = file.CacheDir
+	}
+	if file.SHA256 != "" {
+		opts.ExpectedSHA256 = file.SHA256
+	}
 }
 
 func LoadConfigFromToml(path string) (ConfigFile, error) {
@@ -41,7 +88,7 @@ func LoadConfigFromToml(path string) (ConfigFile, error) {
 }
 
 func ParseArgs(programName string, args []string, errOut io.Writer) (Config, error) {
-	var cfg Config
+	cfg := Config{Download: DefaultDownloadOptions()}
 
 	fs := flag.NewFlagSet(programName, flag.ContinueOnError)
 	fs.SetOutput(errOut)
@@ -61,6 +108,12 @@ func ParseArgs(programName string, args []string, errOut io.Writer) (Config, err
 	cfg.DryRun = fs.Bool("dry-run", false, "If specified, shows what would be ingested without performing any DB writes")
 	fs.StringVar(&cfg.DatabaseConnection, "database", "", "Path to target database")
 
+	fs.DurationVar(&cfg.Download.Timeout, "timeout", cfg.Download.Timeout, "Time limit for each download attempt")
+	fs.IntVar(&cfg.Download.MaxAttempts, "attempts", cfg.Download.MaxAttempts, "Download attempts before giving up")
+	fs.StringVar(&cfg.Download.CacheDir, "cache-dir", cfg.Download.CacheDir, "Directory for cached static feed downloads")
+	fs.StringVar(&cfg.Download.ExpectedSHA256, "sha256", "", "Expected SHA-256 of the downloaded zip")
+	fs.StringVar(&cfg.TelemetryUrl, "telemetry", "", "Address to serve download metrics on")
+
 	if err := fs.Parse(args); err != nil {
 		return Config{}, err
 	}
@@ -78,6 +131,16 @@ func ParseArgs(programName string, args []string, errOut io.Writer) (Config, err
 
 		cfg.Url = tomlCfg.DefaultUrl
 		cfg.DatabaseConnection = tomlCfg.DefaultDatabase
+		cfg.TelemetryUrl = tomlCfg.TelemetryUrl
+		tomlCfg.Download.applyTo(&cfg.Download)
+
+		secrets, err := platform.LoadSecretStore(tomlCfg.SecretsFile)
+		if err != nil {
+			return Config{}, err
+		}
+		if cfg.Download.Auth, err = tomlCfg.Auth.Resolve(secrets); err != nil {
+			return Config{}, fmt.Errorf("static feed auth: %w", err)
+		}
 	}
 
 	if err := cfg.Validate(); err != nil {
@@ -112,5 +175,9 @@ func Main(programName string, args []string, stdOut, errOut io.Writer) int {
 		return -1
 	}
 
-	return Run(cfg)
+	if err := Run(cfg); err != nil {
+		fmt.Fprintln(errOut, "Error:", err)
+		return -1
+	}
+	return 0
 }
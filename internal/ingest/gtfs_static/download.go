@@ -0,0 +1,428 @@
+package gtfs_static
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"tarediiran-industries.com/gtfs-services/internal/platform"
+)
+
+type DownloadProgress struct {
+	URL      string
+	Received int64
+	Total    int64 // -1 when the server didn't send a length
+}
+
+type DownloadProgressFunc func(progress DownloadProgress)
+
+type DownloadOptions struct {
+	// Per-attempt limits. HeaderTimeout covers connect + response headers, Timeout the whole body.
+	HeaderTimeout time.Duration
+	Timeout       time.Duration
+
+	MaxAttempts    int
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+
+	CacheDir       string
+	ExpectedSHA256 string
+
+	Auth *platform.RequestAuth
+
+	Progress DownloadProgressFunc
+}
+
+type DownloadResult struct {
+	Path         string
+	SHA256       string
+	ETag         string
+	LastModified string
+	Bytes        int64
+	NotModified  bool
+}
+
+type downloadCacheEntry struct {
+	URL          string    `json:"url"`
+	ETag         string    `json:"etag,omitempty"`
+	LastModified string    `json:"last_modified,omitempty"`
+	SHA256       string    `json:"sha256,omitempty"`
+	Bytes        int64     `json:"bytes"`
+	FetchedAt    time.Time `json:"fetched_at"`
+}
+
+type Downloader struct {
+	client  *http.Client
+	opts    DownloadOptions
+	metrics *platform.DownloadMetrics
+}
+
+// Errors wrapped in retryableError are worth another attempt; everything else fails the download.
+type retryableError struct {
+	err error
+}
+
+func (err retryableError) Error() string { return err.err.Error() }
+func (err retryableError) Unwrap() error { return err.err }
+
+func DefaultDownloadCacheDir() string {
+	cacheDir, err := os.UserCacheDir()
+	if err != nil {
+		cacheDir = os.TempDir()
+	}
+	return filepath.Join(cacheDir, "gtfs-ingest")
+}
+
+func DefaultDownloadOptions() DownloadOptions {
+	return DownloadOptions{
+		HeaderTimeout:  30 * time.Second,
+		Timeout:        10 * time.Minute,
+		MaxAttempts:    5,
+		InitialBackoff: time.Second,
+		MaxBackoff:     time.Minute,
+		CacheDir:       DefaultDownloadCacheDir(),
+		Progress:       PrintDownloadProgress(2 * time.Second),
+	}
+}
+
+func PrintDownloadProgress(interval time.Duration) DownloadProgressFunc {
+	last := time.Time{}
+	return func(progress DownloadProgress) {
+		done := progress.Total >= 0 && progress.Received >= progress.Total
+		if !done && time.Since(last) < interval {
+			return
+		}
+		last = time.Now()
+
+		if progress.Total > 0 {
+			fmt.Printf(
+				"Downloading %s: %.1f/%.1f MB (%.0f%%)\n",
+				progress.URL,
+				float64(progress.Received)/1e6,
+				float64(progress.Total)/1e6,
+				100*float64(progress.Received)/float64(progress.Total),
+			)
+		} else {
+			fmt.Printf("Downloading %s: %.1f MB\n", progress.URL, float64(progress.Received)/1e6)
+		}
+	}
+}
+
+func NewDownloader(opts DownloadOptions, metrics *platform.DownloadMetrics) *Downloader {
+	defaults := DefaultDownloadOptions()
+	if opts.HeaderTimeout <= 0 {
+		opts.HeaderTimeout = defaults.HeaderTimeout
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = defaults.Timeout
+	}
+	if opts.MaxAttempts <= 0 {
+		opts.MaxAttempts = defaults.MaxAttempts
+	}
+	if opts.InitialBackoff <= 0 {
+		opts.InitialBackoff = defaults.InitialBackoff
+	}
+	if opts.MaxBackoff <= 0 {
+		opts.MaxBackoff = defaults.MaxBackoff
+	}
+	if opts.CacheDir == "" {
+		opts.CacheDir = defaults.CacheDir
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.ResponseHeaderTimeout = opts.HeaderTimeout
+
+	return &Downloader{
+		client:  &http.Client{Transport: transport},
+		opts:    opts,
+		metrics: metrics,
+	}
+}
+
+func (downloader *Downloader) cachePaths(url string) (zipPath, metaPath, partPath, partMetaPath string) {
+	key := sha256.Sum256([]byte(url))
+	base := filepath.Join(downloader.opts.CacheDir, hex.EncodeToString(key[:8]))
+	return base + ".zip", base + ".json", base + ".zip.part", base + ".part.json"
+}
+
+func readCacheEntry(metaPath string) (downloadCacheEntry, bool) {
+	entry := downloadCacheEntry{}
+	buffer, err := os.ReadFile(metaPath)
+	if err != nil {
+		return entry, false
+	}
+	if err := json.Unmarshal(buffer, &entry); err != nil {
+		return entry, false
+	}
+	return entry, true
+}
+
+func writeCacheEntry(metaPath string, entry downloadCacheEntry) error {
+	buffer, err := json.MarshalIndent(entry, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(metaPath, buffer, 0o644)
+}
+
+func hashFile(path string) (string, int64, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", 0, err
+	}
+	defer file.Close()
+
+	hasher := sha256.New()
+	size, err := io.Copy(hasher, file)
+	if err != nil {
+		return "", 0, err
+	}
+	return hex.EncodeToString(hasher.Sum(nil)), size, nil
+}
+
+func (downloader *Downloader) backoff(attempt int) time.Duration {
+	delay := downloader.opts.InitialBackoff << (attempt - 1)
+	if delay <= 0 || delay > downloader.opts.MaxBackoff {
+		delay = downloader.opts.MaxBackoff
+	}
+	return delay
+}
+
+// Download fetches url into the cache directory, reusing the cached copy when the server reports
+// it unchanged and resuming partial transfers left behind by failed attempts.
+func (downloader *Downloader) Download(ctx context.Context, url string) (DownloadResult, error) {
+	if err := os.MkdirAll(downloader.opts.CacheDir, 0o755); err != nil {
+		return DownloadResult{}, fmt.Errorf("create download cache: %w", err)
+	}
+
+	start := time.Now()
+	defer func() {
+		downloader.metrics.DownloadDurationSeconds.Observe(time.Since(start).Seconds())
+	}()
+
+	var lastErr error
+	for attempt := 1; attempt <= downloader.opts.MaxAttempts; attempt++ {
+		if attempt > 1 {
+			delay := downloader.backoff(attempt - 1)
+			fmt.Printf("Download attempt %d failed (%v), retrying in %s\n", attempt-1, lastErr, delay)
+			downloader.metrics.DownloadRetriesTotal.Inc()
+
+			select {
+			case <-ctx.Done():
+				return DownloadResult{}, ctx.Err()
+			case <-time.After(delay):
+			}
+		}
+
+		result, err := downloader.attempt(ctx, url)
+		if err == nil {
+			if result.NotModified {
+				downloader.metrics.DownloadsTotal.WithLabelValues("not_modified").Inc()
+			} else {
+				downloader.metrics.DownloadsTotal.WithLabelValues("downloaded").Inc()
+			}
+			return result, nil
+		}
+
+		lastErr = err
+		var retryable retryableError
+		if !errors.As(err, &retryable) || ctx.Err() != nil {
+			break
+		}
+	}
+
+	downloader.metrics.DownloadsTotal.WithLabelValues("failed").Inc()
+	return DownloadResult{}, fmt.Errorf("Failed to download %s: %w", url, lastErr)
+}
+
+func (downloader *Downloader) attempt(ctx context.Context, url string) (DownloadResult, error) {
+	zipPath, metaPath, partPath, partMetaPath := downloader.cachePaths(url)
+
+	ctx, cancel := context.WithTimeout(ctx, downloader.opts.Timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return DownloadResult{}, err
+	}
+	downloader.opts.Auth.Apply(req)
+
+	cached, hasCached := readCacheEntry(metaPath)
+	if _, err := os.Stat(zipPath); err != nil {
+		hasCached = false
+	}
+
+	var resumeFrom int64
+	// Only resume when the server gave us a validator, otherwise we might splice two versions together
+	partial, hasPartial := readCacheEntry(partMetaPath)
+	hasPartial = hasPartial && (partial.ETag != "" || partial.LastModified != "")
+	if info, err := os.Stat(partPath); err == nil && hasPartial && info.Size() > 0 {
+		resumeFrom = info.Size()
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", resumeFrom))
+		if partial.ETag != "" {
+			req.Header.Set("If-Range", partial.ETag)
+		} else if partial.LastModified != "" {
+			req.Header.Set("If-Range", partial.LastModified)
+		}
+	} else if hasCached {
+		if cached.ETag != "" {
+			req.Header.Set("If-None-Match", cached.ETag)
+		}
+		if cached.LastModified != "" {
+			req.Header.Set("If-Modified-Since", cached.LastModified)
+		}
+	}
+
+	resp, err := downloader.client.Do(req)
+	if err != nil {
+		return DownloadResult{}, retryableError{err}
+	}
+	defer resp.Body.Close()
+
+	var partFile *os.File
+	switch {
+	case resp.StatusCode == http.StatusNotModified && hasCached:
+		return downloader.useCached(url, zipPath, cached)
+
+	case resp.StatusCode == http.StatusPartialContent && resumeFrom > 0:
+		if !strings.HasPrefix(resp.Header.Get("Content-Range"), fmt.Sprintf("bytes %d-", resumeFrom)) {
+			os.Remove(partPath)
+			return DownloadResult{}, retryableError{fmt.Errorf("unexpected Content-Range %q", resp.Header.Get("Content-Range"))}
+		}
+		partFile, err = os.OpenFile(partPath, os.O_WRONLY|os.O_APPEND, 0o644)
+
+	case resp.StatusCode == http.StatusOK:
+		resumeFrom = 0
+		partFile, err = os.OpenFile(partPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o644)
+
+	case resp.StatusCode == http.StatusRequestedRangeNotSatisfiable:
+		os.Remove(partPath)
+		return DownloadResult{}, retryableError{fmt.Errorf("HTTP Error: status code %d", resp.StatusCode)}
+
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		return DownloadResult{}, retryableError{fmt.Errorf("HTTP Error: status code %d", resp.StatusCode)}
+
+	default:
+		return DownloadResult{}, fmt.Errorf("HTTP Error: status code %d", resp.StatusCode)
+	}
+	if err != nil {
+		return DownloadResult{}, err
+	}
+
+	entry := downloadCacheEntry{
+		URL:          url,
+		ETag:         resp.Header.Get("ETag"),
+		LastModified: resp.Header.Get("Last-Modified"),
+		FetchedAt:    time.Now(),
+	}
+	if err := writeCacheEntry(partMetaPath, entry); err != nil {
+		partFile.Close()
+		return DownloadResult{}, err
+	}
+
+	total := int64(-1)
+	if resp.ContentLength >= 0 {
+		total = resumeFrom + resp.ContentLength
+	}
+
+	progress := &progressWriter{
+		url:      url,
+		received: resumeFrom,
+		total:    total,
+		report:   downloader.opts.Progress,
+		metrics:  downloader.metrics,
+	}
+	_, copyErr := io.Copy(io.MultiWriter(partFile, progress), resp.Body)
+	closeErr := partFile.Close()
+	if copyErr != nil {
+		return DownloadResult{}, retryableError{fmt.Errorf("read body: %w", copyErr)}
+	}
+	if closeErr != nil {
+		return DownloadResult{}, closeErr
+	}
+
+	sum, size, err := hashFile(partPath)
+	if err != nil {
+		return DownloadResult{}, err
+	}
+	if total >= 0 && size != total {
+		return DownloadResult{}, retryableError{fmt.Errorf("short download: got %d of %d bytes", size, total)}
+	}
+	if expected := downloader.opts.ExpectedSHA256; expected != "" && !strings.EqualFold(expected, sum) {
+		os.Remove(partPath)
+		os.Remove(partMetaPath)
+		return DownloadResult{}, retryableError{fmt.Errorf("checksum mismatch: expected %s, got %s", expected, sum)}
+	}
+
+	if err := os.Rename(partPath, zipPath); err != nil {
+		return DownloadResult{}, err
+	}
+	os.Remove(partMetaPath)
+
+	entry.SHA256 = sum
+	entry.Bytes = size
+	if err := writeCacheEntry(metaPath, entry); err != nil {
+		return DownloadResult{}, err
+	}
+
+	fmt.Printf("Downloaded %s -> %s (%d bytes, sha256 %s)\n", url, zipPath, size, sum)
+	return DownloadResult{
+		Path:         zipPath,
+		SHA256:       sum,
+		ETag:         entry.ETag,
+		LastModified: entry.LastModified,
+		Bytes:        size,
+	}, nil
+}
+
+func (downloader *Downloader) useCached(url string, zipPath string, cached downloadCacheEntry) (DownloadResult, error) {
+	sum, size, err := hashFile(zipPath)
+	if err != nil {
+		return DownloadResult{}, err
+	}
+
+	// A corrupted cache entry is dropped so the next attempt does a full GET
+	if cached.SHA256 != "" && sum != cached.SHA256 {
+		os.Remove(zipPath)
+		return DownloadResult{}, retryableError{fmt.Errorf("cached copy of %s is corrupt", url)}
+	}
+	if expected := downloader.opts.ExpectedSHA256; expected != "" && !strings.EqualFold(expected, sum) {
+		return DownloadResult{}, fmt.Errorf("checksum mismatch: expected %s, cached copy is %s", expected, sum)
+	}
+
+	fmt.Printf("Not modified: %s (using cached %s)\n", url, zipPath)
+	return DownloadResult{
+		Path:         zipPath,
+		SHA256:       sum,
+		ETag:         cached.ETag,
+		LastModified: cached.LastModified,
+		Bytes:        size,
+		NotModified:  true,
+	}, nil
+}
+
+type progressWriter struct {
+	url      string
+	received int64
+	total    int64
+	report   DownloadProgressFunc
+	metrics  *platform.DownloadMetrics
+}
+
+func (writer *progressWriter) Write(buffer []byte) (int, error) {
+	writer.received += int64(len(buffer))
+	writer.metrics.DownloadBytesTotal.Add(float64(len(buffer)))
+	if writer.report != nil {
+		writer.report(DownloadProgress{URL: writer.url, Received: writer.received, Total: writer.total})
+	}
+	return len(buffer), nil
+}
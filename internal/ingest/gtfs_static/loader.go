@@ -2,25 +2,22 @@ package gtfs_static
 
 import (
 	"context"
-	"crypto/sha256"
-	"database/sql"
 	"encoding/csv"
-	"encoding/hex"
-	"encoding/json"
 	"fmt"
-	"io"
-	"os"
-	"path/filepath"
-	"strings"
+	"slices"
 
+	"github.com/prometheus/client_golang/prometheus"
 	"tarediiran-industries.com/gtfs-services/internal/db"
 	database "tarediiran-industries.com/gtfs-services/internal/db"
+	"tarediiran-industries.com/gtfs-services/internal/platform"
+	"tarediiran-industries.com/gtfs-services/internal/repository"
 )
 
 type Ingestor struct {
-	feedId int
-	db     database.DBTX
-	ctx    context.Context
+	feedId  int64
+	repo    *repository.Repository
+	archive *GtfsArchive
+	ctx     context.Context
 }
 
 type FileTableEntry struct {
@@ -42,310 +39,154 @@ var FileTableMapping = []FileTableEntry{
 	{FileName: "transfers.txt", TableName: "transfers", Required: false, Loader: loadTransfers},
 }
 
-func ReadCSVAsMapRows(filePath string) ([]map[string]string, error) {
-	file, err := os.Open(filePath)
-	if err != nil {
-		return nil, err
-	}
-	defer file.Close()
-
-	reader := csv.NewReader(file)
-	headers, err := reader.Read()
-	if err != nil {
-		return nil, err
-	}
-
-	var records []map[string]string
-	for {
-		row, err := reader.Read()
-		if err != nil {
-			break
-		}
-
-		record := make(map[string]string)
-		if len(row) != len(headers) {
-			return nil, fmt.Errorf("Row has different number of fields than headers")
-		}
-
-		for i, header := range headers {
-			record[header] = row[i]
-		}
-		records = append(records, record)
-	}
-	return records, nil
-}
+type staticRowLoader func(
+	ctx context.Context, table string, feedId int64, header []string, rows repository.StaticRowReader,
+) (int64, error)
 
-func printRows(slice []map[string]string) {
-	js, err := json.MarshalIndent(slice, "", "  ")
-	if err != nil {
-		panic(err)
-	}
-	fmt.Println(string(js))
-}
-
-func buildInsertQuery(tableName string, record map[string]string) (string, []any) {
-	keys := make([]string, 0, len(record))
-	values := make([]any, 0, len(record))
-	placeholders := make([]string, 0, len(record))
-
-	i := 1
-	for col, val := range record {
-		keys = append(keys, col)
-		placeholders = append(placeholders, fmt.Sprintf("$%d", i))
-		values = append(values, val)
-		i++
-	}
-
-	keysStr := strings.Join(keys, ", ")
-	placeholdersStr := strings.Join(placeholders, ", ")
-	query := fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)", tableName, keysStr, placeholdersStr)
-	return query, values
-}
-
-func (ingestor *Ingestor) loadGeneric(filePath string, tableName string) error {
-	fmt.Printf("Loading %s from %s\n", tableName, filePath)
-	rows, err := ReadCSVAsMapRows(filePath)
+// loadRows streams fileName out of the archive into tableName a row at a time, so even
+// stop_times.txt is never held in memory as a whole
+func (ingestor *Ingestor) loadRows(fileName string, tableName string, load staticRowLoader) error {
+	fmt.Printf("Loading %s from %s\n", tableName, fileName)
+	file, err := ingestor.archive.Open(fileName)
 	if err != nil {
 		return err
 	}
-
-	for _, row := range rows {
-		row["feed_id"] = fmt.Sprintf("%d", ingestor.feedId)
-		query, values := buildInsertQuery(tableName, row)
-		_, err := ingestor.db.ExecContext(ingestor.ctx, query, values...)
-		if err != nil {
-			return err
-		}
-	}
-	return nil
-}
-
-func ReadCSVForColumnNames(filePath string) ([]string, error) {
-	file, err := os.Open(filePath)
-	if err != nil {
-		return nil, err
-	}
 	defer file.Close()
 
 	reader := csv.NewReader(file)
-
-	// Gets just the first row, which contains the headers
+	reader.ReuseRecord = true
 	headers, err := reader.Read()
 	if err != nil {
-		return nil, err
-	}
-
-	return headers, nil
-}
-
-func CreateTempCSVWithFeedId(filePath string, feedId int) (string, error) {
-	rows, err := ReadCSVAsMapRows(filePath)
-	if err != nil {
-		return "", err
-	}
-
-	tempFile, err := os.CreateTemp("", "gtfs-ingest-*.csv")
-	if err != nil {
-		return "", err
-	}
-	defer tempFile.Close()
-
-	writer := csv.NewWriter(tempFile)
-	defer writer.Flush()
-
-	if len(rows) == 0 {
-		return "", fmt.Errorf("CSV file has no data rows")
-	}
-
-	// Write headers first
-	headers, err := ReadCSVForColumnNames(filePath)
-	if err != nil {
-		return "", err
-	}
-	headers = append(headers, "feed_id")
-	if err := writer.Write(headers); err != nil {
-		return "", err
+		return fmt.Errorf("%s: %w", fileName, err)
 	}
+	// ReuseRecord hands back the same slice on every Read, the header has to outlive it
+	headers = slices.Clone(headers)
 
-	// Write each row with feed_id prepended
-	for _, row := range rows {
-		row["feed_id"] = fmt.Sprintf("%d", feedId)
-		rowStr := make([]string, 0, len(headers))
-		for _, header := range headers {
-			rowStr = append(rowStr, row[header])
-		}
-		if err := writer.Write(rowStr); err != nil {
-			return "", err
-		}
+	if _, err := load(ingestor.ctx, tableName, ingestor.feedId, headers, reader); err != nil {
+		return fmt.Errorf("%s: %w", fileName, err)
 	}
-
-	return tempFile.Name(), nil
+	return nil
 }
 
-func (ingestor *Ingestor) loadGenericCopy(filePath string, tableName string) error {
-	fmt.Printf("Loading %s from %s\n", tableName, filePath)
-	tempPath, err := CreateTempCSVWithFeedId(filePath, ingestor.feedId)
-
-	columns, err := ReadCSVForColumnNames(tempPath)
-	if err != nil {
-		return err
-	}
+func (ingestor *Ingestor) loadGeneric(fileName string, tableName string) error {
+	return ingestor.loadRows(fileName, tableName, ingestor.repo.InsertStaticRows)
+}
 
-	_, err = ingestor.db.(db.CopyCapable).CopyFrom(ingestor.ctx, tableName, columns, tempPath)
-	return err
+func (ingestor *Ingestor) loadGenericCopy(fileName string, tableName string) error {
+	return ingestor.loadRows(fileName, tableName, ingestor.repo.CopyStaticRows)
 }
 
-func loadAgency(ingestor Ingestor, filePath string) error {
-	return ingestor.loadGeneric(filePath, "agency")
+func loadAgency(ingestor Ingestor, fileName string) error {
+	return ingestor.loadGeneric(fileName, "agency")
 }
 
-func loadRoutes(ingestor Ingestor, filePath string) error {
-	return ingestor.loadGeneric(filePath, "routes")
+func loadRoutes(ingestor Ingestor, fileName string) error {
+	return ingestor.loadGeneric(fileName, "routes")
 }
 
-func loadTrips(ingestor Ingestor, filePath string) error {
-	return ingestor.loadGenericCopy(filePath, "trips")
+func loadTrips(ingestor Ingestor, fileName string) error {
+	return ingestor.loadGenericCopy(fileName, "trips")
 }
 
-func loadStops(ingestor Ingestor, filePath string) error {
-	return ingestor.loadGenericCopy(filePath, "stops")
+func loadStops(ingestor Ingestor, fileName string) error {
+	return ingestor.loadGenericCopy(fileName, "stops")
 }
 
-func loadStopTimes(ingestor Ingestor, filePath string) error {
-	return ingestor.loadGenericCopy(filePath, "stop_times")
+func loadStopTimes(ingestor Ingestor, fileName string) error {
+	return ingestor.loadGenericCopy(fileName, "stop_times")
 }
 
-func loadCalendar(ingestor Ingestor, filePath string) error {
-	return ingestor.loadGeneric(filePath, "calendar")
+func loadCalendar(ingestor Ingestor, fileName string) error {
+	return ingestor.loadGeneric(fileName, "calendar")
 }
 
-func loadCalendarDates(ingestor Ingestor, filePath string) error {
-	return ingestor.loadGeneric(filePath, "calendar_dates")
+func loadCalendarDates(ingestor Ingestor, fileName string) error {
+	return ingestor.loadGeneric(fileName, "calendar_dates")
 }
 
-func loadShapes(ingestor Ingestor, filePath string) error {
+func loadShapes(ingestor Ingestor, fileName string) error {
 	// return loadGeneric(ingestor.ctx, ingestor.db, filePath, "shapes")
 	return nil
 }
 
-func loadTransfers(ingestor Ingestor, filePath string) error {
+func loadTransfers(ingestor Ingestor, fileName string) error {
 	// return loadGeneric(ingestor.ctx, ingestor.db, filePath, "transfers")
 	return nil
 }
 
-func ValidateGtfsDirectory(dirPath string) error {
-	for _, entry := range FileTableMapping {
-		if entry.Required {
-			filePath := filepath.Join(dirPath, entry.FileName)
-			if _, err := os.Stat(filePath); os.IsNotExist(err) {
-				return fmt.Errorf("Required file %s is missing", entry.FileName)
-			}
-		}
-	}
-
-	return nil
-}
-
-func StartFeedIngest(ctx context.Context, db db.DBTX, urlPath string, zipPath string) (int, error) {
-	file, err := os.Open(zipPath)
+func StartFeedIngest(ctx context.Context, repo *repository.Repository, urlPath string, zipPath string) (int64, error) {
+	hashHex, _, err := hashFile(zipPath)
 	if err != nil {
-		return 0, fmt.Errorf("failed to open file: %w", err)
-	}
-	defer file.Close()
-
-	hasher := sha256.New()
-	if _, err := io.Copy(hasher, file); err != nil {
 		return 0, fmt.Errorf("failed to hash file: %w", err)
 	}
-	hashSum := hasher.Sum(nil)
-	hashHex := hex.EncodeToString(hashSum)
-
-	row := db.QueryRowContext(ctx, `
-		INSERT INTO feed_version (imported_at, source_url, source_sha256)
-		VALUES (NOW(), $1, $2)
-		RETURNING feed_id
-	`, urlPath, hashHex)
-
-	var feedId int
-	if err := row.Scan(&feedId); err != nil {
-		return 0, fmt.Errorf("failed to scan feed_id: %w", err)
-	}
 
-	return feedId, nil
-}
-
-func FeedExistsForHash(ctx context.Context, db db.DBTX, zipPath string) (bool, error) {
-	file, err := os.Open(zipPath)
+	feed, err := repo.CreateFeedVersion(ctx, urlPath, hashHex)
 	if err != nil {
-		fmt.Printf("Failed to open file for hashing: %v\n", err)
-		return false, err
+		return 0, err
 	}
-	defer file.Close()
+	return feed.ID, nil
+}
 
-	hasher := sha256.New()
-	if _, err := io.Copy(hasher, file); err != nil {
-		fmt.Printf("Failed to hash file: %v\n", err)
-		return false, err
-	}
-	hashSum := hasher.Sum(nil)
-	hashHex := hex.EncodeToString(hashSum)
-	fmt.Printf("hashHex: %v\n", hashHex)
-
-	existingFeedId := 0
-	err = db.QueryRowContext(ctx, `
-		SELECT feed_id FROM feed_version
-		WHERE source_sha256 = $1
-	`, hashHex).Scan(&existingFeedId)
-
-	if err != nil && err != sql.ErrNoRows {
-		fmt.Printf("Error checking for existing feed: %v\n", err)
-		return false, err
+func FeedExistsForHash(ctx context.Context, repo *repository.Repository, zipPath string) (bool, error) {
+	hashHex, _, err := hashFile(zipPath)
+	if err != nil {
+		return false, fmt.Errorf("failed to hash file: %w", err)
 	}
 
-	return existingFeedId != 0, nil
+	_, found, err := repo.FeedVersionBySHA256(ctx, hashHex)
+	return found, err
 }
 
-func LoadGtfsFromDirectory(urlPath string, zipPath string, domainStringName string) error {
-	extractDir, err := UnzipToTempDir(zipPath)
+// LoadGtfsArchive loads a static GTFS zip, reading each file straight out of the archive
+func LoadGtfsArchive(
+	ctx context.Context, urlPath string, zipPath string, domainStringName string, registry prometheus.Registerer,
+) error {
+	archive, err := OpenGtfsArchive(zipPath, DefaultExtractLimits)
 	if err != nil {
 		return err
 	}
+	defer archive.Close()
 
-	if err := ValidateGtfsDirectory(extractDir); err != nil {
+	if err := archive.Validate(); err != nil {
 		return err
 	}
 
-	ctx := context.Background()
-	db, err := db.NewDatabaseConnection(ctx, domainStringName)
+	db, err := db.NewDatabaseConnection(ctx, domainStringName, database.PoolOptions{})
 	if err != nil {
 		return err
 	}
 	defer db.Close()
+	platform.RegisterDatabasePool(registry, db)
 
-	if exists, err := FeedExistsForHash(ctx, db, zipPath); err != nil {
+	if err := db.RequireSchema(ctx); err != nil {
 		return err
-	} else if exists {
-		fmt.Printf("GTFS data for %s has already been ingested.\n", zipPath)
-		return nil
 	}
 
-	feedId, err := StartFeedIngest(ctx, db, urlPath, zipPath)
-	if err != nil {
+	if exists, err := FeedExistsForHash(ctx, repository.New(db), zipPath); err != nil {
 		return err
+	} else if exists {
+		fmt.Printf("GTFS data for %s has already been ingested.\n", zipPath)
+		return nil
 	}
 
-	ingestor := Ingestor{feedId: feedId, db: db, ctx: ctx}
-	for _, entry := range FileTableMapping {
-		filePath := filepath.Join(extractDir, entry.FileName)
-		ctx = context.Background()
-		if entry.Loader == nil {
-			continue
-		}
-
-		if err := entry.Loader(ingestor, filePath); err != nil {
+	// One transaction for the whole archive, so a failed load leaves no partial feed version behind
+	return db.WithTx(ctx, func(tx *database.Tx) error {
+		repo := repository.New(tx)
+		feedId, err := StartFeedIngest(ctx, repo, urlPath, zipPath)
+		if err != nil {
 			return err
 		}
-	}
 
-	return nil
+		ingestor := Ingestor{feedId: feedId, repo: repo, archive: archive, ctx: ctx}
+		for _, entry := range FileTableMapping {
+			if entry.Loader == nil || !archive.Has(entry.FileName) {
+				continue
+			}
+
+			if err := entry.Loader(ingestor, entry.FileName); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
 }
@@ -5,39 +5,121 @@ import (
 	"flag"
 	"fmt"
 	"io"
+	"time"
 
 	"github.com/BurntSushi/toml"
 	database "tarediiran-industries.com/gtfs-services/internal/db"
 )
 
+// Unset fields fall back to defaults, so a bare [feed.realtime.schedule] behaves like a fixed
+// poll_sec interval with light jitter, error backoff and conditional GETs.
+type PollScheduleConfig struct {
+	JitterFraction    float64 `toml:"jitter"`
+	BackoffMaxSeconds float64 `toml:"backoff_max_sec"`
+	ConditionalGet    *bool   `toml:"conditional_get"`
+
+	// Adaptive polling tracks how often FeedHeader.timestamp changes and polls at twice that rate,
+	// bounded by min_poll_sec and max_poll_sec, half and four times poll_sec when unset.
+	Adaptive       bool    `toml:"adaptive"`
+	MinPollSeconds float64 `toml:"min_poll_sec"`
+	MaxPollSeconds float64 `toml:"max_poll_sec"`
+}
+
+// Dedup decides which frames count as repeats of a snapshot that was already ingested. Mode is
+// "hash" (raw payload, the default), "canonical" (content hash ignoring entity order) or "header"
+// (FeedHeader.timestamp must increase, so out-of-order frames are rejected too).
+type DedupConfig struct {
+	Mode string `toml:"mode"`
+	// How many recent snapshots are remembered, so feeds alternating between mirrors still dedup
+	Window int `toml:"window"`
+	// Keep the seen-set in the database so it survives restarts
+	Persist bool `toml:"persist"`
+}
+
 type RealTimeConfig struct {
-	ID          string  `toml:"id"`
-	URL         string  `toml:"rt_url"`
-	PollSeconds float64 `toml:"poll_sec"`
+	ID          string             `toml:"id"`
+	URL         string             `toml:"rt_url"`
+	PollSeconds float64            `toml:"poll_sec"`
+	Auth        RequestAuthConfig  `toml:"auth"`
+	Schedule    PollScheduleConfig `toml:"schedule"`
+	Dedup       DedupConfig        `toml:"dedup"`
 }
 
+// Nothing here downloads StaticURL, gtfs-ingest fetches the static feed from the default_url of
+// its own config. Credentials for it go in the [auth] section next to that URL instead.
 type FeedConfig struct {
 	StaticURL string           `toml:"static_url"`
 	RealTime  []RealTimeConfig `toml:"realtime"`
 }
 
+// Pool settings left at zero keep the pgxpool defaults
 type DatabaseConfig struct {
-	URL string `toml:"url"`
+	URL                     string  `toml:"url"`
+	MaxConns                int32   `toml:"max_conns"`
+	MinConns                int32   `toml:"min_conns"`
+	MaxConnLifetimeSeconds  float64 `toml:"max_conn_lifetime_sec"`
+	MaxConnIdleSeconds      float64 `toml:"max_conn_idle_sec"`
+	StatementTimeoutSeconds float64 `toml:"statement_timeout_sec"`
+}
+
+func (config DatabaseConfig) PoolOptions() database.PoolOptions {
+	return database.PoolOptions{
+		MaxConns:         config.MaxConns,
+		MinConns:         config.MinConns,
+		MaxConnLifetime:  time.Duration(config.MaxConnLifetimeSeconds * float64(time.Second)),
+		MaxConnIdleTime:  time.Duration(config.MaxConnIdleSeconds * float64(time.Second)),
+		StatementTimeout: time.Duration(config.StatementTimeoutSeconds * float64(time.Second)),
+	}
 }
 
 type ControlConfig struct {
 	DefaultFormat string `toml:"default_format"`
 }
 
+// Queue depths and worker counts apply to each feed's ingest pipeline separately
+type PipelineConfig struct {
+	QueueDepth       int    `toml:"queue_depth"`
+	DecodeWorkers    int    `toml:"decode_workers"`
+	NormalizeWorkers int    `toml:"normalize_workers"`
+	Overflow         string `toml:"overflow"`
+}
+
 type ObservabilityConfig struct {
 	TelemetryUrl string `toml:"telemetry_url"`
 }
 
+type IngestConfig struct {
+	// Frames are buffered here while the database is unreachable, defaults to the user cache dir
+	SpoolDir string `toml:"spool_dir"`
+	// Frames that failed to decode or ingest, retried with `gtfs-ctl capture replay-dead-letters`
+	DeadLetterDir string `toml:"dead_letter_dir"`
+}
+
+// Realtime data is kept in daily partitions; whole days older than window_hours are dropped by
+// gtfs-rt-ingest every interval_min, or on demand with `gtfs-ctl db prune`. Zero keeps everything.
+type RetentionConfig struct {
+	WindowHours     float64 `toml:"window_hours"`
+	Rollup          bool    `toml:"rollup"`
+	IntervalMinutes float64 `toml:"interval_min"`
+}
+
+func (config RetentionConfig) Options() database.RetentionOptions {
+	return database.RetentionOptions{
+		Window:   time.Duration(config.WindowHours * float64(time.Hour)),
+		Rollup:   config.Rollup,
+		Interval: time.Duration(config.IntervalMinutes * float64(time.Minute)),
+	}
+}
+
 type SingleConfig struct {
 	Feed          FeedConfig          `toml:"feed"`
 	Database      DatabaseConfig      `toml:"db"`
 	Control       ControlConfig       `toml:"ctl"`
+	Pipeline      PipelineConfig      `toml:"pipeline"`
+	Ingest        IngestConfig        `toml:"ingest"`
+	Retention     RetentionConfig     `toml:"retention"`
 	Observability ObservabilityConfig `toml:"observability"`
+	Secrets       SecretsConfig       `toml:"secrets"`
 }
 
 type ArgsConfig struct {
@@ -112,7 +194,8 @@ func ParseArgsAndRun(args []string, out, errOut io.Writer, runner MainFunction)
 	}
 
 	if err := runner(cfg); err != nil {
-		panic(err)
+		fmt.Fprintln(errOut, "Error:", err)
+		return 1
 	}
 
 	return 0
@@ -147,8 +230,22 @@ func (config *FeedConfig) ToFeedSpecs() ([]FeedSpec, error) {
 	return feedSpecs, nil
 }
 
+func (config *SingleConfig) ResolveAuth(auth RequestAuthConfig) (*RequestAuth, error) {
+	secrets := SecretStore{}
+	for _, credential := range auth.Credentials {
+		if credential.Secret != "" {
+			var err error
+			if secrets, err = LoadSecretStore(config.Secrets.File); err != nil {
+				return nil, err
+			}
+			break
+		}
+	}
+	return auth.Resolve(secrets)
+}
+
 func (config *SingleConfig) NewDatabase(ctx context.Context) (*database.Database, error) {
-	return database.NewDatabaseConnection(ctx, config.Database.URL)
+	return database.NewDatabaseConnection(ctx, config.Database.URL, config.Database.PoolOptions())
 }
 
 func (config *SingleConfig) NewTelemetryServer() *TelemetryServer {
@@ -0,0 +1,146 @@
+package platform
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+
+	"github.com/BurntSushi/toml"
+)
+
+// Credentials are never stored in the TOML itself, only a reference to where the value lives:
+// either an environment variable (env) or a key in the secrets file (secret).
+type CredentialConfig struct {
+	Header string `toml:"header"`
+	Query  string `toml:"query"`
+	Bearer bool   `toml:"bearer"`
+
+	Env    string `toml:"env"`
+	Secret string `toml:"secret"`
+}
+
+type RequestAuthConfig struct {
+	Headers     map[string]string  `toml:"headers"`
+	Credentials []CredentialConfig `toml:"credentials"`
+}
+
+type SecretsConfig struct {
+	File string `toml:"file"`
+}
+
+type SecretStore map[string]string
+
+type RequestAuth struct {
+	headers http.Header
+	query   map[string]string
+}
+
+func LoadSecretStore(path string) (SecretStore, error) {
+	secrets := SecretStore{}
+	if path == "" {
+		return secrets, nil
+	}
+
+	if _, err := toml.DecodeFile(path, &secrets); err != nil {
+		return nil, fmt.Errorf("load secrets file %s: %w", path, err)
+	}
+	return secrets, nil
+}
+
+func (auth RequestAuthConfig) IsEmpty() bool {
+	return len(auth.Headers) == 0 && len(auth.Credentials) == 0
+}
+
+func (credential CredentialConfig) describe() string {
+	switch {
+	case credential.Header != "":
+		return fmt.Sprintf("header %q", credential.Header)
+	case credential.Query != "":
+		return fmt.Sprintf("query parameter %q", credential.Query)
+	default:
+		return "bearer token"
+	}
+}
+
+func (credential CredentialConfig) Validate() error {
+	targets := 0
+	for _, set := range []bool{credential.Header != "", credential.Query != "", credential.Bearer} {
+		if set {
+			targets++
+		}
+	}
+	if targets != 1 {
+		return fmt.Errorf("credential must set exactly one of header, query or bearer")
+	}
+	if (credential.Env == "") == (credential.Secret == "") {
+		return fmt.Errorf("%s credential must reference exactly one of env or secret", credential.describe())
+	}
+	return nil
+}
+
+func (credential CredentialConfig) resolveValue(secrets SecretStore) (string, error) {
+	if credential.Env != "" {
+		value, ok := os.LookupEnv(credential.Env)
+		if !ok || value == "" {
+			return "", fmt.Errorf("%s credential: environment variable %s is not set", credential.describe(), credential.Env)
+		}
+		return value, nil
+	}
+
+	value, ok := secrets[credential.Secret]
+	if !ok || value == "" {
+		return "", fmt.Errorf("%s credential: secret %q not found in secrets file", credential.describe(), credential.Secret)
+	}
+	return value, nil
+}
+
+func (auth RequestAuthConfig) Resolve(secrets SecretStore) (*RequestAuth, error) {
+	resolved := &RequestAuth{
+		headers: make(http.Header),
+		query:   make(map[string]string),
+	}
+
+	for name, value := range auth.Headers {
+		resolved.headers.Set(name, value)
+	}
+
+	for _, credential := range auth.Credentials {
+		if err := credential.Validate(); err != nil {
+			return nil, err
+		}
+		value, err := credential.resolveValue(secrets)
+		if err != nil {
+			return nil, err
+		}
+
+		switch {
+		case credential.Header != "":
+			resolved.headers.Set(credential.Header, value)
+		case credential.Query != "":
+			resolved.query[credential.Query] = value
+		case credential.Bearer:
+			resolved.headers.Set("Authorization", "Bearer "+value)
+		}
+	}
+
+	return resolved, nil
+}
+
+// Apply adds the resolved headers and query credentials to an outgoing request. Safe on nil.
+func (auth *RequestAuth) Apply(req *http.Request) {
+	if auth == nil {
+		return
+	}
+
+	for name, values := range auth.headers {
+		req.Header[name] = append([]string(nil), values...)
+	}
+
+	if len(auth.query) > 0 {
+		query := req.URL.Query()
+		for name, value := range auth.query {
+			query.Set(name, value)
+		}
+		req.URL.RawQuery = query.Encode()
+	}
+}
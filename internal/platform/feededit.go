@@ -0,0 +1,247 @@
+package platform
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"time"
+)
+
+// nextRecorded is Next with the frame's source as recorded, rather than marked as a replay, for
+// copying frames into another recording
+func (reader *FeedRecordingReader) nextRecorded(ctx context.Context) (FeedFrame, error) {
+	if err := ctx.Err(); err != nil {
+		return FeedFrame{}, err
+	}
+
+	meta, err := reader.nextMatching()
+	if err != nil {
+		return FeedFrame{}, err
+	}
+	frame, err := reader.frameFromMeta(meta)
+	frame.Source = meta.Source
+	return frame, err
+}
+
+// FrameMerger interleaves the frames of several recordings by capture time. A recording can hold
+// frames up to captureSlack out of capture order, so frames are held back until no reader can still
+// return an earlier one. Frames captured at the same time keep the order of readers, then the order
+// they were written in.
+type FrameMerger struct {
+	readers []*FeedRecordingReader
+	// The latest capture time read from each reader, later frames are at most captureSlack before it
+	latest  []time.Time
+	done    []bool
+	pending []mergedFrame
+	read    int
+}
+
+type mergedFrame struct {
+	frame  FeedFrame
+	reader int
+	read   int
+}
+
+func (left mergedFrame) before(right mergedFrame) bool {
+	if !left.frame.CapturedAt.Equal(right.frame.CapturedAt) {
+		return left.frame.CapturedAt.Before(right.frame.CapturedAt)
+	}
+	if left.reader != right.reader {
+		return left.reader < right.reader
+	}
+	return left.read < right.read
+}
+
+func NewFrameMerger(readers ...*FeedRecordingReader) *FrameMerger {
+	return &FrameMerger{
+		readers: readers,
+		latest:  make([]time.Time, len(readers)),
+		done:    make([]bool, len(readers)),
+	}
+}
+
+func (merger *FrameMerger) advance(ctx context.Context, i int) error {
+	frame, err := merger.readers[i].nextRecorded(ctx)
+	if err == io.EOF {
+		merger.done[i] = true
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %w", merger.readers[i].rootDir, err)
+	}
+
+	if frame.CapturedAt.After(merger.latest[i]) {
+		merger.latest[i] = frame.CapturedAt
+	}
+	merger.read++
+	pending := mergedFrame{frame: frame, reader: i, read: merger.read}
+	at, _ := slices.BinarySearchFunc(merger.pending, pending, func(item, target mergedFrame) int {
+		if item.before(target) {
+			return -1
+		}
+		return 1
+	})
+	merger.pending = slices.Insert(merger.pending, at, pending)
+	return nil
+}
+
+// settled reports whether no reader can still return a frame to go before the earliest pending one
+func (merger *FrameMerger) settled() bool {
+	earliest := merger.pending[0].frame.CapturedAt
+	for i := range merger.readers {
+		if !merger.done[i] && !earliest.Before(merger.latest[i].Add(-captureSlack)) {
+			return false
+		}
+	}
+	return true
+}
+
+// Next returns the earliest frame left in any of the recordings, io.EOF once all are exhausted
+func (merger *FrameMerger) Next(ctx context.Context) (FeedFrame, error) {
+	for len(merger.pending) == 0 || !merger.settled() {
+		// Read from the reader holding the earliest frame back
+		lagging := -1
+		for i := range merger.readers {
+			if !merger.done[i] && (lagging < 0 || merger.latest[i].Before(merger.latest[lagging])) {
+				lagging = i
+			}
+		}
+		if lagging < 0 {
+			break
+		}
+		if err := merger.advance(ctx, lagging); err != nil {
+			return FeedFrame{}, err
+		}
+	}
+	if len(merger.pending) == 0 {
+		return FeedFrame{}, io.EOF
+	}
+
+	frame := merger.pending[0].frame
+	merger.pending = slices.Delete(merger.pending, 0, 1)
+	return frame, nil
+}
+
+// MergeFeedSpecs combines the feeds of several recordings, keeping the spec of the first recording
+// to have each feed. With feedIDs set only those feeds are kept.
+func MergeFeedSpecs(headers []RecordingHeader, feedIDs []string) []FeedSpec {
+	feeds := make([]FeedSpec, 0)
+	for _, header := range headers {
+		for _, feed := range header.Feeds {
+			if len(feedIDs) > 0 && !slices.Contains(feedIDs, feed.FeedID) {
+				continue
+			}
+			seen := slices.ContainsFunc(feeds, func(spec FeedSpec) bool {
+				return spec.FeedID == feed.FeedID
+			})
+			if !seen {
+				feeds = append(feeds, feed)
+			}
+		}
+	}
+	return feeds
+}
+
+// EditOptions describe the recordings WriteEditedRecordings creates
+type EditOptions struct {
+	// RecordingName is the name of the one recording written, or the prefix of each part when
+	// splitting. Sources should hold the uids of the recordings the frames come from.
+	Header RecordingHeaderOptions
+	Feeds  []FeedSpec
+	// Start a new recording every so often, counted from midnight in Location before the first
+	// frame. Zero writes a single recording.
+	SplitEvery time.Duration
+	Location   *time.Location
+}
+
+// EditedRecording is one recording written by WriteEditedRecordings
+type EditedRecording struct {
+	Dir    string
+	Frames int
+	First  time.Time
+	Last   time.Time
+}
+
+// WriteEditedRecordings copies the frames from frames into new recordings, renumbering them.
+// Each recording is created at its first frame and named after the time its part starts when
+// splitting, so nothing is written for a selection without frames. Existing recordings are never
+// written to, and on failure the recordings created so far are removed.
+func WriteEditedRecordings(ctx context.Context, frames *FrameMerger, opts EditOptions) ([]EditedRecording, error) {
+	written := make([]EditedRecording, 0)
+	var writer *FeedRecordingWriter
+	var partStart, partEnd, anchor time.Time
+
+	fail := func(err error) ([]EditedRecording, error) {
+		if writer != nil {
+			writer.Close()
+		}
+		for _, recording := range written {
+			os.RemoveAll(recording.Dir)
+		}
+		return nil, err
+	}
+
+	location := opts.Location
+	if location == nil {
+		location = time.Local
+	}
+
+	for {
+		frame, err := frames.Next(ctx)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fail(err)
+		}
+
+		if writer != nil && opts.SplitEvery > 0 && !frame.CapturedAt.Before(partEnd) {
+			if err := writer.Close(); err != nil {
+				writer = nil
+				return fail(err)
+			}
+			writer = nil
+		}
+
+		if writer == nil {
+			header := opts.Header
+			header.CreatedAt = frame.CapturedAt
+			if opts.SplitEvery > 0 {
+				if anchor.IsZero() {
+					day := frame.CapturedAt.In(location)
+					anchor = time.Date(day.Year(), day.Month(), day.Day(), 0, 0, 0, 0, location)
+				}
+				parts := frame.CapturedAt.Sub(anchor) / opts.SplitEvery
+				partStart = anchor.Add(parts * opts.SplitEvery)
+				partEnd = partStart.Add(opts.SplitEvery)
+				header.CreatedAt = partStart
+				header.RecordingName += "-" + partStart.In(location).Format("20060102T150405")
+			}
+
+			if _, err := os.Stat(header.GetRecordingPath()); err == nil {
+				return fail(fmt.Errorf("%s already exists", header.GetRecordingPath()))
+			}
+			if writer, err = CreateFeedRecording(opts.Feeds, header); err != nil {
+				return fail(err)
+			}
+			written = append(written, EditedRecording{Dir: writer.RootDir(), First: frame.CapturedAt})
+		}
+
+		if err := writer.Append(ctx, frame); err != nil {
+			return fail(err)
+		}
+		recording := &written[len(written)-1]
+		recording.Frames++
+		recording.Last = frame.CapturedAt
+	}
+
+	if writer != nil {
+		if err := writer.Close(); err != nil {
+			writer = nil
+			return fail(err)
+		}
+	}
+	return written, nil
+}
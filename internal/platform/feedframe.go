@@ -2,19 +2,40 @@ package platform
 
 import (
 	"bufio"
+	"bytes"
 	"context"
 	"crypto/rand"
 	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"io/fs"
+	"net/http"
 	"os"
 	"path/filepath"
+	"slices"
 	"sync"
 	"time"
 )
 
+// Response headers worth keeping with a frame, the validators and what the body claimed to be
+type FrameHeaders struct {
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"last_modified,omitempty"`
+	ContentType  string `json:"content_type,omitempty"`
+}
+
+type FrameHTTPMeta struct {
+	Status        int          `json:"status"`
+	DurationMs    int64        `json:"duration_ms"`
+	ContentLength int64        `json:"content_length"`
+	Headers       FrameHeaders `json:"headers,omitzero"`
+	// Transport failure, the frame has no status or payload
+	Error string `json:"error,omitempty"`
+}
+
 type FeedFrameMeta struct {
 	SequenceNumber int       `json:"seq"`
 	FeedID         string    `json:"feed_id"`
@@ -23,11 +44,13 @@ type FeedFrameMeta struct {
 	Source         string    `json:"source,omitempty"`
 	PayloadPath    string    `json:"payload,omitempty"`
 
-	HTTP *struct {
-		Status        int   `json:"status"`
-		DurationMs    int64 `json:"duration_ms"`
-		ContentLength int64 `json:"content_length"`
-	} `json:"http,omitempty"`
+	// Where the payload is in a segmented recording
+	Segment  int    `json:"segment,omitempty"`
+	Offset   int64  `json:"offset,omitempty"`
+	Length   int64  `json:"length,omitempty"`
+	Encoding string `json:"encoding,omitempty"`
+
+	HTTP *FrameHTTPMeta `json:"http,omitempty"`
 
 	Error string `json:"error,omitempty"`
 }
@@ -39,6 +62,24 @@ type FeedFrame struct {
 	Source     string
 	Body       []byte
 	SHA256     [32]byte
+
+	// Time from sending the request until the body was read or the request failed
+	Duration      time.Duration
+	ContentLength int64
+	Headers       FrameHeaders
+	// Set instead of a status when the request never got a response
+	FetchError string
+
+	// Why the frame couldn't be ingested, only set for dead-lettered frames
+	Error string
+}
+
+// HasPayload reports whether the frame carries a feed message to ingest, as opposed to recording
+// a failed fetch, an error status or a 304
+func (frame FeedFrame) HasPayload() bool {
+	return len(frame.Body) > 0 &&
+		frame.FetchError == "" &&
+		(frame.Status == 0 || (frame.Status >= 200 && frame.Status < 300))
 }
 
 func (frame FeedFrame) String() string {
@@ -49,6 +90,16 @@ func (frame FeedFrame) String() string {
 		timestamp = frame.CapturedAt.Format(time.RFC3339Nano)
 	}
 
+	if frame.FetchError != "" {
+		return fmt.Sprintf(
+			"FeedFrame{feed=%q source=%q ts=%s error=%q}",
+			frame.FeedID,
+			frame.Source,
+			timestamp,
+			frame.FetchError,
+		)
+	}
+
 	return fmt.Sprintf(
 		"FeedFrame{feed=%q source=%q status=%d ts=%s sha256=%s body=%dB}",
 		frame.FeedID,
@@ -71,11 +122,14 @@ type RecordingHeader struct {
 	RecordingName string `json:"recording_name,omitempty"`
 	RecordingUID  string `json:"recording_uid"`
 
-	Format    string     `json:"format"`
-	CreatedAt time.Time  `json:"created_at"`
-	TimeZone  string     `json:"time_zone,omitempty"`
-	Tool      ToolInfo   `json:"tool"`
-	Feeds     []FeedSpec `json:"feeds"`
+	Format      string     `json:"format"`
+	Compression string     `json:"compression,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+	TimeZone    string     `json:"time_zone,omitempty"`
+	Tool        ToolInfo   `json:"tool"`
+	Feeds       []FeedSpec `json:"feeds"`
+	// Uids of the recordings this one was cut or merged from
+	Sources []string `json:"sources,omitempty"`
 }
 
 type RecordingHeaderOptions struct {
@@ -84,6 +138,15 @@ type RecordingHeaderOptions struct {
 	CreatedAt     time.Time
 	TimeZone      string
 	Tool          ToolInfo
+
+	// RecordingSchemaFiles when zero. Compression only applies to segmented recordings.
+	SchemaVersion int
+	Compression   string
+
+	Sources []string
+
+	// How often appended frames are fsynced, every 5 seconds when zero
+	SyncInterval time.Duration
 }
 
 func (opts RecordingHeaderOptions) GetRecordingPath() string {
@@ -97,12 +160,21 @@ type FeedSpec struct {
 }
 
 type FeedRecordingWriter struct {
-	header     RecordingHeader
-	rootDir    string
-	payloadDir string
+	header   RecordingHeader
+	rootDir  string
+	payloads payloadWriter
+	index    *recordingIndexWriter // only kept for segmented recordings
 
 	framesFile   *os.File
 	framesWriter *bufio.Writer
+	framesOffset int64
+	// Bytes on disk, roughly: frame lines plus the payload bytes actually stored
+	size int64
+
+	syncInterval time.Duration
+	lastSync     time.Time
+	// What was found when picking up an existing recording, nil for a new one
+	resumed *RecordingRepair
 
 	sequenceNumber int
 	lock           sync.Mutex
@@ -110,11 +182,20 @@ type FeedRecordingWriter struct {
 }
 
 type FeedRecordingReader struct {
-	header  RecordingHeader
-	rootDir string
+	header   RecordingHeader
+	rootDir  string
+	payloads payloadReader
 
 	framesFile *os.File
-	decoder    *json.Decoder
+	lines      *bufio.Reader
+	// Byte offset of the next line in frames.jsonl
+	offset int64
+	// A frame read ahead by PeekMeta or a seek, returned again by the next read
+	peeked *FeedFrameMeta
+
+	filter      FrameFilter
+	index       []RecordingIndexEntry
+	indexLoaded bool
 }
 
 func NewToolInfo(name string) ToolInfo {
@@ -162,18 +243,56 @@ func BuildRecordingHeader(opts RecordingHeaderOptions, feeds []FeedSpec) (Record
 		tool.Name = "unknown"
 	}
 
+	schemaVersion := opts.SchemaVersion
+	if schemaVersion == 0 {
+		schemaVersion = RecordingSchemaFiles
+	}
+	switch schemaVersion {
+	case RecordingSchemaFiles:
+		if opts.Compression != CompressionNone {
+			return RecordingHeader{}, fmt.Errorf("compression %q needs a segmented recording", opts.Compression)
+		}
+	case RecordingSchemaSegmented:
+		if opts.Compression != CompressionNone && opts.Compression != CompressionZstd {
+			return RecordingHeader{}, fmt.Errorf("unknown recording compression %q", opts.Compression)
+		}
+	default:
+		return RecordingHeader{}, fmt.Errorf("unknown recording schema version %d", schemaVersion)
+	}
+
 	return RecordingHeader{
-		SchemaVersion: 1,
+		SchemaVersion: schemaVersion,
 		Format:        "gtfs-rt",
+		Compression:   opts.Compression,
 		RecordingName: opts.RecordingName,
 		RecordingUID:  GenerateUID(16),
 		CreatedAt:     opts.CreatedAt,
 		TimeZone:      opts.TimeZone,
 		Tool:          tool,
 		Feeds:         feeds,
+		Sources:       opts.Sources,
 	}, nil
 }
 
+// resumeRecordingHeader keeps an existing recording's identity and layout, taking the new feed
+// specs over the old ones and adding feeds it didn't have yet
+func resumeRecordingHeader(existing RecordingHeader, feeds []FeedSpec) RecordingHeader {
+	for _, feed := range feeds {
+		index := slices.IndexFunc(existing.Feeds, func(spec FeedSpec) bool {
+			return spec.FeedID == feed.FeedID
+		})
+		if index < 0 {
+			existing.Feeds = append(existing.Feeds, feed)
+		} else {
+			existing.Feeds[index] = feed
+		}
+	}
+	return existing
+}
+
+// CreateFeedRecording starts a recording, or resumes it when one already exists at the path. A
+// resumed recording keeps its uid and layout, has a torn last frame repaired and continues its
+// sequence numbers.
 func CreateFeedRecording(
 	feeds []FeedSpec,
 	opts RecordingHeaderOptions,
@@ -184,31 +303,98 @@ func CreateFeedRecording(
 		return nil, err
 	}
 
-	payloadDir := filepath.Join(recordingDir, "payloads")
-	if err := os.MkdirAll(payloadDir, 0o755); err != nil {
+	existing, err := ReadRecordingHeader(recordingDir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return openFeedRecordingWriter(recordingDir, header, opts.SyncInterval, nil)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("resume %s: %w", recordingDir, err)
+	}
+
+	repair, err := RepairFeedRecording(recordingDir)
+	if err != nil {
+		return nil, fmt.Errorf("resume %s: %w", recordingDir, err)
+	}
+	return openFeedRecordingWriter(recordingDir, resumeRecordingHeader(existing, feeds), opts.SyncInterval, &repair)
+}
+
+// openFeedRecordingWriter writes header to recordingDir and opens it for appending, after the
+// frames already there when resumed is set
+func openFeedRecordingWriter(
+	recordingDir string, header RecordingHeader, syncInterval time.Duration, resumed *RecordingRepair,
+) (*FeedRecordingWriter, error) {
+	if err := os.MkdirAll(recordingDir, 0o755); err != nil {
 		return nil, fmt.Errorf("Could not create %s: %w", recordingDir, err)
 	}
 
+	writer := &FeedRecordingWriter{
+		header:       header,
+		rootDir:      recordingDir,
+		syncInterval: syncInterval,
+		lastSync:     time.Now(),
+		resumed:      resumed,
+	}
+	if writer.syncInterval <= 0 {
+		writer.syncInterval = defaultSyncInterval
+	}
+
+	lastSegment := 0
+	if resumed != nil {
+		writer.sequenceNumber = resumed.LastSequence
+		writer.framesOffset = resumed.FramesBytes
+
+		var err error
+		if lastSegment, err = findLastSegment(recordingDir); err != nil {
+			return nil, fmt.Errorf("resume %s: %w", recordingDir, err)
+		}
+		if writer.size, err = DirSize(recordingDir); err != nil {
+			return nil, fmt.Errorf("resume %s: %w", recordingDir, err)
+		}
+	}
+
+	switch header.SchemaVersion {
+	case RecordingSchemaSegmented:
+		segments, err := newSegmentWriter(recordingDir, header.Compression, lastSegment)
+		if err != nil {
+			return nil, err
+		}
+		writer.payloads = segments
+		if writer.index, err = openRecordingIndex(recordingDir); err != nil {
+			segments.Close()
+			return nil, err
+		}
+		if resumed != nil {
+			writer.index.last = resumed.LastIndexed.CapturedAt
+		}
+	default:
+		payloadDir := filepath.Join(recordingDir, "payloads")
+		if err := os.MkdirAll(payloadDir, 0o755); err != nil {
+			return nil, fmt.Errorf("Could not create %s: %w", payloadDir, err)
+		}
+		writer.payloads = &filePayloads{rootDir: recordingDir}
+	}
+
 	headerPath := filepath.Join(recordingDir, "recording.json")
 	if err := writeJSONFileAtomic(headerPath, header, 0o644); err != nil {
+		writer.closePayloads()
 		return nil, fmt.Errorf("write recording.json: %w", err)
 	}
 
 	framesPath := filepath.Join(recordingDir, "frames.jsonl")
 	framesFile, err := os.OpenFile(framesPath, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
 	if err != nil {
+		writer.closePayloads()
 		return nil, fmt.Errorf("open frames.jsonl: %w", err)
 	}
+	if err := syncPath(recordingDir); err != nil {
+		framesFile.Close()
+		writer.closePayloads()
+		return nil, fmt.Errorf("sync %s: %w", recordingDir, err)
+	}
 
-	return &FeedRecordingWriter{
-		header:         header,
-		rootDir:        recordingDir,
-		payloadDir:     payloadDir,
-		framesFile:     framesFile,
-		framesWriter:   bufio.NewWriterSize(framesFile, 256*1024),
-		lock:           sync.Mutex{},
-		sequenceNumber: 0,
-	}, nil
+	writer.framesFile = framesFile
+	writer.framesWriter = bufio.NewWriterSize(framesFile, 256*1024)
+	return writer, nil
 }
 
 func (writer *FeedRecordingWriter) Header() RecordingHeader {
@@ -219,6 +405,19 @@ func (writer *FeedRecordingWriter) RootDir() string {
 	return writer.rootDir
 }
 
+// Size is about how many bytes the recording takes on disk
+func (writer *FeedRecordingWriter) Size() int64 {
+	writer.lock.Lock()
+	defer writer.lock.Unlock()
+	return writer.size
+}
+
+// Resumed reports what was repaired when the writer picked up an existing recording, nil when it
+// started a new one
+func (writer *FeedRecordingWriter) Resumed() *RecordingRepair {
+	return writer.resumed
+}
+
 func (writer *FeedRecordingWriter) Append(ctx context.Context, frame FeedFrame) error {
 	_ = ctx // for now we're not using the ctx, this is for future long write cancels
 
@@ -236,26 +435,34 @@ func (writer *FeedRecordingWriter) Append(ctx context.Context, frame FeedFrame)
 		FeedID:         frame.FeedID,
 		CapturedAt:     frame.CapturedAt,
 		Source:         frame.Source,
+		Error:          frame.Error,
+	}
 
-		// TODO: Fill frame status and figure out sha256 here
+	if frame.Status != 0 || frame.FetchError != "" {
+		meta.HTTP = &FrameHTTPMeta{
+			Status:        frame.Status,
+			DurationMs:    frame.Duration.Milliseconds(),
+			ContentLength: frame.ContentLength,
+			Headers:       frame.Headers,
+			Error:         frame.FetchError,
+		}
 	}
 
 	if len(frame.Body) > 0 {
-		payloadPathRel := filepath.Join(
-			"payloads",
-			fmt.Sprintf("%06d.pb", writer.sequenceNumber),
-		)
-		payloadPathAbs := filepath.Join(writer.rootDir, payloadPathRel)
-		if err := writeFileAtomic(payloadPathAbs, frame.Body, 0o644); err != nil {
+		hash := sha256.Sum256(frame.Body)
+		meta.SHA256 = hex.EncodeToString(hash[:])
+		stored, err := writer.payloads.write(&meta, frame.Body)
+		if err != nil {
 			return fmt.Errorf("write payload: %w", err)
 		}
-		meta.PayloadPath = payloadPathRel
+		writer.size += stored
 	}
 
 	line, err := json.Marshal(&meta)
 	if err != nil {
 		return fmt.Errorf("marshal frame meta: %w", err)
 	}
+
 	if _, err := writer.framesWriter.Write(line); err != nil {
 		return fmt.Errorf("write frames.jsonl: %w", err)
 	}
@@ -266,28 +473,109 @@ func (writer *FeedRecordingWriter) Append(ctx context.Context, frame FeedFrame)
 	if err := writer.framesWriter.Flush(); err != nil {
 		return fmt.Errorf("flush frames.jsonl: %w", err)
 	}
+	offset := writer.framesOffset
+	writer.framesOffset += int64(len(line)) + 1
+	writer.size += int64(len(line)) + 1
+
+	// Only once its line is out, so the index never points past the end of frames.jsonl
+	if writer.index != nil {
+		entry := RecordingIndexEntry{
+			SequenceNumber: meta.SequenceNumber,
+			CapturedAt:     meta.CapturedAt,
+			Offset:         offset,
+		}
+		if err := writer.index.observe(entry); err != nil {
+			return err
+		}
+	}
 
+	if time.Since(writer.lastSync) >= writer.syncInterval {
+		return writer.sync()
+	}
 	return nil
 }
 
+// sync makes the payloads durable before the frames that point at them, and those before the
+// index entries that point at the frames
+func (writer *FeedRecordingWriter) sync() error {
+	writer.lastSync = time.Now()
+	if err := writer.payloads.sync(); err != nil {
+		return fmt.Errorf("sync payloads: %w", err)
+	}
+	if err := writer.framesWriter.Flush(); err != nil {
+		return fmt.Errorf("flush frames.jsonl: %w", err)
+	}
+	if err := writer.framesFile.Sync(); err != nil {
+		return fmt.Errorf("sync frames.jsonl: %w", err)
+	}
+	if writer.index != nil {
+		if err := writer.index.sync(); err != nil {
+			return fmt.Errorf("sync index.jsonl: %w", err)
+		}
+	}
+	return nil
+}
+
+func (writer *FeedRecordingWriter) closePayloads() error {
+	err := writer.payloads.Close()
+	if writer.index != nil {
+		if indexErr := writer.index.Close(); err == nil {
+			err = indexErr
+		}
+	}
+	return err
+}
+
 func (writer *FeedRecordingWriter) Close() error {
-	return writer.framesFile.Close()
+	writer.lock.Lock()
+	defer writer.lock.Unlock()
+
+	if writer.closed {
+		return nil
+	}
+	writer.closed = true
+
+	err := writer.sync()
+	if closeErr := writer.closePayloads(); err == nil {
+		err = closeErr
+	}
+	if closeErr := writer.framesFile.Close(); err == nil {
+		err = closeErr
+	}
+	return err
 }
 
-func OpenFeedRecording(recordingDir string) (*FeedRecordingReader, error) {
-	var err error
+func ReadRecordingHeader(recordingDir string) (RecordingHeader, error) {
 	header := RecordingHeader{}
 	headerPath := filepath.Join(recordingDir, "recording.json")
 
 	headerBytes, err := os.ReadFile(headerPath)
 	if err != nil {
-		return nil, err
+		return header, err
 	}
 
 	if err := json.Unmarshal(headerBytes, &header); err != nil {
+		return header, fmt.Errorf("%s: %w", headerPath, err)
+	}
+	return header, nil
+}
+
+func OpenFeedRecording(recordingDir string) (*FeedRecordingReader, error) {
+	header, err := ReadRecordingHeader(recordingDir)
+	if err != nil {
 		return nil, err
 	}
 
+	var payloads payloadReader
+	switch header.SchemaVersion {
+	case 0, RecordingSchemaFiles:
+		payloads = &filePayloads{rootDir: recordingDir}
+	case RecordingSchemaSegmented:
+		payloads = newSegmentReader(recordingDir)
+	default:
+		return nil, fmt.Errorf("%s: unsupported recording schema version %d", recordingDir, header.SchemaVersion)
+	}
+
 	framesPath := filepath.Join(recordingDir, "frames.jsonl")
 	framesFile, err := os.Open(framesPath)
 	if err != nil {
@@ -297,46 +585,118 @@ func OpenFeedRecording(recordingDir string) (*FeedRecordingReader, error) {
 	return &FeedRecordingReader{
 		header:     header,
 		rootDir:    recordingDir,
+		payloads:   payloads,
 		framesFile: framesFile,
-		decoder:    json.NewDecoder(framesFile),
+		lines:      bufio.NewReaderSize(framesFile, 256*1024),
 	}, nil
 }
 
+func (reader *FeedRecordingReader) Header() RecordingHeader {
+	return reader.header
+}
+
 func (reader *FeedRecordingReader) Close() error {
-	return reader.framesFile.Close()
+	err := reader.payloads.Close()
+	if closeErr := reader.framesFile.Close(); err == nil {
+		err = closeErr
+	}
+	return err
 }
 
-func (reader *FeedRecordingReader) Reset() {
-	reader.framesFile.Seek(0, io.SeekStart)
-	reader.decoder = json.NewDecoder(reader.framesFile)
+// Reset goes back to the first frame, the filter stays in place
+func (reader *FeedRecordingReader) Reset() error {
+	return reader.seekOffset(0)
 }
 
-func (reader *FeedRecordingReader) Next(ctx context.Context) (FeedFrame, error) {
-	if !reader.decoder.More() {
-		return FeedFrame{}, io.EOF
+func (reader *FeedRecordingReader) seekOffset(offset int64) error {
+	if _, err := reader.framesFile.Seek(offset, io.SeekStart); err != nil {
+		return err
 	}
+	reader.lines.Reset(reader.framesFile)
+	reader.offset = offset
+	reader.peeked = nil
+	return nil
+}
 
-	meta := FeedFrameMeta{}
-	if err := reader.decoder.Decode(&meta); err != nil {
-		return FeedFrame{}, err
+// readMeta returns the next line of frames.jsonl, ignoring the filter
+func (reader *FeedRecordingReader) readMeta() (FeedFrameMeta, error) {
+	if reader.peeked != nil {
+		meta := *reader.peeked
+		reader.peeked = nil
+		return meta, nil
 	}
 
-	fmt.Println(meta)
+	for {
+		lineOffset := reader.offset
+		line, err := reader.lines.ReadBytes('\n')
+		reader.offset += int64(len(line))
+		if len(line) == 0 && err != nil {
+			return FeedFrameMeta{}, err
+		}
+		if err != nil && err != io.EOF {
+			return FeedFrameMeta{}, err
+		}
 
-	payloadPathAbs := filepath.Join(reader.rootDir, meta.PayloadPath)
-	payload, err := os.ReadFile(payloadPathAbs)
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 {
+			continue
+		}
+
+		meta := FeedFrameMeta{}
+		if err := json.Unmarshal(line, &meta); err != nil {
+			return FeedFrameMeta{}, fmt.Errorf("frames.jsonl at offset %d: %w", lineOffset, err)
+		}
+		return meta, nil
+	}
+}
+
+func (reader *FeedRecordingReader) Next(ctx context.Context) (FeedFrame, error) {
+	if err := ctx.Err(); err != nil {
+		return FeedFrame{}, err
+	}
+
+	meta, err := reader.nextMatching()
 	if err != nil {
 		return FeedFrame{}, err
 	}
+	return reader.frameFromMeta(meta)
+}
 
-	return FeedFrame{
+func (reader *FeedRecordingReader) frameFromMeta(meta FeedFrameMeta) (FeedFrame, error) {
+	frame := FeedFrame{
 		FeedID:     meta.FeedID,
 		CapturedAt: meta.CapturedAt,
-		Status:     200, // TODO: Address this
 		Source:     "replay",
-		Body:       payload,
-		SHA256:     sha256.Sum256(payload),
-	}, nil
+		Error:      meta.Error,
+	}
+
+	// Failed fetches and error responses are recorded without a payload
+	payload, err := reader.payloads.read(meta)
+	if err != nil {
+		return FeedFrame{}, fmt.Errorf("frame %d: %w", meta.SequenceNumber, err)
+	}
+	if payload != nil {
+		frame.Body = payload
+		frame.SHA256 = sha256.Sum256(payload)
+
+		if meta.SHA256 != "" && meta.SHA256 != hex.EncodeToString(frame.SHA256[:]) {
+			return FeedFrame{}, fmt.Errorf("frame %d: payload does not match its sha256", meta.SequenceNumber)
+		}
+	}
+
+	if meta.HTTP != nil {
+		frame.Status = meta.HTTP.Status
+		frame.Duration = time.Duration(meta.HTTP.DurationMs) * time.Millisecond
+		frame.ContentLength = meta.HTTP.ContentLength
+		frame.Headers = meta.HTTP.Headers
+		frame.FetchError = meta.HTTP.Error
+	} else if len(frame.Body) > 0 {
+		// Recordings from before HTTP metadata was kept only ever stored successful responses
+		frame.Status = http.StatusOK
+		frame.ContentLength = int64(len(frame.Body))
+	}
+
+	return frame, nil
 }
 
 func writeJSONFileAtomic(path string, v any, perm os.FileMode) error {
@@ -349,6 +709,19 @@ func writeJSONFileAtomic(path string, v any, perm os.FileMode) error {
 	return writeFileAtomic(path, buffer, perm)
 }
 
+// syncPath fsyncs a file or directory by name
+func syncPath(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	err = file.Sync()
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
 func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
 	dir := filepath.Dir(path)
 	base := filepath.Base(path)
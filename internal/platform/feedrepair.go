@@ -0,0 +1,224 @@
+package platform
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// RecordingRepair describes the state a recording was left in and what RepairFeedRecording did
+// about it
+type RecordingRepair struct {
+	LastSequence int
+	// Size of frames.jsonl once repaired, where the next frame is appended
+	FramesBytes int64
+	// A frame line torn by a crash mid-append, dropped from the end of frames.jsonl
+	TruncatedBytes int64
+	// Index entries pointing past the repaired end of frames.jsonl, or torn themselves
+	DroppedIndexEntries int
+	LastIndexed         RecordingIndexEntry
+}
+
+func (repair RecordingRepair) Repaired() bool {
+	return repair.TruncatedBytes > 0 || repair.DroppedIndexEntries > 0
+}
+
+// RepairFeedRecording drops a torn last line from frames.jsonl and index entries beyond the
+// frames that survived. Only the last line may be damaged, anything earlier is reported as
+// corruption rather than repaired.
+func RepairFeedRecording(recordingDir string) (RecordingRepair, error) {
+	repair := RecordingRepair{}
+	framesPath := filepath.Join(recordingDir, "frames.jsonl")
+
+	file, err := os.OpenFile(framesPath, os.O_RDWR, 0)
+	if errors.Is(err, fs.ErrNotExist) {
+		return repair, nil
+	}
+	if err != nil {
+		return repair, err
+	}
+	defer file.Close()
+
+	lines := bufio.NewReaderSize(file, 256*1024)
+	offset := int64(0)
+	var torn error
+	for {
+		line, err := lines.ReadBytes('\n')
+		if len(line) == 0 && err == io.EOF {
+			break
+		}
+		if err != nil && err != io.EOF {
+			return repair, err
+		}
+
+		lineOffset := offset
+		offset += int64(len(line))
+
+		if torn != nil {
+			return repair, fmt.Errorf("frames.jsonl is corrupt before its last line: %w", torn)
+		}
+		if len(bytes.TrimSpace(line)) == 0 {
+			repair.FramesBytes = offset
+			continue
+		}
+
+		meta := FeedFrameMeta{}
+		if err := json.Unmarshal(line, &meta); err != nil {
+			torn = fmt.Errorf("line at offset %d: %w", lineOffset, err)
+			continue
+		}
+		repair.LastSequence = max(repair.LastSequence, meta.SequenceNumber)
+
+		// The whole line made it but the newline didn't, so the next append would run into it
+		if !bytes.HasSuffix(line, []byte("\n")) {
+			if _, err := file.WriteAt([]byte("\n"), offset); err != nil {
+				return repair, fmt.Errorf("terminate last line of frames.jsonl: %w", err)
+			}
+			offset++
+		}
+		repair.FramesBytes = offset
+	}
+
+	if torn != nil {
+		repair.TruncatedBytes = offset - repair.FramesBytes
+		if err := file.Truncate(repair.FramesBytes); err != nil {
+			return repair, fmt.Errorf("truncate frames.jsonl: %w", err)
+		}
+		if err := file.Sync(); err != nil {
+			return repair, err
+		}
+	}
+
+	if err := repairRecordingIndex(recordingDir, &repair); err != nil {
+		return repair, err
+	}
+	return repair, nil
+}
+
+func repairRecordingIndex(recordingDir string, repair *RecordingRepair) error {
+	indexPath := filepath.Join(recordingDir, "index.jsonl")
+	indexBytes, err := os.ReadFile(indexPath)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var kept bytes.Buffer
+	for _, line := range bytes.SplitAfter(indexBytes, []byte("\n")) {
+		if len(line) == 0 {
+			continue
+		}
+		entry := RecordingIndexEntry{}
+		if !bytes.HasSuffix(line, []byte("\n")) || json.Unmarshal(line, &entry) != nil || entry.Offset >= repair.FramesBytes {
+			repair.DroppedIndexEntries++
+			continue
+		}
+		kept.Write(line)
+		repair.LastIndexed = entry
+	}
+
+	if repair.DroppedIndexEntries == 0 {
+		return nil
+	}
+	return writeFileAtomic(indexPath, kept.Bytes(), 0o644)
+}
+
+// findLastSegment is the highest numbered file in a segmented recording's segments/, zero if none
+func findLastSegment(recordingDir string) (int, error) {
+	entries, err := os.ReadDir(filepath.Join(recordingDir, "segments"))
+	if errors.Is(err, fs.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	last := 0
+	for _, entry := range entries {
+		number, err := strconv.Atoi(strings.TrimSuffix(entry.Name(), ".seg"))
+		if err == nil && strings.HasSuffix(entry.Name(), ".seg") {
+			last = max(last, number)
+		}
+	}
+	return last, nil
+}
+
+type VerifyProblem struct {
+	SequenceNumber int
+	Problem        string
+}
+
+type RecordingVerification struct {
+	Frames   int
+	Payloads int
+	Problems []VerifyProblem
+}
+
+func (verification *RecordingVerification) problem(seq int, format string, args ...any) {
+	verification.Problems = append(verification.Problems, VerifyProblem{
+		SequenceNumber: seq,
+		Problem:        fmt.Sprintf(format, args...),
+	})
+}
+
+// VerifyFeedRecording reads every frame of a recording, checking each payload against its SHA-256
+// and the sequence numbers for gaps. A torn last line is reported as a problem, not an error.
+func VerifyFeedRecording(recordingDir string) (RecordingVerification, error) {
+	verification := RecordingVerification{}
+
+	reader, err := OpenFeedRecording(recordingDir)
+	if err != nil {
+		return verification, err
+	}
+	defer reader.Close()
+
+	lastSeq := 0
+	for {
+		meta, err := reader.readMeta()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			verification.problem(lastSeq+1, "unreadable frame metadata: %v", err)
+			break
+		}
+		verification.Frames++
+
+		if meta.SequenceNumber != lastSeq+1 {
+			verification.problem(meta.SequenceNumber, "follows sequence number %d", lastSeq)
+		}
+		lastSeq = meta.SequenceNumber
+
+		payload, err := reader.payloads.read(meta)
+		if err != nil {
+			verification.problem(meta.SequenceNumber, "payload unreadable: %v", err)
+			continue
+		}
+		if payload == nil {
+			if meta.SHA256 != "" {
+				verification.problem(meta.SequenceNumber, "has a sha256 but no payload")
+			}
+			continue
+		}
+
+		verification.Payloads++
+		hash := sha256.Sum256(payload)
+		if actual := hex.EncodeToString(hash[:]); actual != meta.SHA256 {
+			verification.problem(meta.SequenceNumber, "payload sha256 %s does not match recorded %s", actual, meta.SHA256)
+		}
+	}
+
+	return verification, nil
+}
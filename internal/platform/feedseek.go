@@ -0,0 +1,123 @@
+package platform
+
+import (
+	"io"
+	"slices"
+	"time"
+)
+
+// FrameFilter narrows the frames a FeedRecordingReader returns. Zero fields don't filter.
+type FrameFilter struct {
+	FeedIDs []string
+	// Frames captured at or after From and before To
+	From time.Time
+	To   time.Time
+}
+
+func (filter FrameFilter) matchesFeed(feedID string) bool {
+	return len(filter.FeedIDs) == 0 || slices.Contains(filter.FeedIDs, feedID)
+}
+
+// SetFilter applies to frames read from here on. It does not move the reader, SeekTime to
+// filter.From avoids scanning everything before it.
+func (reader *FeedRecordingReader) SetFilter(filter FrameFilter) {
+	reader.filter = filter
+}
+
+// Frames are appended as their requests complete, so a slow request can be written after frames
+// captured up to its duration later. Poll requests time out well within this.
+const captureSlack = indexInterval
+
+// nextMatching reads past frames the filter excludes. A frame captured at or after filter.To can
+// still be followed by earlier ones, only a frame captured captureSlack past it ends the recording.
+func (reader *FeedRecordingReader) nextMatching() (FeedFrameMeta, error) {
+	filter := reader.filter
+	for {
+		meta, err := reader.readMeta()
+		if err != nil {
+			return meta, err
+		}
+
+		if !filter.To.IsZero() && !meta.CapturedAt.Before(filter.To) {
+			if !meta.CapturedAt.Before(filter.To.Add(captureSlack)) {
+				reader.peeked = &meta
+				return FeedFrameMeta{}, io.EOF
+			}
+			continue
+		}
+		if !filter.From.IsZero() && meta.CapturedAt.Before(filter.From) {
+			continue
+		}
+		if !filter.matchesFeed(meta.FeedID) {
+			continue
+		}
+		return meta, nil
+	}
+}
+
+// PeekMeta returns the metadata of the frame Next would return, without loading its payload
+func (reader *FeedRecordingReader) PeekMeta() (FeedFrameMeta, error) {
+	meta, err := reader.nextMatching()
+	if err != nil {
+		return meta, err
+	}
+	reader.peeked = &meta
+	return meta, nil
+}
+
+func (reader *FeedRecordingReader) loadIndex() []RecordingIndexEntry {
+	if !reader.indexLoaded {
+		// Without an index seeking scans from the start, which is slower but gives the same result
+		reader.index, _ = ReadRecordingIndex(reader.rootDir)
+		reader.indexLoaded = true
+	}
+	return reader.index
+}
+
+// seekPast moves to the last indexed frame skipIndexed allows, or the start of the recording, then
+// reads past the frames skip is true for
+func (reader *FeedRecordingReader) seekPast(
+	skipIndexed func(entry RecordingIndexEntry) bool, skip func(meta FeedFrameMeta) bool,
+) error {
+	offset := int64(0)
+	for _, entry := range reader.loadIndex() {
+		if !skipIndexed(entry) {
+			break
+		}
+		offset = entry.Offset
+	}
+	if err := reader.seekOffset(offset); err != nil {
+		return err
+	}
+
+	for {
+		meta, err := reader.readMeta()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if !skip(meta) {
+			reader.peeked = &meta
+			return nil
+		}
+	}
+}
+
+// SeekSeq positions the reader at the first frame with a sequence number of at least seq
+func (reader *FeedRecordingReader) SeekSeq(seq int) error {
+	return reader.seekPast(
+		func(entry RecordingIndexEntry) bool { return entry.SequenceNumber < seq },
+		func(meta FeedFrameMeta) bool { return meta.SequenceNumber < seq },
+	)
+}
+
+// SeekTime positions the reader at the first frame captured at or after at. A slow request can be
+// appended after frames captured later than it, the index lookup leaves captureSlack for that.
+func (reader *FeedRecordingReader) SeekTime(at time.Time) error {
+	return reader.seekPast(
+		func(entry RecordingIndexEntry) bool { return entry.CapturedAt.Before(at.Add(-captureSlack)) },
+		func(meta FeedFrameMeta) bool { return meta.CapturedAt.Before(at) },
+	)
+}
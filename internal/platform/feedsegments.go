@@ -0,0 +1,390 @@
+package platform
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	// Every payload is its own file under payloads/
+	RecordingSchemaFiles = 1
+	// Payloads are appended to a few large files under segments/, with an index for seeking
+	RecordingSchemaSegmented = 2
+)
+
+const (
+	CompressionNone = ""
+	CompressionZstd = "zstd"
+)
+
+const (
+	defaultSegmentBytes = 256 << 20
+	indexInterval       = time.Minute
+	defaultSyncInterval = 5 * time.Second
+)
+
+// payloadWriter stores frame bodies for a recording, filling in where each one went on its meta
+// and returning how many bytes that took
+type payloadWriter interface {
+	write(meta *FeedFrameMeta, body []byte) (int64, error)
+	// sync makes everything written so far durable
+	sync() error
+	Close() error
+}
+
+type payloadReader interface {
+	// read returns the frame's body, nil when the frame was recorded without one
+	read(meta FeedFrameMeta) ([]byte, error)
+	Close() error
+}
+
+type filePayloads struct {
+	rootDir string
+	// Payload files written since the last sync
+	pending []string
+}
+
+func (payloads *filePayloads) write(meta *FeedFrameMeta, body []byte) (int64, error) {
+	payloadPathRel := filepath.Join("payloads", fmt.Sprintf("%06d.pb", meta.SequenceNumber))
+	if err := writeFileAtomic(filepath.Join(payloads.rootDir, payloadPathRel), body, 0o644); err != nil {
+		return 0, err
+	}
+	meta.PayloadPath = payloadPathRel
+	payloads.pending = append(payloads.pending, payloadPathRel)
+	return int64(len(body)), nil
+}
+
+func (payloads *filePayloads) sync() error {
+	if len(payloads.pending) == 0 {
+		return nil
+	}
+	for _, payloadPathRel := range payloads.pending {
+		if err := syncPath(filepath.Join(payloads.rootDir, payloadPathRel)); err != nil {
+			return err
+		}
+	}
+	payloads.pending = payloads.pending[:0]
+	// The renames that put each payload in place live in the directory
+	return syncPath(filepath.Join(payloads.rootDir, "payloads"))
+}
+
+func (payloads *filePayloads) read(meta FeedFrameMeta) ([]byte, error) {
+	if meta.PayloadPath == "" {
+		return nil, nil
+	}
+	return os.ReadFile(filepath.Join(payloads.rootDir, meta.PayloadPath))
+}
+
+func (payloads *filePayloads) Close() error {
+	return payloads.sync()
+}
+
+type segmentRef struct {
+	sha256   string
+	segment  int
+	offset   int64
+	length   int64
+	encoding string
+}
+
+func (ref segmentRef) apply(meta *FeedFrameMeta) {
+	meta.Segment = ref.segment
+	meta.Offset = ref.offset
+	meta.Length = ref.length
+	meta.Encoding = ref.encoding
+}
+
+type segmentWriter struct {
+	dir      string
+	maxBytes int64
+	encoder  *zstd.Encoder // nil when payloads are stored as is
+
+	segment int
+	file    *os.File
+	buffer  *bufio.Writer
+	size    int64
+
+	// Feeds often serve the same body for several polls, those frames point back at the last copy
+	last map[string]segmentRef
+}
+
+func segmentPath(dir string, segment int) string {
+	return filepath.Join(dir, fmt.Sprintf("%06d.seg", segment))
+}
+
+// newSegmentWriter starts writing after segment, resumed recordings never append to a segment
+// written before
+func newSegmentWriter(rootDir string, compression string, segment int) (*segmentWriter, error) {
+	dir := filepath.Join(rootDir, "segments")
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("create %s: %w", dir, err)
+	}
+
+	writer := &segmentWriter{
+		dir:      dir,
+		maxBytes: defaultSegmentBytes,
+		segment:  segment,
+		last:     make(map[string]segmentRef),
+	}
+
+	switch compression {
+	case CompressionNone:
+	case CompressionZstd:
+		encoder, err := zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.SpeedDefault))
+		if err != nil {
+			return nil, fmt.Errorf("create zstd encoder: %w", err)
+		}
+		writer.encoder = encoder
+	default:
+		return nil, fmt.Errorf("unknown recording compression %q", compression)
+	}
+
+	return writer, nil
+}
+
+func (writer *segmentWriter) roll() error {
+	if writer.file != nil {
+		if err := writer.closeSegment(); err != nil {
+			return err
+		}
+	}
+
+	writer.segment++
+	file, err := os.OpenFile(segmentPath(writer.dir, writer.segment), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return fmt.Errorf("open segment %d: %w", writer.segment, err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	writer.file = file
+	writer.buffer = bufio.NewWriterSize(file, 256*1024)
+	writer.size = info.Size()
+	return nil
+}
+
+func (writer *segmentWriter) write(meta *FeedFrameMeta, body []byte) (int64, error) {
+	if last, ok := writer.last[meta.FeedID]; ok && last.sha256 == meta.SHA256 {
+		last.apply(meta)
+		return 0, nil
+	}
+
+	data, encoding := body, CompressionNone
+	if writer.encoder != nil {
+		data, encoding = writer.encoder.EncodeAll(body, nil), CompressionZstd
+	}
+
+	if writer.file == nil || (writer.size > 0 && writer.size+int64(len(data)) > writer.maxBytes) {
+		if err := writer.roll(); err != nil {
+			return 0, err
+		}
+	}
+
+	ref := segmentRef{
+		sha256:   meta.SHA256,
+		segment:  writer.segment,
+		offset:   writer.size,
+		length:   int64(len(data)),
+		encoding: encoding,
+	}
+	if _, err := writer.buffer.Write(data); err != nil {
+		return 0, fmt.Errorf("write segment %d: %w", writer.segment, err)
+	}
+	if err := writer.buffer.Flush(); err != nil {
+		return 0, fmt.Errorf("flush segment %d: %w", writer.segment, err)
+	}
+	writer.size += ref.length
+
+	writer.last[meta.FeedID] = ref
+	ref.apply(meta)
+	return ref.length, nil
+}
+
+func (writer *segmentWriter) sync() error {
+	if writer.file == nil {
+		return nil
+	}
+	if err := writer.buffer.Flush(); err != nil {
+		return fmt.Errorf("flush segment %d: %w", writer.segment, err)
+	}
+	return writer.file.Sync()
+}
+
+func (writer *segmentWriter) closeSegment() error {
+	if err := writer.sync(); err != nil {
+		writer.file.Close()
+		return err
+	}
+	return writer.file.Close()
+}
+
+func (writer *segmentWriter) Close() error {
+	if writer.encoder != nil {
+		writer.encoder.Close()
+	}
+	if writer.file == nil {
+		return nil
+	}
+	err := writer.closeSegment()
+	writer.file = nil
+	return err
+}
+
+type segmentReader struct {
+	dir     string
+	files   map[int]*os.File
+	decoder *zstd.Decoder
+}
+
+func newSegmentReader(rootDir string) *segmentReader {
+	return &segmentReader{
+		dir:   filepath.Join(rootDir, "segments"),
+		files: make(map[int]*os.File),
+	}
+}
+
+func (reader *segmentReader) read(meta FeedFrameMeta) ([]byte, error) {
+	if meta.Segment == 0 {
+		return nil, nil
+	}
+
+	file, ok := reader.files[meta.Segment]
+	if !ok {
+		var err error
+		file, err = os.Open(segmentPath(reader.dir, meta.Segment))
+		if err != nil {
+			return nil, err
+		}
+		reader.files[meta.Segment] = file
+	}
+
+	data := make([]byte, meta.Length)
+	if _, err := file.ReadAt(data, meta.Offset); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, fmt.Errorf("read segment %d at %d: %w", meta.Segment, meta.Offset, err)
+	}
+
+	switch meta.Encoding {
+	case CompressionNone:
+		return data, nil
+	case CompressionZstd:
+		if reader.decoder == nil {
+			decoder, err := zstd.NewReader(nil)
+			if err != nil {
+				return nil, fmt.Errorf("create zstd decoder: %w", err)
+			}
+			reader.decoder = decoder
+		}
+		body, err := reader.decoder.DecodeAll(data, nil)
+		if err != nil {
+			return nil, fmt.Errorf("decompress segment %d at %d: %w", meta.Segment, meta.Offset, err)
+		}
+		return body, nil
+	default:
+		return nil, fmt.Errorf("unknown payload encoding %q", meta.Encoding)
+	}
+}
+
+func (reader *segmentReader) Close() error {
+	if reader.decoder != nil {
+		reader.decoder.Close()
+	}
+	var firstErr error
+	for segment, file := range reader.files {
+		if err := file.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+		delete(reader.files, segment)
+	}
+	return firstErr
+}
+
+// RecordingIndexEntry points at the line in frames.jsonl of the first frame captured in each
+// minute of a segmented recording
+type RecordingIndexEntry struct {
+	SequenceNumber int       `json:"seq"`
+	CapturedAt     time.Time `json:"captured_at"`
+	Offset         int64     `json:"offset"`
+}
+
+type recordingIndexWriter struct {
+	file   *os.File
+	buffer *bufio.Writer
+	last   time.Time
+}
+
+func openRecordingIndex(rootDir string) (*recordingIndexWriter, error) {
+	file, err := os.OpenFile(filepath.Join(rootDir, "index.jsonl"), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("open index.jsonl: %w", err)
+	}
+	return &recordingIndexWriter{file: file, buffer: bufio.NewWriter(file)}, nil
+}
+
+func (index *recordingIndexWriter) observe(entry RecordingIndexEntry) error {
+	if !index.last.IsZero() && entry.CapturedAt.Sub(index.last) < indexInterval {
+		return nil
+	}
+	index.last = entry.CapturedAt
+
+	line, err := json.Marshal(&entry)
+	if err != nil {
+		return err
+	}
+	if _, err := index.buffer.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("write index.jsonl: %w", err)
+	}
+	return index.buffer.Flush()
+}
+
+func (index *recordingIndexWriter) sync() error {
+	if err := index.buffer.Flush(); err != nil {
+		return err
+	}
+	return index.file.Sync()
+}
+
+func (index *recordingIndexWriter) Close() error {
+	if err := index.sync(); err != nil {
+		index.file.Close()
+		return err
+	}
+	return index.file.Close()
+}
+
+// ReadRecordingIndex loads the time index of a segmented recording, recordings without one return
+// no entries
+func ReadRecordingIndex(recordingDir string) ([]RecordingIndexEntry, error) {
+	file, err := os.Open(filepath.Join(recordingDir, "index.jsonl"))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	entries := make([]RecordingIndexEntry, 0)
+	decoder := json.NewDecoder(file)
+	for decoder.More() {
+		entry := RecordingIndexEntry{}
+		if err := decoder.Decode(&entry); err != nil {
+			// A torn last line only loses the newest entry, seeking falls back to scanning from before it
+			break
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
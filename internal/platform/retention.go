@@ -0,0 +1,317 @@
+package platform
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DirSize adds up the size of every file under dir
+func DirSize(dir string) (int64, error) {
+	size := int64(0)
+	err := filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() {
+			return nil
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		size += info.Size()
+		return nil
+	})
+	return size, err
+}
+
+var byteSizeUnits = map[string]int64{
+	"":    1,
+	"b":   1,
+	"k":   1 << 10,
+	"kb":  1000,
+	"kib": 1 << 10,
+	"m":   1 << 20,
+	"mb":  1000 * 1000,
+	"mib": 1 << 20,
+	"g":   1 << 30,
+	"gb":  1000 * 1000 * 1000,
+	"gib": 1 << 30,
+	"t":   1 << 40,
+	"tb":  1000 * 1000 * 1000 * 1000,
+	"tib": 1 << 40,
+}
+
+// ParseByteSize reads sizes like 512MiB, 20GB or 1048576. KB, MB and so on are powers of 1000,
+// KiB or a bare K powers of 1024.
+func ParseByteSize(value string) (int64, error) {
+	trimmed := strings.TrimSpace(value)
+	split := strings.IndexFunc(trimmed, func(r rune) bool {
+		return (r < '0' || r > '9') && r != '.'
+	})
+	number, unit := trimmed, ""
+	if split >= 0 {
+		number, unit = trimmed[:split], strings.ToLower(strings.TrimSpace(trimmed[split:]))
+	}
+
+	multiplier, ok := byteSizeUnits[unit]
+	if !ok {
+		return 0, fmt.Errorf("invalid size %q: unknown unit %q", value, unit)
+	}
+	amount, err := strconv.ParseFloat(number, 64)
+	if err != nil || amount < 0 {
+		return 0, fmt.Errorf("invalid size %q", value)
+	}
+	return int64(amount * float64(multiplier)), nil
+}
+
+func FormatByteSize(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%dB", size)
+	}
+	value, exponent := float64(size)/unit, 0
+	for value >= unit && exponent < 3 {
+		value /= unit
+		exponent++
+	}
+	return fmt.Sprintf("%.1f%ciB", value, "KMGT"[exponent])
+}
+
+// RecordingInfo is a recording found under a recordings directory
+type RecordingInfo struct {
+	Dir    string
+	Header RecordingHeader
+	Bytes  int64
+}
+
+// Compact reports whether the recording already stores its payloads in zstd compressed segments
+func (info RecordingInfo) Compact() bool {
+	return info.Header.SchemaVersion == RecordingSchemaSegmented && info.Header.Compression == CompressionZstd
+}
+
+// ListRecordings finds the recordings directly under root, oldest first. Hidden directories are
+// skipped, that is where recordings are rebuilt while being compacted. A recording whose header
+// can't be read is logged and left out rather than failing the whole listing.
+func ListRecordings(root string) ([]RecordingInfo, error) {
+	entries, err := os.ReadDir(root)
+	if err != nil {
+		return nil, err
+	}
+
+	recordings := make([]RecordingInfo, 0)
+	for _, entry := range entries {
+		if !entry.IsDir() || strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		dir := filepath.Join(root, entry.Name())
+		header, err := ReadRecordingHeader(dir)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			log.Printf("skipping recording %s: %v", dir, err)
+			continue
+		}
+		size, err := DirSize(dir)
+		if err != nil {
+			return nil, err
+		}
+		recordings = append(recordings, RecordingInfo{Dir: dir, Header: header, Bytes: size})
+	}
+
+	sort.SliceStable(recordings, func(i, j int) bool {
+		left, right := recordings[i].Header.CreatedAt, recordings[j].Header.CreatedAt
+		if !left.Equal(right) {
+			return left.Before(right)
+		}
+		return recordings[i].Dir < recordings[j].Dir
+	})
+	return recordings, nil
+}
+
+// CompactFeedRecording rewrites a recording as zstd compressed segments, keeping its header and
+// frames. The copy is built next to the original and swapped in once complete, so a failure
+// leaves the original as it was.
+func CompactFeedRecording(ctx context.Context, recordingDir string) error {
+	reader, err := OpenFeedRecording(recordingDir)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	header := reader.Header()
+	header.SchemaVersion = RecordingSchemaSegmented
+	header.Compression = CompressionZstd
+
+	parent, name := filepath.Split(filepath.Clean(recordingDir))
+	compactDir := filepath.Join(parent, "."+name+".compact")
+	oldDir := filepath.Join(parent, "."+name+".old")
+	if err := os.RemoveAll(compactDir); err != nil {
+		return err
+	}
+
+	writer, err := openFeedRecordingWriter(compactDir, header, time.Minute, nil)
+	if err != nil {
+		return err
+	}
+	for {
+		frame, err := reader.nextRecorded(ctx)
+		if err == io.EOF {
+			break
+		}
+		if err == nil {
+			err = writer.Append(ctx, frame)
+		}
+		if err != nil {
+			writer.Close()
+			os.RemoveAll(compactDir)
+			return fmt.Errorf("compact %s: %w", recordingDir, err)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		os.RemoveAll(compactDir)
+		return fmt.Errorf("compact %s: %w", recordingDir, err)
+	}
+	reader.Close()
+
+	if err := os.Rename(recordingDir, oldDir); err != nil {
+		os.RemoveAll(compactDir)
+		return err
+	}
+	if err := os.Rename(compactDir, recordingDir); err != nil {
+		// Put the original back rather than leave nothing at its name
+		os.Rename(oldDir, recordingDir)
+		os.RemoveAll(compactDir)
+		return err
+	}
+	if err := syncPath(parent); err != nil {
+		return err
+	}
+	return os.RemoveAll(oldDir)
+}
+
+// sweepCompactions clears up after compactions that never finished. A half built copy is removed,
+// and an original that was moved aside but never replaced goes back to its name.
+func sweepCompactions(root string) error {
+	entries, err := os.ReadDir(root)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		name := entry.Name()
+		if !entry.IsDir() || !strings.HasPrefix(name, ".") {
+			continue
+		}
+		dir := filepath.Join(root, name)
+		switch {
+		case strings.HasSuffix(name, ".compact"):
+			log.Printf("removing unfinished compaction %s", dir)
+			if err := os.RemoveAll(dir); err != nil {
+				return err
+			}
+		case strings.HasSuffix(name, ".old"):
+			original := filepath.Join(root, strings.TrimSuffix(name[1:], ".old"))
+			if _, err := os.Stat(original); errors.Is(err, fs.ErrNotExist) {
+				log.Printf("restoring %s from an unfinished compaction", original)
+				if err := os.Rename(dir, original); err != nil {
+					return err
+				}
+				continue
+			}
+			log.Printf("removing %s left over from compaction", dir)
+			if err := os.RemoveAll(dir); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// RecordingQuota caps how much space the recordings under a directory may take
+type RecordingQuota struct {
+	MaxBytes int64
+	// Compress the oldest recordings that aren't already before deleting any
+	Compress bool
+}
+
+type QuotaReport struct {
+	UsedBytes  int64
+	FreedBytes int64
+	Compressed []string
+	Deleted    []string
+}
+
+// EnforceRecordingQuota brings the recordings under root within quota, oldest first. The active
+// recording is never touched, so a report can still be over quota when that one alone is too big.
+// Whatever an interrupted compaction left behind is cleared up first.
+func EnforceRecordingQuota(
+	ctx context.Context, root string, quota RecordingQuota, active string,
+) (QuotaReport, error) {
+	report := QuotaReport{}
+	if quota.MaxBytes <= 0 {
+		return report, nil
+	}
+
+	if err := sweepCompactions(root); err != nil {
+		return report, err
+	}
+	recordings, err := ListRecordings(root)
+	if err != nil {
+		return report, err
+	}
+	candidates := make([]RecordingInfo, 0, len(recordings))
+	for _, recording := range recordings {
+		report.UsedBytes += recording.Bytes
+		if filepath.Clean(recording.Dir) != filepath.Clean(active) {
+			candidates = append(candidates, recording)
+		}
+	}
+
+	if quota.Compress {
+		for i := range candidates {
+			if report.UsedBytes <= quota.MaxBytes {
+				return report, nil
+			}
+			recording := &candidates[i]
+			if recording.Compact() {
+				continue
+			}
+			if err := CompactFeedRecording(ctx, recording.Dir); err != nil {
+				return report, err
+			}
+			size, err := DirSize(recording.Dir)
+			if err != nil {
+				return report, err
+			}
+			report.Compressed = append(report.Compressed, recording.Dir)
+			report.FreedBytes += recording.Bytes - size
+			report.UsedBytes -= recording.Bytes - size
+			recording.Bytes = size
+		}
+	}
+
+	for _, recording := range candidates {
+		if report.UsedBytes <= quota.MaxBytes {
+			break
+		}
+		if err := os.RemoveAll(recording.Dir); err != nil {
+			return report, fmt.Errorf("delete %s: %w", recording.Dir, err)
+		}
+		report.Deleted = append(report.Deleted, recording.Dir)
+		report.FreedBytes += recording.Bytes
+		report.UsedBytes -= recording.Bytes
+	}
+	return report, nil
+}
@@ -0,0 +1,92 @@
+package platform
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writeTestRecording(t *testing.T, root string, name string, createdAt time.Time) string {
+	t.Helper()
+
+	feeds := []FeedSpec{{FeedID: "alpha", URL: "http://alpha.test/rt", PollSeconds: 15}}
+	writer, err := CreateFeedRecording(feeds, RecordingHeaderOptions{
+		RecordingName: name,
+		RecordingPath: root,
+		CreatedAt:     createdAt,
+		Tool:          NewToolInfo("retention-test"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	frame := FeedFrame{FeedID: "alpha", CapturedAt: createdAt, Status: 200, Body: []byte("payload")}
+	if err := writer.Append(context.Background(), frame); err != nil {
+		t.Fatal(err)
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return writer.RootDir()
+}
+
+func recordingNames(recordings []RecordingInfo) []string {
+	names := make([]string, 0, len(recordings))
+	for _, recording := range recordings {
+		names = append(names, filepath.Base(recording.Dir))
+	}
+	return names
+}
+
+func TestListRecordingsSkipsUnreadableHeader(t *testing.T) {
+	root := t.TempDir()
+	start := time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+	writeTestRecording(t, root, "first", start)
+	broken := writeTestRecording(t, root, "broken", start.Add(time.Hour))
+	writeTestRecording(t, root, "second", start.Add(2*time.Hour))
+
+	if err := os.WriteFile(filepath.Join(broken, "recording.json"), []byte("{"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	recordings, err := ListRecordings(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := recordingNames(recordings); len(got) != 2 || got[0] != "first" || got[1] != "second" {
+		t.Errorf("recordings = %v, want [first second]", got)
+	}
+}
+
+func TestEnforceRecordingQuotaSweepsCompactions(t *testing.T) {
+	root := t.TempDir()
+	start := time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+	// Compacted, but the original was never removed
+	writeTestRecording(t, root, "compacted", start)
+	stale := writeTestRecording(t, root, ".compacted.old", start)
+	// Moved aside, but the copy was never put in its place
+	moved := writeTestRecording(t, root, ".moved.old", start.Add(time.Hour))
+	unfinished := writeTestRecording(t, root, ".moved.compact", start.Add(time.Hour))
+
+	report, err := EnforceRecordingQuota(context.Background(), root, RecordingQuota{MaxBytes: 1 << 30}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Deleted) != 0 || len(report.Compressed) != 0 {
+		t.Errorf("report = %+v, want nothing deleted or compressed", report)
+	}
+
+	for _, dir := range []string{stale, moved, unfinished} {
+		if _, err := os.Stat(dir); !os.IsNotExist(err) {
+			t.Errorf("%s still there: %v", filepath.Base(dir), err)
+		}
+	}
+	recordings, err := ListRecordings(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := recordingNames(recordings); len(got) != 2 || got[0] != "compacted" || got[1] != "moved" {
+		t.Errorf("recordings = %v, want [compacted moved]", got)
+	}
+}
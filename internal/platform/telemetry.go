@@ -1,7 +1,9 @@
 package platform
 
 import (
+	"errors"
 	"fmt"
+	"log"
 	"net"
 	"net/http"
 	"net/http/pprof"
@@ -10,6 +12,7 @@ import (
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/collectors"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	database "tarediiran-industries.com/gtfs-services/internal/db"
 )
 
 type Metrics struct {
@@ -17,17 +20,35 @@ type Metrics struct {
 	HttpReadBodySeconds *prometheus.HistogramVec
 	HttpBytesTotal      *prometheus.CounterVec
 	HttpErrorsTotal     *prometheus.CounterVec
+	HttpRequestsTotal   *prometheus.CounterVec
+
+	FeedPayloadBytes      *prometheus.HistogramVec
+	FeedUnchangedTotal    *prometheus.CounterVec
+	FeedDuplicatesTotal   *prometheus.CounterVec
+	FeedDecodeErrorsTotal *prometheus.CounterVec
+	FeedSnapshotsTotal    *prometheus.CounterVec
+	FeedEntities          *prometheus.GaugeVec
+	FeedStalenessSeconds  *prometheus.GaugeVec
+
+	CopyDurationSeconds *prometheus.HistogramVec
+	CopyRowsTotal       *prometheus.CounterVec
+
+	PipelineQueueDepth   *prometheus.GaugeVec
+	PipelineDroppedTotal *prometheus.CounterVec
+
+	registry *prometheus.Registry
 }
 
 func NewMetrics(registry *prometheus.Registry) *Metrics {
 	metrics := &Metrics{
+		registry: registry,
 		HttpTTFBSeconds: prometheus.NewHistogramVec(
 			prometheus.HistogramOpts{
 				Name:    "gtfs_http_ttfb_seconds",
 				Help:    "Time from API GET to first byte for HTTP requests",
 				Buckets: prometheus.DefBuckets,
 			},
-			[]string{"endpoint"},
+			[]string{"feed"},
 		),
 		HttpReadBodySeconds: prometheus.NewHistogramVec(
 			prometheus.HistogramOpts{
@@ -35,21 +56,107 @@ func NewMetrics(registry *prometheus.Registry) *Metrics {
 				Help:    "Time to read body of HTTP request from a GTFS-RT HTTP GET response",
 				Buckets: prometheus.DefBuckets,
 			},
-			[]string{"endpoint"},
+			[]string{"feed"},
 		),
 		HttpBytesTotal: prometheus.NewCounterVec(
 			prometheus.CounterOpts{
 				Name: "gtfs_http_bytes_total",
-				Help: "Bytes downloaded per endpoint",
+				Help: "Bytes downloaded per feed",
 			},
-			[]string{"endpoint"},
+			[]string{"feed"},
 		),
 		HttpErrorsTotal: prometheus.NewCounterVec(
 			prometheus.CounterOpts{
 				Name: "gtfs_http_errors_total",
 				Help: "Errors incurred from sustained interaction with an API endpoint",
 			},
-			[]string{"endpoint"},
+			[]string{"feed", "kind"},
+		),
+		HttpRequestsTotal: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "gtfs_http_requests_total",
+				Help: "Completed HTTP requests per feed by status code",
+			},
+			[]string{"feed", "code"},
+		),
+		FeedPayloadBytes: prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Name:    "gtfs_feed_payload_bytes",
+				Help:    "Size of GTFS-RT payloads received per feed",
+				Buckets: prometheus.ExponentialBuckets(1024, 2, 14),
+			},
+			[]string{"feed"},
+		),
+		FeedUnchangedTotal: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "gtfs_feed_unchanged_total",
+				Help: "Frames skipped because their payload hash matched the previous frame",
+			},
+			[]string{"feed"},
+		),
+		FeedDuplicatesTotal: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "gtfs_feed_duplicates_total",
+				Help: "Decoded snapshots skipped by the feed's dedup mode, by reason",
+			},
+			[]string{"feed", "reason"},
+		),
+		FeedDecodeErrorsTotal: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "gtfs_feed_decode_errors_total",
+				Help: "Payloads that failed to decode as a GTFS-RT FeedMessage",
+			},
+			[]string{"feed"},
+		),
+		FeedSnapshotsTotal: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "gtfs_feed_snapshots_total",
+				Help: "Snapshots written to the database per feed",
+			},
+			[]string{"feed"},
+		),
+		FeedEntities: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "gtfs_feed_entities",
+				Help: "Entities in the most recently ingested snapshot",
+			},
+			[]string{"feed"},
+		),
+		FeedStalenessSeconds: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "gtfs_feed_staleness_seconds",
+				Help: "Age of FeedHeader.timestamp when the snapshot was ingested",
+			},
+			[]string{"feed"},
+		),
+		CopyDurationSeconds: prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Name:    "gtfs_db_copy_duration_seconds",
+				Help:    "Time spent in COPY per feed and table",
+				Buckets: prometheus.DefBuckets,
+			},
+			[]string{"feed", "table"},
+		),
+		CopyRowsTotal: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "gtfs_db_copy_rows_total",
+				Help: "Rows written via COPY per feed and table",
+			},
+			[]string{"feed", "table"},
+		),
+		PipelineQueueDepth: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "gtfs_pipeline_queue_depth",
+				Help: "Items waiting in front of each ingest pipeline stage",
+			},
+			[]string{"feed", "stage"},
+		),
+		PipelineDroppedTotal: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "gtfs_pipeline_dropped_total",
+				Help: "Items dropped or coalesced away because a pipeline stage fell behind",
+			},
+			[]string{"feed", "stage"},
 		),
 	}
 
@@ -58,6 +165,69 @@ func NewMetrics(registry *prometheus.Registry) *Metrics {
 		metrics.HttpReadBodySeconds,
 		metrics.HttpBytesTotal,
 		metrics.HttpErrorsTotal,
+		metrics.HttpRequestsTotal,
+		metrics.FeedPayloadBytes,
+		metrics.FeedUnchangedTotal,
+		metrics.FeedDuplicatesTotal,
+		metrics.FeedDecodeErrorsTotal,
+		metrics.FeedSnapshotsTotal,
+		metrics.FeedEntities,
+		metrics.FeedStalenessSeconds,
+		metrics.CopyDurationSeconds,
+		metrics.CopyRowsTotal,
+		metrics.PipelineQueueDepth,
+		metrics.PipelineDroppedTotal,
+	)
+
+	return metrics
+}
+
+func (metrics *Metrics) RegisterDatabasePool(db *database.Database) {
+	RegisterDatabasePool(metrics.registry, db)
+}
+
+type DownloadMetrics struct {
+	DownloadsTotal          *prometheus.CounterVec
+	DownloadRetriesTotal    prometheus.Counter
+	DownloadBytesTotal      prometheus.Counter
+	DownloadDurationSeconds prometheus.Histogram
+}
+
+func NewDownloadMetrics(registry *prometheus.Registry) *DownloadMetrics {
+	metrics := &DownloadMetrics{
+		DownloadsTotal: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "gtfs_static_downloads_total",
+				Help: "Static feed downloads by outcome (downloaded, not_modified, failed)",
+			},
+			[]string{"result"},
+		),
+		DownloadRetriesTotal: prometheus.NewCounter(
+			prometheus.CounterOpts{
+				Name: "gtfs_static_download_retries_total",
+				Help: "Static feed download attempts that were retried",
+			},
+		),
+		DownloadBytesTotal: prometheus.NewCounter(
+			prometheus.CounterOpts{
+				Name: "gtfs_static_download_bytes_total",
+				Help: "Bytes received while downloading static feeds",
+			},
+		),
+		DownloadDurationSeconds: prometheus.NewHistogram(
+			prometheus.HistogramOpts{
+				Name:    "gtfs_static_download_duration_seconds",
+				Help:    "Wall time to download a static feed, including retries",
+				Buckets: prometheus.ExponentialBuckets(0.5, 2, 12),
+			},
+		),
+	}
+
+	registry.MustRegister(
+		metrics.DownloadsTotal,
+		metrics.DownloadRetriesTotal,
+		metrics.DownloadBytesTotal,
+		metrics.DownloadDurationSeconds,
 	)
 
 	return metrics
@@ -140,3 +310,78 @@ func (telemetry TelemetryServer) Stop() error {
 
 	return telemetry.server.Close()
 }
+
+// databasePoolCollector reads the pgx pool's counters at scrape time
+type databasePoolCollector struct {
+	db *database.Database
+
+	acquiredConns     *prometheus.Desc
+	idleConns         *prometheus.Desc
+	constructingConns *prometheus.Desc
+	totalConns        *prometheus.Desc
+	maxConns          *prometheus.Desc
+	acquiresTotal     *prometheus.Desc
+	acquireSeconds    *prometheus.Desc
+	emptyAcquires     *prometheus.Desc
+	canceledAcquires  *prometheus.Desc
+}
+
+func NewDatabasePoolCollector(db *database.Database) prometheus.Collector {
+	desc := func(name string, help string) *prometheus.Desc {
+		return prometheus.NewDesc("gtfs_db_pool_"+name, help, nil, nil)
+	}
+	return &databasePoolCollector{
+		db:                db,
+		acquiredConns:     desc("acquired_conns", "Connections currently checked out of the pool"),
+		idleConns:         desc("idle_conns", "Idle connections in the pool"),
+		constructingConns: desc("constructing_conns", "Connections being opened"),
+		totalConns:        desc("total_conns", "Connections in the pool, acquired, idle or being opened"),
+		maxConns:          desc("max_conns", "Configured maximum size of the pool"),
+		acquiresTotal:     desc("acquires_total", "Successful connection acquires"),
+		acquireSeconds:    desc("acquire_seconds_total", "Time spent waiting to acquire connections"),
+		emptyAcquires:     desc("empty_acquires_total", "Acquires that had to wait because no connection was idle"),
+		canceledAcquires:  desc("canceled_acquires_total", "Acquires canceled by their context"),
+	}
+}
+
+func (collector *databasePoolCollector) Describe(descs chan<- *prometheus.Desc) {
+	descs <- collector.acquiredConns
+	descs <- collector.idleConns
+	descs <- collector.constructingConns
+	descs <- collector.totalConns
+	descs <- collector.maxConns
+	descs <- collector.acquiresTotal
+	descs <- collector.acquireSeconds
+	descs <- collector.emptyAcquires
+	descs <- collector.canceledAcquires
+}
+
+func (collector *databasePoolCollector) Collect(metrics chan<- prometheus.Metric) {
+	stat := collector.db.Stat()
+	gauge := func(desc *prometheus.Desc, value float64) {
+		metrics <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, value)
+	}
+	counter := func(desc *prometheus.Desc, value float64) {
+		metrics <- prometheus.MustNewConstMetric(desc, prometheus.CounterValue, value)
+	}
+
+	gauge(collector.acquiredConns, float64(stat.AcquiredConns()))
+	gauge(collector.idleConns, float64(stat.IdleConns()))
+	gauge(collector.constructingConns, float64(stat.ConstructingConns()))
+	gauge(collector.totalConns, float64(stat.TotalConns()))
+	gauge(collector.maxConns, float64(stat.MaxConns()))
+	counter(collector.acquiresTotal, float64(stat.AcquireCount()))
+	counter(collector.acquireSeconds, stat.AcquireDuration().Seconds())
+	counter(collector.emptyAcquires, float64(stat.EmptyAcquireCount()))
+	counter(collector.canceledAcquires, float64(stat.CanceledAcquireCount()))
+}
+
+// RegisterDatabasePool exports db's pool statistics on registry. A process only has one pool per
+// registry, so a second registration is ignored.
+func RegisterDatabasePool(registry prometheus.Registerer, db *database.Database) {
+	err := registry.Register(NewDatabasePoolCollector(db))
+	var alreadyRegistered prometheus.AlreadyRegisteredError
+	if err != nil && !errors.As(err, &alreadyRegistered) {
+		log.Printf("register database pool metrics: %v", err)
+	}
+}
@@ -0,0 +1,117 @@
+package probe
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/MobilityData/gtfs-realtime-bindings/golang/gtfs"
+	"google.golang.org/protobuf/proto"
+	"tarediiran-industries.com/gtfs-services/internal/platform"
+)
+
+// Target is where a single payload is read from, exactly one of URL and Path is set
+type Target struct {
+	FeedID string
+	URL    string
+	Path   string
+	Auth   *platform.RequestAuth
+}
+
+func (target Target) String() string {
+	if target.Path != "" {
+		return target.Path
+	}
+	if target.FeedID != "" {
+		return fmt.Sprintf("%s (%s)", target.FeedID, target.URL)
+	}
+	return target.URL
+}
+
+// Resolve reads arg as a feed id from config, then as an http(s) URL, then as a file path
+func Resolve(config *platform.SingleConfig, arg string) (Target, error) {
+	for _, feed := range config.Feed.RealTime {
+		if feed.ID != arg {
+			continue
+		}
+		auth, err := config.ResolveAuth(feed.Auth)
+		if err != nil {
+			return Target{}, fmt.Errorf("feed %s: %w", feed.ID, err)
+		}
+		return Target{FeedID: feed.ID, URL: feed.URL, Auth: auth}, nil
+	}
+
+	if strings.HasPrefix(arg, "http://") || strings.HasPrefix(arg, "https://") {
+		return Target{URL: arg}, nil
+	}
+
+	if _, err := os.Stat(arg); err != nil {
+		return Target{}, fmt.Errorf("%q is not a configured feed id, a URL or a readable file: %w", arg, err)
+	}
+	return Target{Path: arg}, nil
+}
+
+// Result is one payload and how it was obtained
+type Result struct {
+	Target    Target
+	FetchedAt time.Time
+	Duration  time.Duration
+	Status    int
+	Headers   platform.FrameHeaders
+	Body      []byte
+	Message   *gtfs.FeedMessage
+}
+
+// Load fetches or reads target's payload and decodes it. A response that isn't a 2xx is an error.
+func Load(ctx context.Context, client *http.Client, target Target) (Result, error) {
+	result := Result{Target: target, FetchedAt: time.Now()}
+
+	if target.Path != "" {
+		body, err := os.ReadFile(target.Path)
+		if err != nil {
+			return result, err
+		}
+		result.Body = body
+	} else {
+		req, err := http.NewRequestWithContext(ctx, "GET", target.URL, nil)
+		if err != nil {
+			return result, err
+		}
+		target.Auth.Apply(req)
+
+		resp, err := client.Do(req)
+		if err != nil {
+			return result, err
+		}
+		defer resp.Body.Close()
+
+		result.Status = resp.StatusCode
+		result.Headers = platform.FrameHeaders{
+			ETag:         resp.Header.Get("ETag"),
+			LastModified: resp.Header.Get("Last-Modified"),
+			ContentType:  resp.Header.Get("Content-Type"),
+		}
+		if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+			result.Duration = time.Since(result.FetchedAt)
+			return result, fmt.Errorf("%s: HTTP status %d", target, resp.StatusCode)
+		}
+
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return result, fmt.Errorf("read body: %w", err)
+		}
+		result.Body = body
+	}
+	result.Duration = time.Since(result.FetchedAt)
+
+	message := &gtfs.FeedMessage{}
+	if err := proto.Unmarshal(result.Body, message); err != nil {
+		return result, fmt.Errorf("decode %s: %w", target, err)
+	}
+	result.Message = message
+	return result, nil
+}
@@ -0,0 +1,194 @@
+package probe
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"time"
+
+	"github.com/MobilityData/gtfs-realtime-bindings/golang/gtfs"
+)
+
+type RouteSummary struct {
+	RouteID         string
+	Trips           int
+	StopTimeUpdates int
+	Vehicles        int
+}
+
+type TripSummary struct {
+	TripID    string
+	RouteID   string
+	StartDate string
+	StartTime string
+	Stops     int
+	// First predicted stop still to come, empty when every prediction is in the past
+	NextStopID  string
+	NextArrival time.Time
+}
+
+type Summary struct {
+	Version         string
+	Incrementality  string
+	HeaderTimestamp time.Time
+	// How long before now the feed claims to have been generated
+	Age time.Duration
+
+	Entities        int
+	TripUpdates     int
+	Vehicles        int
+	Alerts          int
+	StopTimeUpdates int
+
+	Routes []RouteSummary
+	Trips  []TripSummary
+}
+
+func stopTime(event *gtfs.TripUpdate_StopTimeEvent) time.Time {
+	if event.GetTime() == 0 {
+		return time.Time{}
+	}
+	return time.Unix(event.GetTime(), 0)
+}
+
+func Summarize(message *gtfs.FeedMessage, now time.Time) Summary {
+	header := message.GetHeader()
+	summary := Summary{
+		Version:        header.GetGtfsRealtimeVersion(),
+		Incrementality: header.GetIncrementality().String(),
+		Entities:       len(message.GetEntity()),
+	}
+	if header.GetTimestamp() != 0 {
+		summary.HeaderTimestamp = time.Unix(int64(header.GetTimestamp()), 0)
+		summary.Age = now.Sub(summary.HeaderTimestamp)
+	}
+
+	routes := make(map[string]*RouteSummary)
+	route := func(routeId string) *RouteSummary {
+		if _, ok := routes[routeId]; !ok {
+			routes[routeId] = &RouteSummary{RouteID: routeId}
+		}
+		return routes[routeId]
+	}
+
+	for _, entity := range message.GetEntity() {
+		if tripUpdate := entity.GetTripUpdate(); tripUpdate != nil {
+			trip := tripUpdate.GetTrip()
+			stopTimes := tripUpdate.GetStopTimeUpdate()
+			summary.TripUpdates++
+			summary.StopTimeUpdates += len(stopTimes)
+
+			routeSummary := route(trip.GetRouteId())
+			routeSummary.Trips++
+			routeSummary.StopTimeUpdates += len(stopTimes)
+
+			tripSummary := TripSummary{
+				TripID:    trip.GetTripId(),
+				RouteID:   trip.GetRouteId(),
+				StartDate: trip.GetStartDate(),
+				StartTime: trip.GetStartTime(),
+				Stops:     len(stopTimes),
+			}
+			for _, update := range stopTimes {
+				at := stopTime(update.GetArrival())
+				if at.IsZero() {
+					at = stopTime(update.GetDeparture())
+				}
+				if !at.IsZero() && !at.Before(now) {
+					tripSummary.NextStopID = update.GetStopId()
+					tripSummary.NextArrival = at
+					break
+				}
+			}
+			summary.Trips = append(summary.Trips, tripSummary)
+		}
+		if vehicle := entity.GetVehicle(); vehicle != nil {
+			summary.Vehicles++
+			route(vehicle.GetTrip().GetRouteId()).Vehicles++
+		}
+		if entity.GetAlert() != nil {
+			summary.Alerts++
+		}
+	}
+
+	for _, routeSummary := range routes {
+		summary.Routes = append(summary.Routes, *routeSummary)
+	}
+	sort.Slice(summary.Routes, func(i, j int) bool {
+		return summary.Routes[i].RouteID < summary.Routes[j].RouteID
+	})
+	sort.SliceStable(summary.Trips, func(i, j int) bool {
+		if summary.Trips[i].RouteID != summary.Trips[j].RouteID {
+			return summary.Trips[i].RouteID < summary.Trips[j].RouteID
+		}
+		// Trips with nothing left to predict go after the ones still running
+		left, right := summary.Trips[i].NextArrival, summary.Trips[j].NextArrival
+		if left.IsZero() || right.IsZero() {
+			return !left.IsZero()
+		}
+		return left.Before(right)
+	})
+
+	return summary
+}
+
+func formatClock(at time.Time) string {
+	if at.IsZero() {
+		return "-"
+	}
+	return at.Local().Format("15:04:05")
+}
+
+// WriteSummary prints result and its summary as tables, every trip as well when withTrips is set
+func WriteSummary(out io.Writer, result Result, summary Summary, withTrips bool) {
+	fmt.Fprintf(out, "source:     %s\n", result.Target)
+	if result.Status != 0 {
+		fmt.Fprintf(out, "response:   %d in %s, %d bytes", result.Status, result.Duration.Round(time.Millisecond), len(result.Body))
+		if result.Headers.ETag != "" {
+			fmt.Fprintf(out, ", etag %s", result.Headers.ETag)
+		}
+		if result.Headers.LastModified != "" {
+			fmt.Fprintf(out, ", last modified %s", result.Headers.LastModified)
+		}
+		fmt.Fprintln(out)
+	} else {
+		fmt.Fprintf(out, "size:       %d bytes\n", len(result.Body))
+	}
+	fmt.Fprintf(out, "version:    %s (%s)\n", summary.Version, summary.Incrementality)
+	if summary.HeaderTimestamp.IsZero() {
+		fmt.Fprintf(out, "timestamp:  none\n")
+	} else {
+		fmt.Fprintf(
+			out, "timestamp:  %s (%s old)\n",
+			summary.HeaderTimestamp.Local().Format(time.RFC3339), summary.Age.Round(time.Second),
+		)
+	}
+	fmt.Fprintf(
+		out, "entities:   %d (%d trip updates, %d vehicles, %d alerts), %d stop time updates\n\n",
+		summary.Entities, summary.TripUpdates, summary.Vehicles, summary.Alerts, summary.StopTimeUpdates,
+	)
+
+	fmt.Fprintf(out, "%-8s %6s %8s %9s\n", "ROUTE", "TRIPS", "STOPS", "VEHICLES")
+	for _, route := range summary.Routes {
+		routeId := route.RouteID
+		if routeId == "" {
+			routeId = "(none)"
+		}
+		fmt.Fprintf(out, "%-8s %6d %8d %9d\n", routeId, route.Trips, route.StopTimeUpdates, route.Vehicles)
+	}
+
+	if !withTrips {
+		return
+	}
+	fmt.Fprintf(out, "\n%-6s %-36s %-9s %-9s %6s %-8s %s\n", "ROUTE", "TRIP", "DATE", "START", "STOPS", "NEXT", "AT")
+	for _, trip := range summary.Trips {
+		nextStop := trip.NextStopID
+		if nextStop == "" {
+			nextStop = "-"
+		}
+		fmt.Fprintf(
+			out, "%-6s %-36s %-9s %-9s %6d %-8s %s\n",
+			trip.RouteID, trip.TripID, trip.StartDate, trip.StartTime, trip.Stops, nextStop, formatClock(trip.NextArrival),
+		)
+	}
+}
@@ -0,0 +1,114 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// Alert is a row of service_alerts. A zero ActiveStart or ActiveEnd is open-ended.
+type Alert struct {
+	FeedID          string
+	ID              string
+	ActiveStart     time.Time
+	ActiveEnd       time.Time
+	Cause           string
+	Effect          string
+	HeaderText      string
+	DescriptionText string
+	RouteIDs        []string
+	StopIDs         []string
+	SnapshotID      int64
+	UpdatedAt       time.Time
+}
+
+func nullTime(value time.Time) sql.NullTime {
+	return sql.NullTime{Time: value, Valid: !value.IsZero()}
+}
+
+// ReplaceAlerts makes alerts the full set of alerts stored for feedID
+func (repo *Repository) ReplaceAlerts(
+	ctx context.Context, feedID string, snapshotId int64, updatedAt time.Time, alerts []Alert,
+) error {
+	alertIds := make([]string, len(alerts))
+	for i, alert := range alerts {
+		alertIds[i] = alert.ID
+
+		routeIds, stopIds := alert.RouteIDs, alert.StopIDs
+		if routeIds == nil {
+			routeIds = []string{}
+		}
+		if stopIds == nil {
+			stopIds = []string{}
+		}
+
+		_, err := repo.exec(
+			ctx,
+			`INSERT INTO service_alerts
+				(feed_id, alert_id, active_start, active_end, cause, effect, header_text, description_text,
+				route_ids, stop_ids, snapshot_id, updated_at)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)
+			ON CONFLICT (feed_id, alert_id) DO UPDATE SET
+				active_start = EXCLUDED.active_start,
+				active_end = EXCLUDED.active_end,
+				cause = EXCLUDED.cause,
+				effect = EXCLUDED.effect,
+				header_text = EXCLUDED.header_text,
+				description_text = EXCLUDED.description_text,
+				route_ids = EXCLUDED.route_ids,
+				stop_ids = EXCLUDED.stop_ids,
+				snapshot_id = EXCLUDED.snapshot_id,
+				updated_at = EXCLUDED.updated_at`,
+			feedID, alert.ID, nullTime(alert.ActiveStart), nullTime(alert.ActiveEnd),
+			alert.Cause, alert.Effect, alert.HeaderText, alert.DescriptionText,
+			routeIds, stopIds, snapshotId, updatedAt,
+		)
+		if err != nil {
+			return fmt.Errorf("upsert alert %s: %w", alert.ID, err)
+		}
+	}
+
+	_, err := repo.exec(
+		ctx,
+		"DELETE FROM service_alerts WHERE feed_id = $1 AND alert_id <> ALL($2::text[])",
+		feedID, alertIds,
+	)
+	if err != nil {
+		return fmt.Errorf("delete expired alerts for %s: %w", feedID, err)
+	}
+	return nil
+}
+
+// ActiveAlerts lists alerts in effect at now, only those affecting routeID unless it is empty
+func (repo *Repository) ActiveAlerts(ctx context.Context, routeID string, now time.Time) ([]Alert, error) {
+	alerts, err := queryRows(
+		ctx, repo,
+		`SELECT
+			feed_id, alert_id, active_start, active_end,
+			COALESCE(cause, ''), COALESCE(effect, ''), COALESCE(header_text, ''), COALESCE(description_text, ''),
+			route_ids, stop_ids, snapshot_id, updated_at
+		FROM service_alerts
+		WHERE (active_start IS NULL OR active_start <= $2)
+			AND (active_end IS NULL OR active_end > $2)
+			AND ($1::text = '' OR $1 = ANY(route_ids))
+		ORDER BY active_start NULLS FIRST, feed_id, alert_id`,
+		func(row scanner) (Alert, error) {
+			alert := Alert{}
+			var activeStart, activeEnd sql.NullTime
+			err := row.Scan(
+				&alert.FeedID, &alert.ID, &activeStart, &activeEnd,
+				&alert.Cause, &alert.Effect, &alert.HeaderText, &alert.DescriptionText,
+				&alert.RouteIDs, &alert.StopIDs, &alert.SnapshotID, &alert.UpdatedAt,
+			)
+			alert.ActiveStart = activeStart.Time
+			alert.ActiveEnd = activeEnd.Time
+			return alert, err
+		},
+		routeID, now,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("list active alerts: %w", err)
+	}
+	return alerts, nil
+}
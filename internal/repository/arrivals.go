@@ -0,0 +1,85 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// Arrival is one stop time currently predicted for a trip in a realtime feed. A zero ArrivalTime
+// or DepartureTime means the feed did not predict it.
+type Arrival struct {
+	FeedID        string
+	TripID        string
+	StartDate     string
+	DirectionID   int16
+	RouteID       string
+	Headsign      string
+	StopID        string
+	StopName      string
+	ArrivalTime   time.Time
+	DepartureTime time.Time
+	ChangedAt     time.Time
+}
+
+// When the train is at the stop, departure if the feed gave no arrival
+func (arrival Arrival) At() time.Time {
+	if arrival.ArrivalTime.IsZero() {
+		return arrival.DepartureTime
+	}
+	return arrival.ArrivalTime
+}
+
+const arrivalSelect = `SELECT
+	cur.feed_id, cur.trip_id, cur.start_date, COALESCE(cur.direction_id, 0),
+	COALESCE(trip.route_id, ''), COALESCE(trip.trip_headsign, ''),
+	cur.stop_id, COALESCE(stops.stop_name, ''), cur.arrival_time, cur.departure_time, cur.changed_at
+FROM view_trip_stop_times_current cur
+LEFT JOIN LATERAL (
+	SELECT route_id, trip_headsign FROM trips WHERE trips.rt_trip_id = cur.trip_id LIMIT 1
+) trip ON TRUE
+LEFT JOIN stops ON stops.stop_id = cur.stop_id
+`
+
+func scanArrival(row scanner) (Arrival, error) {
+	arrival := Arrival{}
+	err := row.Scan(
+		&arrival.FeedID, &arrival.TripID, &arrival.StartDate, &arrival.DirectionID,
+		&arrival.RouteID, &arrival.Headsign,
+		&arrival.StopID, &arrival.StopName,
+		unixTime{&arrival.ArrivalTime}, unixTime{&arrival.DepartureTime}, &arrival.ChangedAt,
+	)
+	return arrival, err
+}
+
+// UpcomingArrivals lists the next limit trains predicted at stopID, or at any of its platforms when
+// it is a parent station, from after onwards
+func (repo *Repository) UpcomingArrivals(ctx context.Context, stopID string, after time.Time, limit int) ([]Arrival, error) {
+	arrivals, err := queryRows(
+		ctx, repo,
+		arrivalSelect+`WHERE (cur.stop_id = $1 OR stops.parent_station = $1)
+			AND GREATEST(cur.arrival_time, cur.departure_time) >= $2
+		ORDER BY GREATEST(cur.arrival_time, cur.departure_time)
+		LIMIT $3`,
+		scanArrival, stopID, after.Unix(), limit,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("upcoming arrivals at %s: %w", stopID, err)
+	}
+	return arrivals, nil
+}
+
+// TripArrivals lists every stop currently predicted for a realtime trip id, in the order it will
+// reach them
+func (repo *Repository) TripArrivals(ctx context.Context, tripID string) ([]Arrival, error) {
+	arrivals, err := queryRows(
+		ctx, repo,
+		arrivalSelect+`WHERE cur.trip_id = $1
+		ORDER BY cur.start_date, GREATEST(cur.arrival_time, cur.departure_time)`,
+		scanArrival, tripID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("arrivals for trip %s: %w", tripID, err)
+	}
+	return arrivals, nil
+}
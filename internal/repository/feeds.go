@@ -0,0 +1,61 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// FeedVersion is one imported static GTFS archive
+type FeedVersion struct {
+	ID           int64
+	ImportedAt   time.Time
+	SourceURL    string
+	SourceSHA256 string
+}
+
+const feedVersionColumns = "feed_id, imported_at, source_url, source_sha256"
+
+func scanFeedVersion(row scanner) (FeedVersion, error) {
+	feed := FeedVersion{}
+	err := row.Scan(&feed.ID, &feed.ImportedAt, nullString{&feed.SourceURL}, nullString{&feed.SourceSHA256})
+	return feed, err
+}
+
+func (repo *Repository) CreateFeedVersion(ctx context.Context, sourceURL string, sourceSHA256 string) (FeedVersion, error) {
+	feed, _, err := queryRow(
+		ctx, repo,
+		`INSERT INTO feed_version (imported_at, source_url, source_sha256)
+		VALUES (NOW(), $1, $2)
+		RETURNING `+feedVersionColumns,
+		scanFeedVersion, sourceURL, sourceSHA256,
+	)
+	if err != nil {
+		return feed, fmt.Errorf("create feed version: %w", err)
+	}
+	return feed, nil
+}
+
+func (repo *Repository) FeedVersionBySHA256(ctx context.Context, sourceSHA256 string) (FeedVersion, bool, error) {
+	feed, found, err := queryRow(
+		ctx, repo,
+		"SELECT "+feedVersionColumns+" FROM feed_version WHERE source_sha256 = $1 ORDER BY feed_id DESC LIMIT 1",
+		scanFeedVersion, sourceSHA256,
+	)
+	if err != nil {
+		return feed, false, fmt.Errorf("look up feed version: %w", err)
+	}
+	return feed, found, nil
+}
+
+func (repo *Repository) LatestFeedVersion(ctx context.Context) (FeedVersion, bool, error) {
+	feed, found, err := queryRow(
+		ctx, repo,
+		"SELECT "+feedVersionColumns+" FROM feed_version ORDER BY feed_id DESC LIMIT 1",
+		scanFeedVersion,
+	)
+	if err != nil {
+		return feed, false, fmt.Errorf("latest feed version: %w", err)
+	}
+	return feed, found, nil
+}
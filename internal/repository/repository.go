@@ -0,0 +1,129 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	database "tarediiran-industries.com/gtfs-services/internal/db"
+)
+
+// Repository is the one place SQL against the GTFS schema lives. pgx prepares each statement the
+// first time a connection runs it and reuses it from its statement cache after that.
+//
+// It works over anything implementing db.DBTX, so a *db.Database, a *db.Tx when several writes
+// must land together, or a pgx connection opened against a local Postgres when exercising queries
+// by hand.
+type Repository struct {
+	db database.DBTX
+}
+
+func New(db database.DBTX) *Repository {
+	return &Repository{db: db}
+}
+
+func (repo *Repository) exec(ctx context.Context, query string, args ...any) (int64, error) {
+	tag, err := repo.db.Exec(ctx, query, args...)
+	if err != nil {
+		return 0, err
+	}
+	return tag.RowsAffected(), nil
+}
+
+// scanner is satisfied by both pgx.Row and pgx.Rows
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+func queryRows[T any](
+	ctx context.Context, repo *Repository, query string, scan func(scanner) (T, error), args ...any,
+) ([]T, error) {
+	rows, err := repo.db.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := make([]T, 0)
+	for rows.Next() {
+		value, err := scan(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, value)
+	}
+	return out, rows.Err()
+}
+
+// queryRow returns false instead of an error when the query finds nothing
+func queryRow[T any](
+	ctx context.Context, repo *Repository, query string, scan func(scanner) (T, error), args ...any,
+) (T, bool, error) {
+	var zero T
+	value, err := scan(repo.db.QueryRow(ctx, query, args...))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return zero, false, nil
+	}
+	if err != nil {
+		return zero, false, err
+	}
+	return value, true, nil
+}
+
+// hashValue scans a BYTEA column into a fixed size content hash
+type hashValue struct {
+	dest *[32]byte
+}
+
+func (value hashValue) Scan(src any) error {
+	raw, ok := src.([]byte)
+	if !ok {
+		return fmt.Errorf("content hash: unexpected %T", src)
+	}
+	if len(raw) != len(value.dest) {
+		return fmt.Errorf("content hash: expected %d bytes, got %d", len(value.dest), len(raw))
+	}
+	copy(value.dest[:], raw)
+	return nil
+}
+
+// nullString maps SQL NULL to the empty string
+type nullString struct {
+	dest *string
+}
+
+func (value nullString) Scan(src any) error {
+	var scanned sql.NullString
+	if err := scanned.Scan(src); err != nil {
+		return err
+	}
+	*value.dest = scanned.String
+	return nil
+}
+
+// unixTime scans a BIGINT of Unix seconds, where zero means no time was given
+type unixTime struct {
+	dest *time.Time
+}
+
+func (value unixTime) Scan(src any) error {
+	var seconds sql.NullInt64
+	if err := seconds.Scan(src); err != nil {
+		return err
+	}
+	if !seconds.Valid || seconds.Int64 == 0 {
+		*value.dest = time.Time{}
+		return nil
+	}
+	*value.dest = time.Unix(seconds.Int64, 0)
+	return nil
+}
+
+func scanInt64(row scanner) (int64, error) {
+	var value int64
+	err := row.Scan(&value)
+	return value, err
+}
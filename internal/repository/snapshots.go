@@ -0,0 +1,66 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+func (repo *Repository) InsertSnapshot(ctx context.Context, feedID string, fetchedAt time.Time) (int64, error) {
+	snapshotId, _, err := queryRow(
+		ctx, repo,
+		"INSERT INTO feed_snapshots (feed_id, fetched_at) VALUES ($1, $2) RETURNING snapshot_id",
+		scanInt64, feedID, fetchedAt,
+	)
+	return snapshotId, err
+}
+
+// SeenSnapshot is one entry in a feed's persisted dedup seen-set
+type SeenSnapshot struct {
+	FeedID          string
+	ContentHash     [32]byte
+	HeaderTimestamp int64
+	SeenAt          time.Time
+}
+
+// RecentSeenSnapshots returns up to limit entries of the seen-set, newest first
+func (repo *Repository) RecentSeenSnapshots(ctx context.Context, feedID string, limit int) ([]SeenSnapshot, error) {
+	seen, err := queryRows(
+		ctx, repo,
+		`SELECT feed_id, content_hash, COALESCE(header_timestamp, 0), seen_at FROM feed_snapshot_seen
+		WHERE feed_id = $1 ORDER BY seen_at DESC LIMIT $2`,
+		func(row scanner) (SeenSnapshot, error) {
+			snapshot := SeenSnapshot{}
+			err := row.Scan(&snapshot.FeedID, hashValue{&snapshot.ContentHash}, &snapshot.HeaderTimestamp, &snapshot.SeenAt)
+			return snapshot, err
+		},
+		feedID, limit,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("load dedup seen-set for %s: %w", feedID, err)
+	}
+	return seen, nil
+}
+
+func (repo *Repository) LatestSeenHeaderTimestamp(ctx context.Context, feedID string) (int64, error) {
+	timestamp, _, err := queryRow(
+		ctx, repo,
+		"SELECT COALESCE(MAX(header_timestamp), 0) FROM feed_snapshot_seen WHERE feed_id = $1",
+		scanInt64, feedID,
+	)
+	if err != nil {
+		return 0, fmt.Errorf("load dedup header timestamp for %s: %w", feedID, err)
+	}
+	return timestamp, nil
+}
+
+func (repo *Repository) RecordSeenSnapshot(ctx context.Context, snapshot SeenSnapshot) error {
+	_, err := repo.exec(
+		ctx,
+		`INSERT INTO feed_snapshot_seen (feed_id, content_hash, header_timestamp, seen_at)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (feed_id, content_hash) DO UPDATE SET seen_at = EXCLUDED.seen_at`,
+		snapshot.FeedID, snapshot.ContentHash[:], snapshot.HeaderTimestamp, snapshot.SeenAt,
+	)
+	return err
+}
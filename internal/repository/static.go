@@ -0,0 +1,182 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	database "tarediiran-industries.com/gtfs-services/internal/db"
+)
+
+// Columns each static GTFS table accepts, see migrations/001_initial.up.sql. Anything else in a
+// file is dropped, so table and column names never come from the archive being loaded.
+var staticTableColumns = map[string][]string{
+	"agency":         {"agency_id", "agency_name", "agency_url", "agency_timezone", "agency_lang", "agency_phone"},
+	"routes":         {"route_id", "agency_id", "route_short_name", "route_long_name", "route_desc", "route_type", "route_url", "route_color", "route_text_color", "route_sort_order"},
+	"trips":          {"trip_id", "route_id", "service_id", "trip_headsign", "direction_id", "shape_id"},
+	"stops":          {"stop_id", "stop_name", "stop_lat", "stop_lon", "location_type", "parent_station"},
+	"stop_times":     {"stop_id", "trip_id", "arrival_time", "departure_time", "stop_sequence"},
+	"calendar":       {"service_id", "monday", "tuesday", "wednesday", "thursday", "friday", "saturday", "sunday", "start_date", "end_date"},
+	"calendar_dates": {"service_id", "date", "exception_type"},
+	"shapes":         {"shape_id", "shape_pt_lat", "shape_pt_lon", "shape_pt_sequence", "shape_dist_traveled"},
+	"transfers":      {"from_stop_id", "to_stop_id", "transfer_type", "min_transfer_time"},
+}
+
+// staticProjection picks the header fields table knows about, returning their positions in the
+// CSV and the matching column names with feed_id appended
+func staticProjection(table string, header []string) ([]int, []string, error) {
+	known, ok := staticTableColumns[table]
+	if !ok {
+		return nil, nil, fmt.Errorf("unknown static table %q", table)
+	}
+
+	positions := make([]int, 0, len(header))
+	columns := make([]string, 0, len(header)+1)
+	for i, name := range header {
+		name = strings.TrimSpace(strings.TrimPrefix(name, "\ufeff"))
+		for _, column := range known {
+			if name == column {
+				positions = append(positions, i)
+				columns = append(columns, column)
+				break
+			}
+		}
+	}
+	if len(columns) == 0 {
+		return nil, nil, fmt.Errorf("%s: no known columns in header %v", table, header)
+	}
+	return positions, append(columns, "feed_id"), nil
+}
+
+// StaticRowReader hands out the rows of a static GTFS file after its header one at a time, and
+// io.EOF after the last. A *csv.Reader is one.
+type StaticRowReader interface {
+	Read() ([]string, error)
+}
+
+// InsertStaticRows inserts rows one statement at a time, for the small tables where COPY is not
+// worth it. Every row shares one cached statement. Empty fields are stored as NULL.
+func (repo *Repository) InsertStaticRows(
+	ctx context.Context, table string, feedId int64, header []string, rows StaticRowReader,
+) (int64, error) {
+	positions, columns, err := staticProjection(table, header)
+	if err != nil {
+		return 0, err
+	}
+
+	placeholders := make([]string, len(columns))
+	for i := range columns {
+		placeholders[i] = "$" + strconv.Itoa(i+1)
+	}
+	query := fmt.Sprintf(
+		"INSERT INTO %s (%s) VALUES (%s)",
+		table, strings.Join(columns, ", "), strings.Join(placeholders, ", "),
+	)
+
+	var inserted int64
+	values := make([]any, len(columns))
+	for {
+		row, err := rows.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return inserted, fmt.Errorf("read %s: %w", table, err)
+		}
+		if len(row) != len(header) {
+			return inserted, fmt.Errorf("%s: row has %d fields, header has %d", table, len(row), len(header))
+		}
+		for i, position := range positions {
+			if row[position] == "" {
+				values[i] = nil
+			} else {
+				values[i] = row[position]
+			}
+		}
+		values[len(values)-1] = feedId
+
+		count, err := repo.exec(ctx, query, values...)
+		if err != nil {
+			return inserted, fmt.Errorf("insert into %s: %w", table, err)
+		}
+		inserted += count
+	}
+	return inserted, nil
+}
+
+// staticRowSource feeds rows to COPY as they are read, projected onto the table's columns with
+// feed_id last. Empty fields are stored as NULL, as InsertStaticRows does.
+type staticRowSource struct {
+	table     string
+	header    []string
+	positions []int
+	rows      StaticRowReader
+	values    []any
+	err       error
+}
+
+func (source *staticRowSource) Next() bool {
+	row, err := source.rows.Read()
+	if err == io.EOF {
+		return false
+	}
+	if err != nil {
+		source.err = fmt.Errorf("read %s: %w", source.table, err)
+		return false
+	}
+	if len(row) != len(source.header) {
+		source.err = fmt.Errorf("%s: row has %d fields, header has %d", source.table, len(row), len(source.header))
+		return false
+	}
+	for i, position := range source.positions {
+		if row[position] == "" {
+			source.values[i] = nil
+		} else {
+			source.values[i] = row[position]
+		}
+	}
+	return true
+}
+
+func (source *staticRowSource) Values() ([]any, error) {
+	return source.values, nil
+}
+
+func (source *staticRowSource) Err() error {
+	return source.err
+}
+
+// CopyStaticRows bulk loads rows with COPY, streaming them straight from rows. It needs the
+// repository to sit on a connection that implements db.CopyCapable.
+func (repo *Repository) CopyStaticRows(
+	ctx context.Context, table string, feedId int64, header []string, rows StaticRowReader,
+) (int64, error) {
+	copier, ok := repo.db.(database.CopyCapable)
+	if !ok {
+		return 0, fmt.Errorf("copy into %s: connection does not support COPY", table)
+	}
+	positions, columns, err := staticProjection(table, header)
+	if err != nil {
+		return 0, err
+	}
+
+	source := &staticRowSource{
+		table:     table,
+		header:    header,
+		positions: positions,
+		rows:      rows,
+		values:    make([]any, len(columns)),
+	}
+	source.values[len(columns)-1] = feedId
+
+	copied, err := copier.CopyFromSource(ctx, table, columns, source)
+	if source.err != nil {
+		return 0, source.err
+	}
+	if err != nil {
+		return 0, fmt.Errorf("copy into %s: %w", table, err)
+	}
+	return copied, nil
+}
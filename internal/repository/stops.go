@@ -0,0 +1,50 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+)
+
+// Stop is a row of the static stops table
+type Stop struct {
+	ID            string
+	Name          string
+	Lat           float64
+	Lon           float64
+	LocationType  int16
+	ParentStation string
+}
+
+const stopColumns = `stop_id, COALESCE(stop_name, ''), COALESCE(stop_lat, 0), COALESCE(stop_lon, 0),
+	COALESCE(location_type, 0), COALESCE(parent_station, '')`
+
+func scanStop(row scanner) (Stop, error) {
+	stop := Stop{}
+	err := row.Scan(&stop.ID, &stop.Name, &stop.Lat, &stop.Lon, &stop.LocationType, &stop.ParentStation)
+	return stop, err
+}
+
+func (repo *Repository) Stop(ctx context.Context, stopID string) (Stop, bool, error) {
+	stop, found, err := queryRow(ctx, repo, "SELECT "+stopColumns+" FROM stops WHERE stop_id = $1", scanStop, stopID)
+	if err != nil {
+		return stop, false, fmt.Errorf("look up stop %s: %w", stopID, err)
+	}
+	return stop, found, nil
+}
+
+// Stations lists stops that are not platforms of another stop, optionally only those whose name
+// contains search
+func (repo *Repository) Stations(ctx context.Context, search string) ([]Stop, error) {
+	stations, err := queryRows(
+		ctx, repo,
+		`SELECT `+stopColumns+` FROM stops
+		WHERE (location_type = 1 OR COALESCE(parent_station, '') = '')
+			AND ($1::text = '' OR stop_name ILIKE '%' || $1 || '%')
+		ORDER BY stop_name, stop_id`,
+		scanStop, search,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("list stations: %w", err)
+	}
+	return stations, nil
+}
@@ -0,0 +1,201 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// TripKey identifies a realtime trip, trip ids are reused from one service day to the next
+type TripKey struct {
+	TripID    string
+	StartDate string
+}
+
+// CurrentTrip is a row of trip_update_current
+type CurrentTrip struct {
+	TripKey
+	StartTime   string
+	DirectionID int16
+	ContentHash [32]byte
+}
+
+func (repo *Repository) CurrentTrips(ctx context.Context, feedID string) ([]CurrentTrip, error) {
+	trips, err := queryRows(
+		ctx, repo,
+		`SELECT trip_id, start_date, COALESCE(start_time, ''), COALESCE(direction_id, 0), content_hash
+		FROM trip_update_current WHERE feed_id = $1`,
+		func(row scanner) (CurrentTrip, error) {
+			trip := CurrentTrip{}
+			err := row.Scan(&trip.TripID, &trip.StartDate, &trip.StartTime, &trip.DirectionID, hashValue{&trip.ContentHash})
+			return trip, err
+		},
+		feedID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("load current trips for %s: %w", feedID, err)
+	}
+	return trips, nil
+}
+
+func (repo *Repository) UpsertCurrentTrips(
+	ctx context.Context, feedID string, snapshotId int64, changedAt time.Time, trips []CurrentTrip,
+) error {
+	if len(trips) == 0 {
+		return nil
+	}
+
+	tripIds := make([]string, len(trips))
+	startDates := make([]string, len(trips))
+	startTimes := make([]string, len(trips))
+	directionIds := make([]int16, len(trips))
+	hashes := make([][]byte, len(trips))
+	for i := range trips {
+		tripIds[i] = trips[i].TripID
+		startDates[i] = trips[i].StartDate
+		startTimes[i] = trips[i].StartTime
+		directionIds[i] = trips[i].DirectionID
+		hashes[i] = trips[i].ContentHash[:]
+	}
+
+	_, err := repo.exec(
+		ctx,
+		`INSERT INTO trip_update_current
+			(feed_id, trip_id, start_date, start_time, direction_id, content_hash, snapshot_id, changed_at)
+		SELECT $1, trip_id, start_date, start_time, direction_id, content_hash, $7, $8
+		FROM unnest($2::text[], $3::text[], $4::text[], $5::smallint[], $6::bytea[])
+			AS changed(trip_id, start_date, start_time, direction_id, content_hash)
+		ON CONFLICT (feed_id, trip_id, start_date) DO UPDATE SET
+			start_time = EXCLUDED.start_time,
+			direction_id = EXCLUDED.direction_id,
+			content_hash = EXCLUDED.content_hash,
+			snapshot_id = EXCLUDED.snapshot_id,
+			changed_at = EXCLUDED.changed_at`,
+		feedID, tripIds, startDates, startTimes, directionIds, hashes, snapshotId, changedAt,
+	)
+	if err != nil {
+		return fmt.Errorf("upsert trip_update_current: %w", err)
+	}
+	return nil
+}
+
+func (repo *Repository) DeleteCurrentTrips(ctx context.Context, feedID string, keys []TripKey) error {
+	if len(keys) == 0 {
+		return nil
+	}
+
+	tripIds := make([]string, len(keys))
+	startDates := make([]string, len(keys))
+	for i, key := range keys {
+		tripIds[i] = key.TripID
+		startDates[i] = key.StartDate
+	}
+
+	_, err := repo.exec(
+		ctx,
+		`DELETE FROM trip_update_current
+		WHERE feed_id = $1
+			AND (trip_id, start_date) IN (SELECT * FROM unnest($2::text[], $3::text[]))`,
+		feedID, tripIds, startDates,
+	)
+	if err != nil {
+		return fmt.Errorf("delete from trip_update_current: %w", err)
+	}
+	return nil
+}
+
+// Route is a row of the static routes table
+type Route struct {
+	ID        string
+	AgencyID  string
+	ShortName string
+	LongName  string
+	Type      int16
+	Color     string
+	TextColor string
+}
+
+func (repo *Repository) Routes(ctx context.Context) ([]Route, error) {
+	routes, err := queryRows(
+		ctx, repo,
+		`SELECT route_id, agency_id, route_short_name, route_long_name, route_type, route_color, route_text_color
+		FROM routes
+		ORDER BY route_sort_order NULLS LAST, route_id`,
+		func(row scanner) (Route, error) {
+			route := Route{}
+			err := row.Scan(
+				&route.ID,
+				nullString{&route.AgencyID},
+				nullString{&route.ShortName},
+				nullString{&route.LongName},
+				&route.Type,
+				nullString{&route.Color},
+				nullString{&route.TextColor},
+			)
+			return route, err
+		},
+	)
+	if err != nil {
+		return nil, fmt.Errorf("list routes: %w", err)
+	}
+	return routes, nil
+}
+
+// Train is a trip currently present in a realtime feed, matched to its scheduled trip where
+// possible, along with the next stop it is predicted to reach
+type Train struct {
+	FeedID      string
+	TripID      string
+	StartDate   string
+	DirectionID int16
+	RouteID     string
+	Headsign    string
+	ChangedAt   time.Time
+
+	NextStopID   string
+	NextStopName string
+	NextArrival  time.Time
+}
+
+// ActiveTrains lists the trains in trip_update_current, only those on routeID unless it is empty.
+// The next stop is the first one predicted at or after now.
+func (repo *Repository) ActiveTrains(ctx context.Context, routeID string, now time.Time) ([]Train, error) {
+	trains, err := queryRows(
+		ctx, repo,
+		`SELECT
+			cur.feed_id, cur.trip_id, cur.start_date, COALESCE(cur.direction_id, 0),
+			COALESCE(trip.route_id, ''), COALESCE(trip.trip_headsign, ''), cur.changed_at,
+			COALESCE(next_stop.stop_id, ''), COALESCE(stops.stop_name, ''), COALESCE(next_stop.at, 0)
+		FROM trip_update_current cur
+		LEFT JOIN LATERAL (
+			SELECT route_id, trip_headsign FROM trips WHERE trips.rt_trip_id = cur.trip_id LIMIT 1
+		) trip ON TRUE
+		LEFT JOIN LATERAL (
+			SELECT stu.stop_id, GREATEST(stu.arrival_time, stu.departure_time) AS at
+			FROM trip_update_stop_time_events stu
+			WHERE stu.snapshot_id = cur.snapshot_id
+				AND stu.trip_id = cur.trip_id
+				AND stu.start_date = cur.start_date
+				AND GREATEST(stu.arrival_time, stu.departure_time) >= $2
+			ORDER BY at
+			LIMIT 1
+		) next_stop ON TRUE
+		LEFT JOIN stops ON stops.stop_id = next_stop.stop_id
+		WHERE $1::text = '' OR trip.route_id = $1
+		ORDER BY trip.route_id, cur.direction_id, next_stop.at`,
+		func(row scanner) (Train, error) {
+			train := Train{}
+			err := row.Scan(
+				&train.FeedID, &train.TripID, &train.StartDate, &train.DirectionID,
+				&train.RouteID, &train.Headsign, &train.ChangedAt,
+				&train.NextStopID, &train.NextStopName, unixTime{&train.NextArrival},
+			)
+			return train, err
+		},
+		routeID, now.Unix(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("list active trains: %w", err)
+	}
+	return trains, nil
+}
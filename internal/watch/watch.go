@@ -0,0 +1,44 @@
+package watch
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"time"
+)
+
+const clearScreen = "\033[H\033[2J"
+
+// RenderFunc writes one refresh of the watched output
+type RenderFunc func(ctx context.Context, out io.Writer) error
+
+// Run renders every interval until ctx is done, replacing the previous output on the terminal.
+// Render errors are shown in place of the output rather than ending the watch.
+func Run(ctx context.Context, out io.Writer, interval time.Duration, render RenderFunc) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		// Rendered up front so a slow fetch doesn't leave the screen blank
+		var buffer bytes.Buffer
+		if err := render(ctx, &buffer); err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			fmt.Fprintf(&buffer, "error: %v\n", err)
+		}
+
+		fmt.Fprint(out, clearScreen)
+		fmt.Fprintf(out, "Every %s, updated %s (Ctrl-C to stop)\n\n", interval, time.Now().Format("15:04:05"))
+		if _, err := buffer.WriteTo(out); err != nil {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
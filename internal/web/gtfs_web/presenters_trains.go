@@ -2,7 +2,10 @@ package gtfs_web
 
 import (
 	"fmt"
+	"strconv"
 	"time"
+
+	"tarediiran-industries.com/gtfs-services/internal/repository"
 )
 
 type TrainDTO struct {
@@ -13,6 +16,38 @@ type TrainDTO struct {
 	Status    string
 }
 
+func TrainFromRepository(train repository.Train) TrainDTO {
+	direction := train.Headsign
+	if direction == "" {
+		direction = strconv.Itoa(int(train.DirectionID))
+	}
+
+	status := "NO_PREDICTIONS"
+	if train.NextStopID != "" {
+		next := train.NextStopName
+		if next == "" {
+			next = train.NextStopID
+		}
+		status = "TO " + next
+	}
+
+	return TrainDTO{
+		Route:     train.RouteID,
+		Direction: direction,
+		TripID:    train.TripID,
+		LastSeen:  train.ChangedAt,
+		Status:    status,
+	}
+}
+
+func RouteIDs(routes []repository.Route) []string {
+	out := make([]string, 0, len(routes))
+	for _, route := range routes {
+		out = append(out, route.ID)
+	}
+	return out
+}
+
 func BuildTrainsPageVM(routes []string, selected string, pollSeconds int) TrainsPageVM {
 	out := make([]string, 0, len(routes)+1)
 	out = append(out, "ALL")
@@ -8,8 +8,12 @@ import (
 func (server *GtfsWebServer) handleTrainsPage(writer http.ResponseWriter, request *http.Request) {
 	query := ParseTrainsQuery(request.URL.Query())
 
-	routes := MockRoutes() // Replace with real routes getter later
-	viewmodel := BuildTrainsPageVM(routes, query.Route, 2)
+	routes, err := server.repo.Routes(request.Context())
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	viewmodel := BuildTrainsPageVM(RouteIDs(routes), query.Route, 2)
 
 	writer.Header().Set("Content-Type", "text;html; charset=utf-8")
 	if err := server.renderer.Render(writer, "layout.html", viewmodel); err != nil {
@@ -21,8 +25,21 @@ func (server *GtfsWebServer) handleTrainsPage(writer http.ResponseWriter, reques
 func (server *GtfsWebServer) handleTrainsPartial(writer http.ResponseWriter, request *http.Request) {
 	query := ParseTrainsQuery(request.URL.Query())
 
+	route := query.Route
+	if route == "ALL" {
+		route = ""
+	}
+
 	now := time.Now()
-	trains := MockTrains(query.Route, now)
+	active, err := server.repo.ActiveTrains(request.Context(), route, now)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	trains := make([]TrainDTO, 0, len(active))
+	for _, train := range active {
+		trains = append(trains, TrainFromRepository(train))
+	}
 	viewmodel := BuildTrainsTableVM(query.Route, trains, now)
 
 	writer.Header().Set("Content-Type", "text;html; charset=utf-8")
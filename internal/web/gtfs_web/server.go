@@ -10,19 +10,25 @@ import (
 	"github.com/go-chi/chi/v5/middleware"
 
 	database "tarediiran-industries.com/gtfs-services/internal/db"
+	"tarediiran-industries.com/gtfs-services/internal/repository"
 )
 
 type GtfsWebServer struct {
 	db       *database.Database
+	repo     *repository.Repository
 	server   *http.Server
 	renderer *Renderer
 }
 
 func NewGtfsWebServer(ctx context.Context, listenAddr string, databaseUrl string) (*GtfsWebServer, error) {
-	db, err := database.NewDatabaseConnection(ctx, databaseUrl)
+	db, err := database.NewDatabaseConnection(ctx, databaseUrl, database.PoolOptions{})
 	if err != nil {
 		return nil, err
 	}
+	if err := db.RequireSchema(ctx); err != nil {
+		db.Close()
+		return nil, err
+	}
 	renderer, err := NewRenderer()
 	if err != nil {
 		return nil, err
@@ -41,6 +47,7 @@ func NewGtfsWebServer(ctx context.Context, listenAddr string, databaseUrl string
 
 	server := &GtfsWebServer{
 		db:       db,
+		repo:     repository.New(db),
 		server:   httpServer,
 		renderer: renderer,
 	}
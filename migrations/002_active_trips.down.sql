@@ -0,0 +1,4 @@
+DROP VIEW IF EXISTS view_train_trips;
+DROP TABLE IF EXISTS trip_update_stop_time_events;
+DROP TABLE IF EXISTS trip_update_events;
+DROP TABLE IF EXISTS feed_snapshots;
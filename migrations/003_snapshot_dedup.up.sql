@@ -0,0 +1,13 @@
+-- Content hashes of snapshots already ingested per feed, so deduplication survives restarts of the
+-- realtime ingester. Only populated for feeds with dedup.persist enabled.
+CREATE TABLE IF NOT EXISTS feed_snapshot_seen (
+    feed_id TEXT NOT NULL,
+    content_hash BYTEA NOT NULL,
+    header_timestamp BIGINT,
+    seen_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+
+    PRIMARY KEY (feed_id, content_hash)
+);
+
+CREATE INDEX IF NOT EXISTS feed_snapshot_seen_recent_idx
+    ON feed_snapshot_seen (feed_id, seen_at DESC);
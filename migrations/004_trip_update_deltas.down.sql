@@ -0,0 +1,9 @@
+DROP VIEW IF EXISTS view_trip_stop_times_current;
+DROP TABLE IF EXISTS trip_update_current;
+DROP INDEX IF EXISTS trip_update_stop_time_events_trip_idx;
+
+ALTER TABLE trip_update_stop_time_events
+    DROP COLUMN IF EXISTS trip_id,
+    DROP COLUMN IF EXISTS start_date;
+
+ALTER TABLE feed_snapshots DROP COLUMN IF EXISTS feed_id;
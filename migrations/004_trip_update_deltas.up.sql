@@ -0,0 +1,49 @@
+-- Trip updates are stored as a change log: trip_update_events only gets a row (and its stop times)
+-- when a trip's predictions differ from what trip_update_current holds for it. Polls where nothing
+-- changed still get a feed_snapshots row.
+ALTER TABLE feed_snapshots ADD COLUMN IF NOT EXISTS feed_id TEXT;
+
+-- Stop times used to hang off the snapshot only, now that a snapshot holds just the changed trips
+-- they need to say which trip they belong to
+ALTER TABLE trip_update_stop_time_events
+    ADD COLUMN IF NOT EXISTS trip_id TEXT,
+    ADD COLUMN IF NOT EXISTS start_date TEXT;
+
+CREATE INDEX IF NOT EXISTS trip_update_stop_time_events_trip_idx
+    ON trip_update_stop_time_events (trip_id, start_date, snapshot_id);
+
+-- Latest state of every trip currently present in each realtime feed. content_hash covers the
+-- trip's start time, direction and stop time predictions; snapshot_id is the snapshot of the last
+-- change, which is where its stop times live in the change log.
+CREATE TABLE IF NOT EXISTS trip_update_current (
+    feed_id TEXT NOT NULL,
+    trip_id TEXT NOT NULL,
+    start_date TEXT NOT NULL,
+    start_time TEXT,
+    direction_id SMALLINT,
+    content_hash BYTEA NOT NULL,
+    snapshot_id BIGINT NOT NULL,
+    changed_at TIMESTAMPTZ NOT NULL,
+
+    PRIMARY KEY (feed_id, trip_id, start_date),
+
+    CONSTRAINT snapshot_id
+        FOREIGN KEY (snapshot_id) REFERENCES feed_snapshots(snapshot_id)
+        ON DELETE CASCADE
+);
+
+CREATE OR REPLACE VIEW view_trip_stop_times_current AS
+SELECT
+    cur.feed_id,
+    cur.trip_id,
+    cur.start_date,
+    cur.direction_id,
+    stu.stop_id,
+    stu.arrival_time,
+    stu.departure_time,
+    cur.changed_at
+FROM trip_update_current cur
+JOIN trip_update_stop_time_events stu
+    ON stu.snapshot_id = cur.snapshot_id
+    AND stu.trip_id = cur.trip_id
+    AND stu.start_date = cur.start_date;
@@ -0,0 +1,121 @@
+-- Moves the realtime tables back to the unpartitioned layout of migration 004. Rollups are dropped.
+DROP FUNCTION IF EXISTS gtfs_rt_rollup_day(DATE);
+DROP TABLE IF EXISTS route_daily_summary;
+
+DROP VIEW IF EXISTS view_trip_stop_times_current;
+DROP VIEW IF EXISTS view_train_trips;
+
+ALTER TABLE feed_snapshots RENAME TO feed_snapshots_partitioned;
+ALTER INDEX feed_snapshots_pkey RENAME TO feed_snapshots_partitioned_pkey;
+ALTER TABLE trip_update_events RENAME TO trip_update_events_partitioned;
+ALTER TABLE trip_update_stop_time_events RENAME TO trip_update_stop_time_events_partitioned;
+DROP INDEX IF EXISTS trip_update_stop_time_events_trip_idx;
+
+CREATE TABLE feed_snapshots (
+    snapshot_id BIGINT GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+    fetched_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    feed_id TEXT
+);
+
+CREATE TABLE trip_update_events (
+    trip_id TEXT,
+    start_date TEXT,
+    start_time TEXT,
+    direction_id SMALLINT,
+    snapshot_id BIGINT,
+
+    insertion_time TIMESTAMP NOT NULL DEFAULT NOW(),
+
+    CONSTRAINT snapshot_id
+        FOREIGN KEY (snapshot_id) REFERENCES feed_snapshots(snapshot_id)
+        ON DELETE CASCADE
+);
+
+CREATE TABLE trip_update_stop_time_events (
+    stop_id TEXT,
+    arrival_time BIGINT,
+    departure_time BIGINT,
+    snapshot_id BIGINT,
+    trip_id TEXT,
+    start_date TEXT,
+
+    CONSTRAINT snapshot_id
+        FOREIGN KEY (snapshot_id) REFERENCES feed_snapshots(snapshot_id)
+        ON DELETE CASCADE
+);
+
+CREATE INDEX trip_update_stop_time_events_trip_idx
+    ON trip_update_stop_time_events (trip_id, start_date, snapshot_id);
+
+INSERT INTO feed_snapshots (snapshot_id, fetched_at, feed_id)
+OVERRIDING SYSTEM VALUE
+SELECT snapshot_id, fetched_at, feed_id
+FROM feed_snapshots_partitioned;
+
+SELECT setval(
+    pg_get_serial_sequence('feed_snapshots', 'snapshot_id'),
+    COALESCE((SELECT MAX(snapshot_id) FROM feed_snapshots), 0) + 1,
+    false
+);
+
+INSERT INTO trip_update_events (trip_id, start_date, start_time, direction_id, snapshot_id, insertion_time)
+SELECT trip_id, start_date, start_time, direction_id, snapshot_id, insertion_time
+FROM trip_update_events_partitioned;
+
+INSERT INTO trip_update_stop_time_events (stop_id, arrival_time, departure_time, snapshot_id, trip_id, start_date)
+SELECT stop_id, arrival_time, departure_time, snapshot_id, trip_id, start_date
+FROM trip_update_stop_time_events_partitioned;
+
+DROP TABLE trip_update_stop_time_events_partitioned;
+DROP TABLE trip_update_events_partitioned;
+DROP TABLE feed_snapshots_partitioned;
+DROP FUNCTION IF EXISTS gtfs_rt_ensure_partitions(DATE, DATE);
+
+DELETE FROM trip_update_current
+WHERE snapshot_id NOT IN (SELECT snapshot_id FROM feed_snapshots);
+
+ALTER TABLE trip_update_current
+    ADD CONSTRAINT snapshot_id
+    FOREIGN KEY (snapshot_id) REFERENCES feed_snapshots(snapshot_id)
+    ON DELETE CASCADE;
+
+CREATE OR REPLACE VIEW view_train_trips AS
+WITH latest AS (
+    SELECT DISTINCT ON (trip_id, start_date)
+        trip_id,
+        start_date,
+        start_time,
+        direction_id,
+        snapshot_id,
+        insertion_time
+    FROM trip_update_events
+    ORDER BY
+        trip_id,
+        start_date,
+        insertion_time DESC,
+        snapshot_id DESC
+)
+SELECT
+    latest.trip_id,
+    latest.start_date,
+    latest.direction_id as rt_direction_id,
+    trips.route_id,
+    trips.trip_headsign
+FROM latest
+LEFT JOIN trips ON trips.rt_trip_id = latest.trip_id;
+
+CREATE OR REPLACE VIEW view_trip_stop_times_current AS
+SELECT
+    cur.feed_id,
+    cur.trip_id,
+    cur.start_date,
+    cur.direction_id,
+    stu.stop_id,
+    stu.arrival_time,
+    stu.departure_time,
+    cur.changed_at
+FROM trip_update_current cur
+JOIN trip_update_stop_time_events stu
+    ON stu.snapshot_id = cur.snapshot_id
+    AND stu.trip_id = cur.trip_id
+    AND stu.start_date = cur.start_date;
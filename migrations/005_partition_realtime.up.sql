@@ -0,0 +1,237 @@
+-- The realtime tables are partitioned by day on the snapshot's fetched_at (UTC), so retention is a
+-- matter of dropping whole partitions rather than deleting rows. See `gtfs-ctl db prune` and the
+-- [retention] section of the ingest config. Existing rows are moved into daily partitions.
+--
+-- Partitioned tables need the partition key in every unique constraint, so snapshot ids come from a
+-- plain sequence and the event tables no longer carry foreign keys to feed_snapshots. Dropping a
+-- day drops the matching partition of every table together instead.
+
+DROP VIEW IF EXISTS view_trip_stop_times_current;
+DROP VIEW IF EXISTS view_train_trips;
+ALTER TABLE trip_update_current DROP CONSTRAINT IF EXISTS snapshot_id;
+
+ALTER TABLE feed_snapshots RENAME TO feed_snapshots_unpartitioned;
+ALTER INDEX feed_snapshots_pkey RENAME TO feed_snapshots_unpartitioned_pkey;
+ALTER TABLE trip_update_events RENAME TO trip_update_events_unpartitioned;
+ALTER TABLE trip_update_stop_time_events RENAME TO trip_update_stop_time_events_unpartitioned;
+DROP INDEX IF EXISTS trip_update_stop_time_events_trip_idx;
+
+CREATE SEQUENCE IF NOT EXISTS feed_snapshot_ids AS BIGINT;
+SELECT setval(
+    'feed_snapshot_ids',
+    COALESCE((SELECT MAX(snapshot_id) FROM feed_snapshots_unpartitioned), 0) + 1,
+    false
+);
+
+CREATE TABLE feed_snapshots (
+    snapshot_id BIGINT NOT NULL DEFAULT nextval('feed_snapshot_ids'),
+    feed_id TEXT,
+    fetched_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+
+    PRIMARY KEY (snapshot_id, fetched_at)
+) PARTITION BY RANGE (fetched_at);
+
+ALTER SEQUENCE feed_snapshot_ids OWNED BY feed_snapshots.snapshot_id;
+
+CREATE TABLE trip_update_events (
+    trip_id TEXT,
+    start_date TEXT,
+    start_time TEXT,
+    direction_id SMALLINT,
+    snapshot_id BIGINT,
+
+    insertion_time TIMESTAMP NOT NULL DEFAULT NOW(),
+    fetched_at TIMESTAMPTZ NOT NULL
+) PARTITION BY RANGE (fetched_at);
+
+CREATE INDEX trip_update_events_trip_idx
+    ON trip_update_events (trip_id, start_date, insertion_time DESC);
+
+CREATE TABLE trip_update_stop_time_events (
+    stop_id TEXT,
+    arrival_time BIGINT,
+    departure_time BIGINT,
+    snapshot_id BIGINT,
+    trip_id TEXT,
+    start_date TEXT,
+
+    fetched_at TIMESTAMPTZ NOT NULL
+) PARTITION BY RANGE (fetched_at);
+
+CREATE INDEX trip_update_stop_time_events_trip_idx
+    ON trip_update_stop_time_events (trip_id, start_date, snapshot_id);
+
+-- Creates the daily partitions of every realtime table covering first_day..last_day (UTC) that
+-- don't exist yet, returning how many were created. The ingester calls this ahead of time.
+CREATE OR REPLACE FUNCTION gtfs_rt_ensure_partitions(first_day DATE, last_day DATE) RETURNS INTEGER AS $$
+DECLARE
+    partition_day DATE;
+    parent TEXT;
+    partition_name TEXT;
+    created INTEGER := 0;
+BEGIN
+    FOR partition_day IN SELECT generate_series(first_day, last_day, INTERVAL '1 day')::DATE LOOP
+        FOREACH parent IN ARRAY ARRAY['feed_snapshots', 'trip_update_events', 'trip_update_stop_time_events'] LOOP
+            partition_name := parent || '_p' || to_char(partition_day, 'YYYYMMDD');
+            IF to_regclass(partition_name) IS NULL THEN
+                EXECUTE format(
+                    'CREATE TABLE %I PARTITION OF %I FOR VALUES FROM (%L) TO (%L)',
+                    partition_name,
+                    parent,
+                    partition_day::TIMESTAMP AT TIME ZONE 'UTC',
+                    (partition_day + 1)::TIMESTAMP AT TIME ZONE 'UTC'
+                );
+                created := created + 1;
+            END IF;
+        END LOOP;
+    END LOOP;
+    RETURN created;
+END;
+$$ LANGUAGE plpgsql;
+
+SELECT gtfs_rt_ensure_partitions(
+    COALESCE(
+        (SELECT (MIN(fetched_at) AT TIME ZONE 'UTC')::DATE FROM feed_snapshots_unpartitioned),
+        (NOW() AT TIME ZONE 'UTC')::DATE
+    ),
+    (NOW() AT TIME ZONE 'UTC')::DATE + 2
+);
+
+INSERT INTO feed_snapshots (snapshot_id, feed_id, fetched_at)
+SELECT snapshot_id, feed_id, fetched_at
+FROM feed_snapshots_unpartitioned;
+
+INSERT INTO trip_update_events (
+    trip_id, start_date, start_time, direction_id, snapshot_id, insertion_time, fetched_at
+)
+SELECT e.trip_id, e.start_date, e.start_time, e.direction_id, e.snapshot_id, e.insertion_time, s.fetched_at
+FROM trip_update_events_unpartitioned e
+JOIN feed_snapshots_unpartitioned s ON s.snapshot_id = e.snapshot_id;
+
+INSERT INTO trip_update_stop_time_events (
+    stop_id, arrival_time, departure_time, snapshot_id, trip_id, start_date, fetched_at
+)
+SELECT e.stop_id, e.arrival_time, e.departure_time, e.snapshot_id, e.trip_id, e.start_date, s.fetched_at
+FROM trip_update_stop_time_events_unpartitioned e
+JOIN feed_snapshots_unpartitioned s ON s.snapshot_id = e.snapshot_id;
+
+DROP TABLE trip_update_stop_time_events_unpartitioned;
+DROP TABLE trip_update_events_unpartitioned;
+DROP TABLE feed_snapshots_unpartitioned;
+
+-- Per-route daily rollup kept after a day's partitions are dropped. An arrival counts as on time
+-- when the last prediction made for it was between 1 minute early and 5 minutes late.
+CREATE TABLE IF NOT EXISTS route_daily_summary (
+    summary_date DATE NOT NULL,
+    route_id TEXT NOT NULL,
+    trips_observed INTEGER NOT NULL,
+    arrivals_observed INTEGER NOT NULL,
+    arrivals_on_time INTEGER NOT NULL,
+    mean_delay_seconds DOUBLE PRECISION,
+    rolled_up_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+
+    PRIMARY KEY (summary_date, route_id)
+);
+
+CREATE OR REPLACE FUNCTION gtfs_rt_rollup_day(rollup_day DATE) RETURNS INTEGER AS $$
+DECLARE
+    rolled_up INTEGER;
+BEGIN
+    INSERT INTO route_daily_summary (
+        summary_date, route_id, trips_observed, arrivals_observed, arrivals_on_time, mean_delay_seconds
+    )
+    WITH final_predictions AS (
+        -- The last prediction made for a stop is the closest thing we have to the actual arrival
+        SELECT DISTINCT ON (stu.trip_id, stu.start_date, stu.stop_id)
+            stu.trip_id,
+            stu.start_date,
+            stu.stop_id,
+            stu.arrival_time
+        FROM trip_update_stop_time_events stu
+        WHERE stu.fetched_at >= rollup_day::TIMESTAMP AT TIME ZONE 'UTC'
+            AND stu.fetched_at < (rollup_day + 1)::TIMESTAMP AT TIME ZONE 'UTC'
+            AND stu.arrival_time > 0
+            AND stu.start_date ~ '^[0-9]{8}$'
+        ORDER BY stu.trip_id, stu.start_date, stu.stop_id, stu.fetched_at DESC
+    ),
+    delays AS (
+        -- One realtime trip id can match a trip in several services, keep the closest schedule
+        SELECT DISTINCT ON (fp.trip_id, fp.start_date, fp.stop_id)
+            trips.route_id,
+            fp.trip_id,
+            fp.start_date,
+            fp.arrival_time - EXTRACT(EPOCH FROM
+                (to_date(fp.start_date, 'YYYYMMDD') + st.arrival_time::INTERVAL) AT TIME ZONE tz.name
+            ) AS delay_seconds
+        FROM final_predictions fp
+        JOIN trips ON trips.rt_trip_id = fp.trip_id
+        JOIN stop_times st ON st.trip_id = trips.trip_id AND st.stop_id = fp.stop_id
+        CROSS JOIN (
+            SELECT COALESCE((SELECT agency_timezone FROM agency LIMIT 1), 'UTC') AS name
+        ) tz
+        ORDER BY fp.trip_id, fp.start_date, fp.stop_id, ABS(fp.arrival_time - EXTRACT(EPOCH FROM
+            (to_date(fp.start_date, 'YYYYMMDD') + st.arrival_time::INTERVAL) AT TIME ZONE tz.name
+        ))
+    )
+    SELECT
+        rollup_day,
+        delays.route_id,
+        COUNT(DISTINCT (delays.trip_id, delays.start_date)),
+        COUNT(*),
+        COUNT(*) FILTER (WHERE delays.delay_seconds BETWEEN -60 AND 300),
+        AVG(delays.delay_seconds)
+    FROM delays
+    WHERE delays.route_id IS NOT NULL
+    GROUP BY delays.route_id
+    ON CONFLICT (summary_date, route_id) DO UPDATE SET
+        trips_observed = EXCLUDED.trips_observed,
+        arrivals_observed = EXCLUDED.arrivals_observed,
+        arrivals_on_time = EXCLUDED.arrivals_on_time,
+        mean_delay_seconds = EXCLUDED.mean_delay_seconds,
+        rolled_up_at = NOW();
+
+    GET DIAGNOSTICS rolled_up = ROW_COUNT;
+    RETURN rolled_up;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE VIEW view_train_trips AS
+WITH latest AS (
+    SELECT DISTINCT ON (trip_id, start_date)
+        trip_id,
+        start_date,
+        start_time,
+        direction_id,
+        snapshot_id,
+        insertion_time
+    FROM trip_update_events
+    ORDER BY
+        trip_id,
+        start_date,
+        insertion_time DESC,
+        snapshot_id DESC
+)
+SELECT
+    latest.trip_id,
+    latest.start_date,
+    latest.direction_id as rt_direction_id,
+    trips.route_id,
+    trips.trip_headsign
+FROM latest
+LEFT JOIN trips ON trips.rt_trip_id = latest.trip_id;
+
+CREATE OR REPLACE VIEW view_trip_stop_times_current AS
+SELECT
+    cur.feed_id,
+    cur.trip_id,
+    cur.start_date,
+    cur.direction_id,
+    stu.stop_id,
+    stu.arrival_time,
+    stu.departure_time,
+    cur.changed_at
+FROM trip_update_current cur
+JOIN trip_update_stop_time_events stu
+    ON stu.snapshot_id = cur.snapshot_id
+    AND stu.trip_id = cur.trip_id
+    AND stu.start_date = cur.start_date;
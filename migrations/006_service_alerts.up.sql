@@ -0,0 +1,21 @@
+-- Service alerts currently published by each realtime feed. Alerts are replaced as a set every
+-- time a snapshot is written, so an alert missing from the latest snapshot is gone from here too.
+CREATE TABLE IF NOT EXISTS service_alerts (
+    feed_id TEXT NOT NULL,
+    alert_id TEXT NOT NULL,
+    -- Union of the alert's active periods, NULL on either side means open-ended
+    active_start TIMESTAMPTZ,
+    active_end TIMESTAMPTZ,
+    cause TEXT,
+    effect TEXT,
+    header_text TEXT,
+    description_text TEXT,
+    route_ids TEXT[] NOT NULL DEFAULT '{}',
+    stop_ids TEXT[] NOT NULL DEFAULT '{}',
+    snapshot_id BIGINT NOT NULL,
+    updated_at TIMESTAMPTZ NOT NULL,
+
+    PRIMARY KEY (feed_id, alert_id)
+);
+
+CREATE INDEX IF NOT EXISTS service_alerts_route_idx ON service_alerts USING GIN (route_ids);
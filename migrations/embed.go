@@ -0,0 +1,8 @@
+// Package migrations embeds the SQL schema migrations so the binaries can apply and check them
+// without the external migrate CLI.
+package migrations
+
+import "embed"
+
+//go:embed *.sql
+var FS embed.FS